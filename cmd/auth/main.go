@@ -2,15 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
+	// time zones of user profiles are checked against it, the image has none
+	_ "time/tzdata"
 
+	"github.com/gerladeno/authorization-service/pkg/clients"
 	"github.com/gerladeno/authorization-service/pkg/common"
 
 	migrate "github.com/rubenv/sql-migrate"
@@ -19,10 +26,16 @@ import (
 
 	"github.com/gerladeno/authorization-service/pkg/authentication"
 	"github.com/gerladeno/authorization-service/pkg/authorization"
+	"github.com/gerladeno/authorization-service/pkg/extauthz"
+	"github.com/gerladeno/authorization-service/pkg/oauth"
+	"github.com/gerladeno/authorization-service/pkg/policy"
 	"github.com/gerladeno/authorization-service/pkg/rest"
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/gerladeno/authorization-service/pkg/revocation"
+	"github.com/gerladeno/authorization-service/pkg/verification"
+	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 )
 
 const httpPort = 3000
@@ -45,29 +58,244 @@ func main() {
 		flashCallID     = os.Getenv("FLASHCALL_ID")
 		flashCallSecret = os.Getenv("FLASHCALL_SECRET")
 		signingKey      = os.Getenv("PRIVATE_SIGNING_KEY")
+		signingKeysDir  = os.Getenv("SIGNING_KEYS_DIR")
+		extAuthzAddr    = os.Getenv("EXTAUTHZ_GRPC_ADDR")
+		bootstrapClient = os.Getenv("API_CLIENT_BOOTSTRAP")
+		signingPepper   = os.Getenv("API_CLIENT_SIGNING_PEPPER")
+		tlsCertFile     = os.Getenv("TLS_CERT_FILE")
+		tlsKeyFile      = os.Getenv("TLS_KEY_FILE")
+		tlsClientCAFile = os.Getenv("TLS_CLIENT_CA_FILE")
+		mtlsClients     = os.Getenv("MTLS_ALLOWED_CLIENTS")
+		impersonators   = os.Getenv("IMPERSONATORS")
+		policyDir       = os.Getenv("POLICY_DIR")
+		policyCacheTTL  = getEnvDuration(log, "POLICY_CACHE_TTL", 30*time.Second)
+		keysReload      = getEnvDuration(log, "SIGNING_KEYS_RELOAD_INTERVAL", time.Minute)
+		keysRotation    = getEnvDuration(log, "SIGNING_KEYS_ROTATION_INTERVAL", 0)
 		host            = "localhost"
 		pgDSN           = os.Getenv("PG_DSN")
+		pgMaxConns      = getEnvInt(log, "PG_MAX_CONNS", 0)
+		profileStore    = os.Getenv("PROFILE_STORE")
+		redisURL        = os.Getenv("REDIS_URL")
+		challengeStore  = os.Getenv("VERIFICATION_STORE")
+		smsGatewayURL   = os.Getenv("SMS_GATEWAY_URL")
+		smsGatewayToken = os.Getenv("SMS_GATEWAY_TOKEN")
+		smsCodeLength   = getEnvInt(log, "SMS_CODE_LENGTH", 6)
+		failoverChain   = os.Getenv("AUTH_FAILOVER_CHAIN")
+		defaultChannel  = os.Getenv("AUTH_DEFAULT_CHANNEL")
+		failoverConfig  = authentication.FailoverConfig{
+			FailureThreshold: getEnvInt(log, "AUTH_FAILOVER_THRESHOLD", 3),
+			OpenFor:          getEnvDuration(log, "AUTH_FAILOVER_OPEN_FOR", time.Minute),
+		}
+		tokenConfig = authorization.Config{
+			Issuer:     getEnv("TOKEN_ISSUER", "authorization-service"),
+			AccessTTL:  getEnvDuration(log, "ACCESS_TOKEN_TTL", 15*time.Minute),
+			RefreshTTL: getEnvDuration(log, "REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		}
+		userCacheConfig = profilestore.CacheConfig{
+			Size:        getEnvInt(log, "USER_CACHE_SIZE", 10000),
+			TTL:         getEnvDuration(log, "USER_CACHE_TTL", time.Minute),
+			NegativeTTL: getEnvDuration(log, "USER_CACHE_NEGATIVE_TTL", 5*time.Second),
+		}
+		verifyConfig = verification.Config{
+			TTL:            getEnvDuration(log, "VERIFICATION_CODE_TTL", 5*time.Minute),
+			MaxAttempts:    getEnvInt(log, "VERIFICATION_MAX_ATTEMPTS", 3),
+			ResendCooldown: getEnvDuration(log, "VERIFICATION_RESEND_COOLDOWN", 2*time.Minute),
+		}
 	)
 	if common.RunsInContainer() {
 		pgDSN = strings.ReplaceAll(pgDSN, "localhost:5433", "auth_pg:5432")
 	}
 	ctx := context.Background()
-	pg, err := profilestore.GetPGStore(ctx, log, pgDSN)
+	store := withUserCache(log, getProfileStore(ctx, log, profileStore, pgDSN, pgMaxConns), userCacheConfig, redisURL)
+	var challenges verification.Store = store
+	var smsCodes authentication.CodeStore = store
+	if challengeStore == "memory" {
+		challenges = verification.NewMemoryStore(ctx)
+		smsCodes = authentication.NewMemoryCodeStore(ctx)
+	}
+	sessions := verification.New(log, challenges, verifyConfig)
+	channels := map[string]authorization.Authenticator{
+		authorization.ChannelFlashCall: authentication.New(log, flashCallHost, flashCallID, flashCallSecret),
+	}
+	switch smsGatewayURL {
+	case "":
+		log.Info("SMS_GATEWAY_URL not set, sms channel disabled")
+	case "log":
+		channels[authorization.ChannelSMS] = authentication.NewSMS(
+			log, authentication.NewLogGateway(log), smsCodes, smsCodeLength, verifyConfig.TTL)
+	default:
+		channels[authorization.ChannelSMS] = authentication.NewSMS(
+			log, authentication.NewHTTPGateway(smsGatewayURL, smsGatewayToken), smsCodes, smsCodeLength, verifyConfig.TTL)
+	}
+	if failoverChain != "" {
+		failover := authentication.NewFailover(log, failoverConfig)
+		for _, name := range strings.Split(failoverChain, ",") {
+			provider, ok := channels[strings.TrimSpace(name)]
+			if !ok {
+				log.Panicf("unknown channel %q in AUTH_FAILOVER_CHAIN", name)
+			}
+			failover.Add(strings.TrimSpace(name), provider)
+		}
+		channels[authorization.ChannelAuto] = failover
+	}
+	var keys *authorization.Keyring
+	var err error
+	if signingKeysDir != "" {
+		if keys, err = authorization.LoadKeyring(log, signingKeysDir); err != nil {
+			panic(fmt.Errorf("err loading signing keys: %w", err))
+		}
+		go keys.Watch(ctx, keysReload, keysRotation, tokenConfig.AccessTTL)
+	} else {
+		keys = authorization.MustGetKeyring(log, signingKey)
+	}
+	revocations, err := revocation.NewChecker(ctx, log, store, revocation.Config{
+		TokenTTL:        tokenConfig.AccessTTL,
+		SyncInterval:    getEnvDuration(log, "REVOCATION_SYNC_INTERVAL", 5*time.Second),
+		RebuildInterval: time.Hour,
+	})
+	if err != nil {
+		panic(fmt.Errorf("err loading revocations: %w", err))
+	}
+	go revocations.Run(ctx)
+	auth := authorization.New(log, channels, sessions, store, store, revocations, keys, tokenConfig)
+	if defaultChannel != "" {
+		auth = auth.WithDefaultChannel(defaultChannel)
+	}
+	tenants, err := authorization.LoadTenants(ctx, log, store, signingKeysDir)
+	if err != nil {
+		panic(fmt.Errorf("err loading tenants: %w", err))
+	}
+	go tenants.Watch(ctx, getEnvDuration(log, "TENANTS_RELOAD_INTERVAL", time.Minute), tokenConfig.AccessTTL)
+	auth = auth.WithRoles(store).
+		WithImpersonation(strings.FieldsFunc(impersonators, func(r rune) bool { return r == ',' }), store).
+		WithTenants(tenants)
+	apiClients := clients.NewRegistry(log, store)
+	if mtlsClients != "" {
+		certificates, err := clients.ParseCertificates(mtlsClients)
+		if err != nil {
+			panic(err)
+		}
+		apiClients = apiClients.WithCertificates(certificates)
+	}
+	if signingPepper != "" {
+		apiClients = apiClients.WithSigningPepper([]byte(signingPepper))
+	} else {
+		log.Info("API_CLIENT_SIGNING_PEPPER not set, signed requests of api clients disabled")
+	}
+	if bootstrapClient != "" {
+		id, secret, ok := strings.Cut(bootstrapClient, ":")
+		if !ok || id == "" || secret == "" {
+			log.Panic("API_CLIENT_BOOTSTRAP should be id:secret")
+		}
+		if err = apiClients.Bootstrap(ctx, id, secret); err != nil {
+			panic(fmt.Errorf("err creating bootstrap client: %w", err))
+		}
+	}
+	oauthServer := oauth.New(log, store, auth).
+		WithVerificationURI(getEnv("DEVICE_VERIFICATION_URI", strings.TrimSuffix(tokenConfig.Issuer, "/")+"/oauth/device"))
+	policies, err := policy.Load(log, policyDir, policyCacheTTL)
+	if err != nil {
+		panic(fmt.Errorf("err loading policies: %w", err))
+	}
+	router := rest.NewRouter(log, auth, store, apiClients, oauthServer, policies, host, version)
+	var grpcServer *grpc.Server
+	if extAuthzAddr != "" {
+		grpcServer = grpc.NewServer()
+		extauthz.New(log, auth).Register(grpcServer)
+		go startGRPCServer(grpcServer, extAuthzAddr, log)
+	}
+	tlsConfig, err := getTLSConfig(tlsClientCAFile)
+	if err != nil {
+		panic(fmt.Errorf("err configuring tls: %w", err))
+	}
+	if err = startServer(ctx, router, log, tlsConfig, tlsCertFile, tlsKeyFile); err != nil {
+		log.Fatal(err)
+	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	store.Close()
+}
+
+// getProfileStore keeps everything in postgres unless kind is memory or the dsn is of SQLite.
+func getProfileStore(ctx context.Context, log *logrus.Logger, kind, dsn string, maxConns int) profilestore.Store {
+	if kind == "memory" {
+		log.Warn("PROFILE_STORE is memory, users, clients and tokens are dropped on restart")
+		return profilestore.NewMemoryStore(ctx)
+	}
+	if strings.HasPrefix(dsn, profilestore.SQLiteScheme) {
+		sqlite, err := profilestore.GetSQLiteStore(ctx, log, strings.TrimPrefix(dsn, profilestore.SQLiteScheme))
+		if err != nil {
+			panic(fmt.Errorf("err opening sqlite: %w", err))
+		}
+		if err = sqlite.Migrate(migrate.Up); err != nil {
+			panic(fmt.Errorf("err migrating sqlite: %w", err))
+		}
+		return sqlite
+	}
+	pg, err := profilestore.GetPGStore(ctx, log, dsn, maxConns)
 	if err != nil {
 		panic(fmt.Errorf("err connecting to pg: %w", err))
 	}
 	if err = pg.Migrate(migrate.Up); err != nil {
 		panic(fmt.Errorf("err migrating pg: %w", err))
 	}
-	flashcall := authentication.New(log, flashCallHost, flashCallID, flashCallSecret)
-	auth := authorization.New(log, flashcall, signingKey)
-	router := rest.NewRouter(log, auth, pg, host, version)
-	if err = startServer(ctx, router, log); err != nil {
-		log.Fatal(err)
+	return pg
+}
+
+// withUserCache reads users through a cache in process and in redis at url, if given.
+func withUserCache(log *logrus.Logger, store profilestore.Store, config profilestore.CacheConfig, url string) profilestore.Store {
+	if url != "" {
+		options, err := redis.ParseURL(url)
+		if err != nil {
+			panic(fmt.Errorf("err parsing REDIS_URL: %w", err))
+		}
+		config.Redis = redis.NewClient(options)
+	}
+	if config.Size <= 0 && config.Redis == nil {
+		return store
 	}
+	return profilestore.NewCachedStore(log, store, config)
 }
 
-func startServer(ctx context.Context, router http.Handler, log *logrus.Logger) error {
+// getTLSConfig asks for client certificates signed by the CA, if one is given. Certificates stay optional
+// for the public endpoints, the private ones check them against MTLS_ALLOWED_CLIENTS.
+func getTLSConfig(clientCAFile string) (*tls.Config, error) {
+	config := tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile == "" {
+		return &config, nil
+	}
+	ca, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("err reading client ca: %w", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("err no certificates in %s", clientCAFile)
+	}
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return &config, nil
+}
+
+func startGRPCServer(s *grpc.Server, addr string, log *logrus.Logger) {
+	log.Infof("starting ext_authz grpc server on %s", addr)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("err listening on %s: %v", addr, err)
+	}
+	if err = s.Serve(lis); err != nil {
+		log.Fatalf("err serving grpc: %v", err)
+	}
+}
+
+// startServer serves https if the cert file is given, plain http otherwise.
+func startServer(
+	ctx context.Context,
+	router http.Handler,
+	log *logrus.Logger,
+	tlsConfig *tls.Config,
+	certFile, keyFile string,
+) error {
 	log.Infof("starting server on port %d", httpPort)
 	s := &http.Server{
 		Addr:              fmt.Sprintf(":%d", httpPort),
@@ -75,10 +303,17 @@ func startServer(ctx context.Context, router http.Handler, log *logrus.Logger) e
 		ReadTimeout:       30 * time.Second,
 		WriteTimeout:      30 * time.Second,
 		Handler:           router,
+		TLSConfig:         tlsConfig,
 	}
 	errCh := make(chan error)
 	go func() {
-		if err := s.ListenAndServe(); err != nil && errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if certFile != "" {
+			err = s.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = s.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errCh <- err
 		}
 	}()
@@ -104,3 +339,34 @@ func GetLogger(verbose bool) *logrus.Logger {
 	}
 	return log
 }
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvDuration(log *logrus.Logger, key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Panicf("err parsing env %s: %v", key, err)
+	}
+	return d
+}
+
+func getEnvInt(log *logrus.Logger, key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Panicf("err parsing env %s: %v", key, err)
+	}
+	return n
+}
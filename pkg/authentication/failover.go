@@ -0,0 +1,171 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/metrics"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	operationVerifyPhone  = "verify_phone"
+	operationAuthenticate = "authenticate"
+)
+
+type Provider interface {
+	Authenticate(ctx context.Context, challenge *models.Challenge, phone, code string) error
+	VerifyPhone(ctx context.Context, challenge *models.Challenge, phone string) error
+}
+
+type FailoverConfig struct {
+	// FailureThreshold consecutive failures open the circuit of a provider for OpenFor.
+	FailureThreshold int
+	OpenFor          time.Duration
+}
+
+type failoverProvider struct {
+	name      string
+	provider  Provider
+	failures  int
+	openUntil time.Time
+}
+
+// Failover tries providers in priority order until one of them sends the code and then
+// checks the code with the same provider, named in the challenge so that any instance finds it.
+// Providers failing too often are skipped for a while.
+type Failover struct {
+	log       *logrus.Entry
+	config    FailoverConfig
+	metrics   *metrics.Authenticator
+	mu        sync.Mutex
+	providers []*failoverProvider
+}
+
+func NewFailover(log *logrus.Logger, config FailoverConfig) *Failover {
+	f := Failover{
+		log:     log.WithField("module", "failover"),
+		config:  config,
+		metrics: metrics.NewAuthenticator().AutoRegister(),
+	}
+	return &f
+}
+
+// Add appends the provider to the chain with the lowest priority so far.
+func (f *Failover) Add(name string, provider Provider) *Failover {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.providers = append(f.providers, &failoverProvider{name: name, provider: provider})
+	f.metrics.CircuitOpen.WithLabelValues(name).Set(0)
+	return f
+}
+
+// VerifyPhone sends the code through the first provider that works, naming it in the challenge.
+func (f *Failover) VerifyPhone(ctx context.Context, challenge *models.Challenge, phone string) error {
+	var invalidPhone, lastErr error
+	for _, p := range f.available() {
+		err := p.provider.VerifyPhone(ctx, challenge, phone)
+		switch {
+		case err == nil:
+			f.succeeded(p, operationVerifyPhone)
+			challenge.SetProvider(p.name)
+			return nil
+		case errors.Is(err, common.ErrResendTooSoon):
+			return err
+		case errors.Is(err, common.ErrInvalidPhoneNumber):
+			// another provider may be able to reach the phone
+			invalidPhone = err
+		default:
+			f.failed(p, operationVerifyPhone, err)
+			lastErr = err
+		}
+	}
+	switch {
+	case lastErr != nil:
+		return fmt.Errorf("err all authentication providers failed, last: %w", lastErr)
+	case invalidPhone != nil:
+		return invalidPhone
+	default:
+		return errors.New("err no authentication provider available")
+	}
+}
+
+// Authenticate checks the code with the provider named in the challenge.
+func (f *Failover) Authenticate(ctx context.Context, challenge *models.Challenge, phone, code string) error {
+	_, name := challenge.SplitChannel()
+	p, ok := f.provider(name)
+	if !ok {
+		return fmt.Errorf("%w: no provider sent a code to %s", common.ErrUnauthenticated, phone)
+	}
+	err := p.provider.Authenticate(ctx, challenge, phone, code)
+	switch {
+	case err == nil:
+		f.succeeded(p, operationAuthenticate)
+	case errors.Is(err, common.ErrUnauthenticated),
+		errors.Is(err, common.ErrInvalidPhoneNumber),
+		errors.Is(err, common.ErrCodeExpired),
+		errors.Is(err, common.ErrAttemptsExhausted):
+		// the provider works, the user doesn't
+		f.succeeded(p, operationAuthenticate)
+	default:
+		f.failed(p, operationAuthenticate, err)
+	}
+	return err
+}
+
+// provider finds the provider by name, whether its circuit is open or not.
+func (f *Failover) provider(name string) (*failoverProvider, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range f.providers {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// available returns providers in priority order, skipping the ones with open circuits.
+// A provider whose circuit has been open for long enough is given another try.
+func (f *Failover) available() []*failoverProvider {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	result := make([]*failoverProvider, 0, len(f.providers))
+	for _, p := range f.providers {
+		if now.Before(p.openUntil) {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+func (f *Failover) succeeded(p *failoverProvider, operation string) {
+	f.metrics.SuccessTotal.WithLabelValues(p.name, operation).Inc()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p.failures >= f.config.FailureThreshold {
+		f.log.Infof("provider %s recovered", p.name)
+	}
+	p.failures = 0
+	f.metrics.CircuitOpen.WithLabelValues(p.name).Set(0)
+}
+
+func (f *Failover) failed(p *failoverProvider, operation string, err error) {
+	f.metrics.FailureTotal.WithLabelValues(p.name, operation).Inc()
+	f.log.Warnf("provider %s failed to %s: %v", p.name, operation, err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p.failures++
+	if p.failures >= f.config.FailureThreshold {
+		p.openUntil = time.Now().Add(f.config.OpenFor)
+		f.metrics.CircuitOpen.WithLabelValues(p.name).Set(1)
+		f.log.Warnf("provider %s skipped for %s after %d consecutive failures", p.name, f.config.OpenFor, p.failures)
+	}
+}
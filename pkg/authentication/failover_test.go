@@ -0,0 +1,62 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+// stubProvider sends codes unless it's down and accepts code.
+type stubProvider struct {
+	down bool
+	code string
+	sent int
+}
+
+func (p *stubProvider) Authenticate(_ context.Context, _ *models.Challenge, _, code string) error {
+	if code != p.code {
+		return common.ErrUnauthenticated
+	}
+	return nil
+}
+
+func (p *stubProvider) VerifyPhone(context.Context, *models.Challenge, string) error {
+	if p.down {
+		return errors.New("err provider down")
+	}
+	p.sent++
+	return nil
+}
+
+// TestFailoverProvider checks that the code is checked by the provider that sent it, whichever instance checks it.
+func TestFailoverProvider(t *testing.T) {
+	ctx := context.Background()
+	config := FailoverConfig{FailureThreshold: 3, OpenFor: time.Minute}
+	first, second := &stubProvider{down: true, code: "1111"}, &stubProvider{code: "2222"}
+	sender := NewFailover(logrus.New(), config).Add("first", first).Add("second", second)
+	challenge := models.Challenge{Phone: testPhone, Channel: "auto"}
+	if err := sender.VerifyPhone(ctx, &challenge, testPhone); err != nil {
+		t.Fatal(err)
+	}
+	if second.sent != 1 || challenge.Channel != "auto:second" {
+		t.Fatalf("code sent %d times by second, challenge of %s", second.sent, challenge.Channel)
+	}
+
+	first.down = false
+	checker := NewFailover(logrus.New(), config).Add("first", first).Add("second", second)
+	if err := checker.Authenticate(ctx, &challenge, testPhone, "1111"); !errors.Is(err, common.ErrUnauthenticated) {
+		t.Fatalf("got %v checking the code of another provider, want %v", err, common.ErrUnauthenticated)
+	}
+	if err := checker.Authenticate(ctx, &challenge, testPhone, "2222"); err != nil {
+		t.Fatal(err)
+	}
+	unsent := models.Challenge{Phone: testPhone, Channel: "auto"}
+	if err := checker.Authenticate(ctx, &unsent, testPhone, "2222"); !errors.Is(err, common.ErrUnauthenticated) {
+		t.Fatalf("got %v checking a code no provider sent, want %v", err, common.ErrUnauthenticated)
+	}
+}
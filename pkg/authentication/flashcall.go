@@ -1,34 +1,160 @@
 package authentication
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/metrics"
+	"github.com/gerladeno/authorization-service/pkg/models"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	statusSuccess = "succes" // sic, as returned by telphin
+	statusError   = "error"
+)
+
+// Error codes returned by telphin in the description field, see telphin_flash_call.pdf.
+const (
+	errInvalidClient         = "invalid_client"
+	errOneCallForTwoMinutes  = "one_call_for_two_minutes"
+	errTenCallsPerDay        = "ten_calls_per_day"
+	errParametersError       = "parameters_error"
+	errDirectionError        = "direction_error"
+	errServiceIsNotConnected = "service_is_not_connected"
+	errCodeLifetimeExpired   = "code_lifetime_expired"
+	errAuthCodeDoesNotExist  = "auth_code_does_not_exist"
+	errAuthAttemptsEnded     = "auth_attempts_ended"
+	errInvalidCode           = "invalid_code"
+)
+
+const (
+	httpRequestTimeout   = 10 * time.Second
+	httpIdleConnsPerHost = 10
+)
+
 type FlashCall struct {
 	log       *logrus.Entry
 	host      string
 	AppID     string
 	AppSecret string
+	client    *http.Client
+	metrics   *metrics.HTTPOut
+}
+
+type flashCallRequest struct {
+	AppID     string `json:"app_id"`
+	AppSecret string `json:"app_secret"`
+	Number    string `json:"number"`
+	AuthCode  string `json:"auth_code,omitempty"`
+}
+
+type flashCallResponse struct {
+	Status      string `json:"status"`
+	Number      string `json:"number"`
+	AuthCode    string `json:"auth_code"`
+	Description string `json:"description"`
 }
 
 func New(log *logrus.Logger, host, appID, appSecret string) *FlashCall {
 	fc := FlashCall{
 		log:       log.WithField("module", "flashcall"),
-		host:      host,
+		host:      strings.TrimSuffix(host, "/"),
 		AppID:     appID,
 		AppSecret: appSecret,
+		client: &http.Client{
+			Timeout:   httpRequestTimeout,
+			Transport: &http.Transport{MaxIdleConnsPerHost: httpIdleConnsPerHost},
+		},
+		metrics: metrics.NewHTTPOut(host).AutoRegister(),
 	}
 	return &fc
 }
 
-func (fc *FlashCall) Authenticate(ctx context.Context, phone, code string) error {
-	fc.log.Infof("phone number %s verified successfully with code %s", phone, code)
+// Authenticate checks the code (last 4 digits of the calling number) the user received with the call.
+func (fc *FlashCall) Authenticate(ctx context.Context, _ *models.Challenge, phone, code string) error {
+	if code == "" {
+		return common.ErrUnauthenticated
+	}
+	resp, err := fc.do(ctx, "/auth/", flashCallRequest{
+		AppID:     fc.AppID,
+		AppSecret: fc.AppSecret,
+		Number:    phone,
+		AuthCode:  code,
+	})
+	if err != nil {
+		return fmt.Errorf("err authenticating %s: %w", phone, err)
+	}
+	fc.log.Debugf("phone number %s verified successfully", resp.Number)
 	return nil
 }
 
-func (fc *FlashCall) VerifyPhone(ctx context.Context, phone string) error {
-	fc.log.Infof("calling %s", phone)
+// VerifyPhone initiates a flash call to the phone.
+func (fc *FlashCall) VerifyPhone(ctx context.Context, _ *models.Challenge, phone string) error {
+	resp, err := fc.do(ctx, "/", flashCallRequest{
+		AppID:     fc.AppID,
+		AppSecret: fc.AppSecret,
+		Number:    phone,
+	})
+	if err != nil {
+		return fmt.Errorf("err calling %s: %w", phone, err)
+	}
+	fc.log.Debugf("calling %s", resp.Number)
 	return nil
 }
+
+func (fc *FlashCall) do(ctx context.Context, path string, body flashCallRequest) (*flashCallResponse, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("err marshalling flashcall request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fc.host+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("err creating flashcall request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var result flashCallResponse
+	resp, err := fc.metrics.DoAndCollect(fc.client, req, &result)
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch result.Status {
+	case statusSuccess:
+		return &result, nil
+	case statusError:
+		return nil, mapFlashCallError(result.Description)
+	default:
+		return nil, fmt.Errorf("err unexpected flashcall status %q: %s", result.Status, result.Description)
+	}
+}
+
+func mapFlashCallError(description string) error {
+	switch description {
+	case errDirectionError, errParametersError:
+		return fmt.Errorf("%w: %s", common.ErrInvalidPhoneNumber, description)
+	case errInvalidCode, errAuthCodeDoesNotExist:
+		return fmt.Errorf("%w: %s", common.ErrUnauthenticated, description)
+	case errCodeLifetimeExpired:
+		return fmt.Errorf("%w: %s", common.ErrCodeExpired, description)
+	case errAuthAttemptsEnded:
+		return fmt.Errorf("%w: %s", common.ErrAttemptsExhausted, description)
+	case errOneCallForTwoMinutes:
+		return fmt.Errorf("%w: %s", common.ErrResendTooSoon, description)
+	case errTenCallsPerDay:
+		return fmt.Errorf("err flashcall rate limit: %s", description)
+	case errInvalidClient, errServiceIsNotConnected:
+		return fmt.Errorf("err flashcall misconfigured: %s", description)
+	default:
+		return errors.New("err flashcall: " + description)
+	}
+}
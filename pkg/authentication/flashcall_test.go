@@ -0,0 +1,93 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gerladeno/authorization-service/pkg/authentication/telphintest"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testAppID     = "app"
+	testAppSecret = "secret"
+	testPhone     = "+79005556162"
+)
+
+// testChallenge is the challenge of a code sent to testPhone of the default tenant.
+func testChallenge() *models.Challenge {
+	return &models.Challenge{Phone: testPhone}
+}
+
+func newTestFlashCall(t *testing.T) (*FlashCall, *telphintest.Server) {
+	t.Helper()
+	server := telphintest.NewServer(testAppID, testAppSecret)
+	t.Cleanup(server.Close)
+	return New(logrus.New(), server.URL+"/", testAppID, testAppSecret), server
+}
+
+func TestFlashCallVerify(t *testing.T) {
+	ctx := context.Background()
+	fc, server := newTestFlashCall(t)
+	if err := fc.VerifyPhone(ctx, testChallenge(), testPhone); err != nil {
+		t.Fatal(err)
+	}
+	code := server.Code(testPhone)
+	if code == "" {
+		t.Fatal("no call was made")
+	}
+	if err := fc.Authenticate(ctx, testChallenge(), testPhone, code); err != nil {
+		t.Fatal(err)
+	}
+	// the code is used up
+	if err := fc.Authenticate(ctx, testChallenge(), testPhone, code); !errors.Is(err, common.ErrUnauthenticated) {
+		t.Fatalf("got %v authenticating with a used code, want %v", err, common.ErrUnauthenticated)
+	}
+}
+
+func TestFlashCallErrors(t *testing.T) {
+	ctx := context.Background()
+	fc, _ := newTestFlashCall(t)
+	if err := fc.VerifyPhone(ctx, testChallenge(), "+19005556162"); !errors.Is(err, common.ErrInvalidPhoneNumber) {
+		t.Fatalf("got %v calling a foreign number, want %v", err, common.ErrInvalidPhoneNumber)
+	}
+	if err := fc.Authenticate(ctx, testChallenge(), testPhone, ""); !errors.Is(err, common.ErrUnauthenticated) {
+		t.Fatalf("got %v authenticating without a code, want %v", err, common.ErrUnauthenticated)
+	}
+	if err := fc.Authenticate(ctx, testChallenge(), testPhone, "0000"); !errors.Is(err, common.ErrUnauthenticated) {
+		t.Fatalf("got %v authenticating before a call, want %v", err, common.ErrUnauthenticated)
+	}
+
+	if err := fc.VerifyPhone(ctx, testChallenge(), testPhone); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := fc.Authenticate(ctx, testChallenge(), testPhone, "wrong"); !errors.Is(err, common.ErrUnauthenticated) {
+			t.Fatalf("got %v authenticating with a wrong code, want %v", err, common.ErrUnauthenticated)
+		}
+	}
+	if err := fc.Authenticate(ctx, testChallenge(), testPhone, "wrong"); !errors.Is(err, common.ErrAttemptsExhausted) {
+		t.Fatalf("got %v after the attempts ended, want %v", err, common.ErrAttemptsExhausted)
+	}
+}
+
+// TestFlashCallCredentials checks that the app credentials are sent with every request and that telphin refusing
+// them isn't taken for a problem of the user.
+func TestFlashCallCredentials(t *testing.T) {
+	ctx := context.Background()
+	server := telphintest.NewServer(testAppID, testAppSecret)
+	defer server.Close()
+	fc := New(logrus.New(), server.URL, testAppID, "other")
+	errs := []error{
+		fc.VerifyPhone(ctx, testChallenge(), testPhone),
+		fc.Authenticate(ctx, testChallenge(), testPhone, "0000"),
+	}
+	for _, err := range errs {
+		if err == nil || errors.Is(err, common.ErrUnauthenticated) || errors.Is(err, common.ErrInvalidPhoneNumber) {
+			t.Fatalf("got %v with wrong app credentials, want a misconfiguration", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package authentication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gerladeno/authorization-service/pkg/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// HTTPGateway sends text messages through a plain HTTP API: a POST of {"phone": ..., "text": ...}
+// with a bearer token, answered with 200 and a JSON body.
+type HTTPGateway struct {
+	url     string
+	token   string
+	client  *http.Client
+	metrics *metrics.HTTPOut
+}
+
+type smsRequest struct {
+	Phone string `json:"phone"`
+	Text  string `json:"text"`
+}
+
+func NewHTTPGateway(url, token string) *HTTPGateway {
+	g := HTTPGateway{
+		url:   url,
+		token: token,
+		client: &http.Client{
+			Timeout:   httpRequestTimeout,
+			Transport: &http.Transport{MaxIdleConnsPerHost: httpIdleConnsPerHost},
+		},
+		metrics: metrics.NewHTTPOut(url).AutoRegister(),
+	}
+	return &g
+}
+
+func (g *HTTPGateway) Send(ctx context.Context, phone, text string) error {
+	b, err := json.Marshal(smsRequest{Phone: phone, Text: text})
+	if err != nil {
+		return fmt.Errorf("err marshalling sms request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("err creating sms request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	}
+	var result map[string]interface{}
+	resp, err := g.metrics.DoAndCollect(g.client, req, &result)
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+	return err
+}
+
+// LogGateway only logs the messages, for local development.
+type LogGateway struct {
+	log *logrus.Entry
+}
+
+func NewLogGateway(log *logrus.Logger) *LogGateway {
+	return &LogGateway{log: log.WithField("module", "sms_gateway")}
+}
+
+func (g *LogGateway) Send(_ context.Context, phone, text string) error {
+	g.log.Infof("sms to %s: %s", phone, text)
+	return nil
+}
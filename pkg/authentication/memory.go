@@ -0,0 +1,71 @@
+package authentication
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+)
+
+const memoryCleanupInterval = time.Minute
+
+type memoryCode struct {
+	hash    string
+	expires time.Time
+}
+
+type MemoryCodeStore struct {
+	mu    sync.Mutex
+	codes map[string]memoryCode
+}
+
+// NewMemoryCodeStore returns a CodeStore that is dropped on restart. Expired codes are purged until ctx is done.
+func NewMemoryCodeStore(ctx context.Context) *MemoryCodeStore {
+	m := MemoryCodeStore{codes: make(map[string]memoryCode)}
+	go m.cleanup(ctx)
+	return &m
+}
+
+func (m *MemoryCodeStore) SaveCode(_ context.Context, phone, codeHash string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[phone] = memoryCode{hash: codeHash, expires: expires}
+	return nil
+}
+
+func (m *MemoryCodeStore) GetCode(_ context.Context, phone string) (string, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.codes[phone]
+	if !ok {
+		return "", time.Time{}, common.ErrChallengeNotFound
+	}
+	return code.hash, code.expires, nil
+}
+
+func (m *MemoryCodeStore) DeleteCode(_ context.Context, phone string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.codes, phone)
+	return nil
+}
+
+func (m *MemoryCodeStore) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(memoryCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for phone, code := range m.codes {
+				if now.UTC().After(code.expires) {
+					delete(m.codes, phone)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
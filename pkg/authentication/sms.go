@@ -0,0 +1,111 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+const smsText = "Your verification code: %s"
+
+var rePhone = regexp.MustCompile(`^\+?\d{10,15}$`)
+
+type SMSGateway interface {
+	Send(ctx context.Context, phone, text string) error
+}
+
+// CodeStore keeps a hash of the last code sent under each key, the phone prefixed with the tenant outside
+// the default one as the challenge is.
+type CodeStore interface {
+	SaveCode(ctx context.Context, key, codeHash string, expires time.Time) error
+	GetCode(ctx context.Context, key string) (string, time.Time, error)
+	DeleteCode(ctx context.Context, key string) error
+}
+
+// SMS sends numeric one-time codes in text messages and verifies them itself.
+type SMS struct {
+	log        *logrus.Entry
+	gateway    SMSGateway
+	store      CodeStore
+	codeLength int
+	ttl        time.Duration
+}
+
+func NewSMS(log *logrus.Logger, gateway SMSGateway, store CodeStore, codeLength int, ttl time.Duration) *SMS {
+	s := SMS{
+		log:        log.WithField("module", "sms"),
+		gateway:    gateway,
+		store:      store,
+		codeLength: codeLength,
+		ttl:        ttl,
+	}
+	return &s
+}
+
+func (s *SMS) Authenticate(ctx context.Context, challenge *models.Challenge, phone, code string) error {
+	codeHash, expires, err := s.store.GetCode(ctx, challenge.Phone)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrChallengeNotFound):
+		return fmt.Errorf("%w: no code sent to %s", common.ErrUnauthenticated, phone)
+	default:
+		return fmt.Errorf("err getting sms code for %s: %w", phone, err)
+	}
+	if time.Now().UTC().After(expires) {
+		return common.ErrCodeExpired
+	}
+	if subtle.ConstantTimeCompare([]byte(codeHash), []byte(hashCode(challenge.Phone, code))) != 1 {
+		return common.ErrUnauthenticated
+	}
+	if err = s.store.DeleteCode(ctx, challenge.Phone); err != nil {
+		return fmt.Errorf("err deleting sms code for %s: %w", phone, err)
+	}
+	return nil
+}
+
+func (s *SMS) VerifyPhone(ctx context.Context, challenge *models.Challenge, phone string) error {
+	if !rePhone.MatchString(phone) {
+		return common.ErrInvalidPhoneNumber
+	}
+	code, err := generateCode(s.codeLength)
+	if err != nil {
+		return err
+	}
+	if err = s.store.SaveCode(ctx, challenge.Phone, hashCode(challenge.Phone, code), time.Now().UTC().Add(s.ttl)); err != nil {
+		return fmt.Errorf("err saving sms code for %s: %w", phone, err)
+	}
+	if err = s.gateway.Send(ctx, phone, fmt.Sprintf(smsText, code)); err != nil {
+		return fmt.Errorf("err sending sms to %s: %w", phone, err)
+	}
+	s.log.Debugf("sms code sent to %s", phone)
+	return nil
+}
+
+func generateCode(length int) (string, error) {
+	var b strings.Builder
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", fmt.Errorf("err generating sms code: %w", err)
+		}
+		b.WriteString(n.String())
+	}
+	return b.String(), nil
+}
+
+func hashCode(key, code string) string {
+	sum := sha256.Sum256([]byte(key + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,124 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/authentication/smstest"
+	"github.com/gerladeno/authorization-service/pkg/authentication/telphintest"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const testGatewayToken = "token"
+
+func TestSMS(t *testing.T) {
+	ctx := context.Background()
+	server := smstest.NewServer(testGatewayToken)
+	defer server.Close()
+	sms := NewSMS(logrus.New(), NewHTTPGateway(server.URL, testGatewayToken), NewMemoryCodeStore(ctx), 6, time.Minute)
+
+	if err := sms.VerifyPhone(ctx, testChallenge(), testPhone); err != nil {
+		t.Fatal(err)
+	}
+	code := server.Code(testPhone)
+	if len(code) != 6 {
+		t.Fatalf("got code %q in %v", code, server.Messages(testPhone))
+	}
+	if err := sms.Authenticate(ctx, testChallenge(), testPhone, "wrong"); !errors.Is(err, common.ErrUnauthenticated) {
+		t.Fatalf("got %v authenticating with a wrong code, want %v", err, common.ErrUnauthenticated)
+	}
+	if err := sms.Authenticate(ctx, testChallenge(), testPhone, code); err != nil {
+		t.Fatal(err)
+	}
+	if err := sms.Authenticate(ctx, testChallenge(), testPhone, code); !errors.Is(err, common.ErrUnauthenticated) {
+		t.Fatalf("got %v authenticating with a used code, want %v", err, common.ErrUnauthenticated)
+	}
+	if err := sms.VerifyPhone(ctx, testChallenge(), "phone"); !errors.Is(err, common.ErrInvalidPhoneNumber) {
+		t.Fatalf("got %v sending to an invalid phone, want %v", err, common.ErrInvalidPhoneNumber)
+	}
+}
+
+// TestSMSTenants checks that codes sent to the same phone for users of different tenants are kept apart.
+func TestSMSTenants(t *testing.T) {
+	ctx := context.Background()
+	server := smstest.NewServer(testGatewayToken)
+	defer server.Close()
+	sms := NewSMS(logrus.New(), NewHTTPGateway(server.URL, testGatewayToken), NewMemoryCodeStore(ctx), 6, time.Minute)
+	other := &models.Challenge{Phone: "other/" + testPhone}
+
+	if err := sms.VerifyPhone(ctx, testChallenge(), testPhone); err != nil {
+		t.Fatal(err)
+	}
+	code := server.Code(testPhone)
+	if err := sms.VerifyPhone(ctx, other, testPhone); err != nil {
+		t.Fatal(err)
+	}
+	otherCode := server.Code(testPhone)
+	if code == otherCode {
+		// the codes match once in a million runs, nothing tells them apart then
+		t.Skip("same code sent twice")
+	}
+	if err := sms.Authenticate(ctx, other, testPhone, code); !errors.Is(err, common.ErrUnauthenticated) {
+		t.Fatalf("got %v authenticating with the code of another tenant, want %v", err, common.ErrUnauthenticated)
+	}
+	if err := sms.Authenticate(ctx, testChallenge(), testPhone, code); err != nil {
+		t.Fatal(err)
+	}
+	if err := sms.Authenticate(ctx, other, testPhone, otherCode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPGatewayToken(t *testing.T) {
+	server := smstest.NewServer(testGatewayToken)
+	defer server.Close()
+	if err := NewHTTPGateway(server.URL, "other").Send(context.Background(), testPhone, "1234"); err == nil {
+		t.Fatal("sent with a wrong token")
+	}
+	if messages := server.Messages(testPhone); len(messages) != 0 {
+		t.Fatalf("got messages %v sent with a wrong token", messages)
+	}
+}
+
+// TestHTTPOutMetrics checks that the gateway and the flash calls both export their requests.
+func TestHTTPOutMetrics(t *testing.T) {
+	ctx := context.Background()
+	gateway := smstest.NewServer("")
+	defer gateway.Close()
+	telphin := telphintest.NewServer(testAppID, testAppSecret)
+	defer telphin.Close()
+	if err := NewHTTPGateway(gateway.URL, "").Send(ctx, testPhone, "1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(logrus.New(), telphin.URL, testAppID, testAppSecret).VerifyPhone(ctx, testChallenge(), testPhone); err != nil {
+		t.Fatal(err)
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := make(map[string]bool)
+	for _, family := range families {
+		if family.GetName() != "http_out_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "host" {
+					hosts[label.GetValue()] = true
+				}
+			}
+		}
+	}
+	for _, host := range []string{gateway.URL, telphin.URL} {
+		if !hosts[host] {
+			t.Errorf("no requests to %s exported, got hosts %v", host, hosts)
+		}
+	}
+}
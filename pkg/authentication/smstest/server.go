@@ -0,0 +1,69 @@
+// Package smstest provides a local stand-in for the HTTP sms gateway,
+// so the sms channel can be run and tested without sending real messages.
+package smstest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+)
+
+var reCode = regexp.MustCompile(`\d{4,}`)
+
+type message struct {
+	Phone string `json:"phone"`
+	Text  string `json:"text"`
+}
+
+// Server accepts messages the way authentication.HTTPGateway sends them and remembers them.
+type Server struct {
+	*httptest.Server
+	token    string
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+// NewServer starts a fake gateway requiring the bearer token, if it's not empty.
+func NewServer(token string) *Server {
+	s := &Server{
+		token:    token,
+		messages: make(map[string][]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.send))
+	return s
+}
+
+// Messages returns texts sent to the phone, oldest first.
+func (s *Server) Messages(phone string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages[phone]...)
+}
+
+// Code extracts the code from the last message sent to the phone, empty if there was none.
+func (s *Server) Code(phone string) string {
+	messages := s.Messages(phone)
+	if len(messages) == 0 {
+		return ""
+	}
+	return reCode.FindString(messages[len(messages)-1])
+}
+
+func (s *Server) send(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	var msg message
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&msg) != nil || msg.Phone == "" {
+		http.Error(w, `{"error":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.messages[msg.Phone] = append(s.messages[msg.Phone], msg.Text)
+	s.mu.Unlock()
+	w.Header().Set("Content-type", "application/json")
+	_, _ = w.Write([]byte(`{"status":"ok"}`))
+}
@@ -0,0 +1,137 @@
+// Package telphintest provides a local stand-in for the telphin flash call API,
+// so the service can be run and tested without calling real phones.
+package telphintest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	codeLifetime = 2 * time.Minute
+	maxAttempts  = 3
+)
+
+type request struct {
+	AppID     string `json:"app_id"`
+	AppSecret string `json:"app_secret"`
+	Number    string `json:"number"`
+	AuthCode  string `json:"auth_code"`
+}
+
+type pending struct {
+	code     string
+	issued   time.Time
+	attempts int
+}
+
+// Server mimics https://flashcall.telphin.ru/ and https://flashcall.telphin.ru/auth/.
+type Server struct {
+	*httptest.Server
+	appID     string
+	appSecret string
+	mu        sync.Mutex
+	codes     map[string]*pending
+}
+
+// NewServer starts a fake telphin server accepting the given app credentials.
+func NewServer(appID, appSecret string) *Server {
+	s := &Server{
+		appID:     appID,
+		appSecret: appSecret,
+		codes:     make(map[string]*pending),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.call)
+	mux.HandleFunc("/auth/", s.auth)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Code returns the code the last call to the phone carried, empty if there was none.
+func (s *Server) Code(phone string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.codes[phone]; ok {
+		return p.code
+	}
+	return ""
+}
+
+func (s *Server) call(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decode(w, r)
+	if !ok {
+		return
+	}
+	if !strings.HasPrefix(req.Number, "+7") && !strings.HasPrefix(req.Number, "8") {
+		writeError(w, "direction_error")
+		return
+	}
+	s.mu.Lock()
+	s.codes[req.Number] = &pending{
+		code:   fmt.Sprintf("%04d", rand.Intn(10000)),
+		issued: time.Now(),
+	}
+	s.mu.Unlock()
+	writeJSON(w, map[string]string{"status": "succes", "number": req.Number})
+}
+
+func (s *Server) auth(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decode(w, r)
+	if !ok {
+		return
+	}
+	if req.AuthCode == "" {
+		writeError(w, "parameters_error")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.codes[req.Number]
+	switch {
+	case !ok:
+		writeError(w, "auth_code_does_not_exist")
+	case time.Since(p.issued) > codeLifetime:
+		writeError(w, "code_lifetime_expired")
+	case p.attempts >= maxAttempts:
+		writeError(w, "auth_attempts_ended")
+	case p.code != req.AuthCode:
+		p.attempts++
+		writeError(w, "invalid_code")
+	default:
+		delete(s.codes, req.Number)
+		writeJSON(w, map[string]string{"status": "succes", "number": req.Number, "auth_code": req.AuthCode})
+	}
+}
+
+func (s *Server) decode(w http.ResponseWriter, r *http.Request) (*request, bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, "parameters_error")
+		return nil, false
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Number == "" {
+		writeError(w, "parameters_error")
+		return nil, false
+	}
+	if req.AppID != s.appID || req.AppSecret != s.appSecret {
+		writeError(w, "invalid_client")
+		return nil, false
+	}
+	return &req, true
+}
+
+func writeError(w http.ResponseWriter, description string) {
+	writeJSON(w, map[string]string{"status": "error", "description": description})
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(data) //nolint:errchkjson
+}
@@ -0,0 +1,146 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/google/uuid"
+)
+
+const AuditImpersonate = "impersonate"
+
+// AuditLog keeps the record of impersonations.
+type AuditLog interface {
+	SaveAuditEntry(ctx context.Context, entry *models.AuditEntry) error
+}
+
+// WithImpersonation lets the users, support staff, impersonate others by token exchange, as well as those
+// granted ScopeImpersonate by their roles. Every impersonation is recorded to the audit log before the token
+// is issued, none is allowed without it.
+func (a *Authorizer) WithImpersonation(impersonators []string, audit AuditLog) *Authorizer {
+	a.impersonators = make(map[string]bool, len(impersonators))
+	for _, id := range impersonators {
+		a.impersonators[id] = true
+	}
+	a.audit = audit
+	return a
+}
+
+// ExchangeToken issues an access token in place of a user's one, RFC 8693. Without RequestedSubject it's
+// the same user's for the audience, scopes can only be narrowed. With it the subject token has to be of
+// support staff, who get a token of the requested user with the act claim telling who really acts.
+// No refresh token is issued, the exchange is repeated instead.
+func (a *Authorizer) ExchangeToken(ctx context.Context, req *models.TokenExchange) (*models.Tokens, error) {
+	subject, err := a.parseValidToken(ctx, req.SubjectToken)
+	if err != nil {
+		return nil, err
+	}
+	if subject.IsClient() {
+		return nil, fmt.Errorf("%w: client token of %s can't be exchanged", common.ErrInvalidAccessToken, subject.ClientID)
+	}
+	claims := userClaims(subject.UUID, subject.TenantID, "", req.ClientID, subject.Scope)
+	claims.Roles = subject.Roles
+	claims.Act = subject.Act
+	impersonating := req.RequestedSubject != "" && req.RequestedSubject != subject.UUID
+	if impersonating {
+		if claims, err = a.impersonationClaims(ctx, subject, req); err != nil {
+			return nil, err
+		}
+	}
+	if claims.Scope, err = narrowScope(claims.Scope, req.Scope); err != nil {
+		return nil, err
+	}
+	claims.Audience = req.Audience
+	if impersonating {
+		if err = a.auditImpersonation(ctx, claims); err != nil {
+			return nil, err
+		}
+	}
+	access, accessExpires, err := a.accessToken(claims)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Tokens{
+		UUID:          claims.UUID,
+		AccessToken:   access,
+		AccessExpires: accessExpires,
+		ClientID:      req.ClientID,
+		Scope:         claims.Scope,
+	}, nil
+}
+
+// narrowScope returns the requested scope if it's within the granted one, the granted one if none is asked for.
+// An empty granted scope grants nothing to ask for.
+func narrowScope(granted, requested string) (string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return granted, nil
+	}
+	for _, s := range scopes {
+		if !HasScope(granted, s) {
+			return "", fmt.Errorf("%w: %s", common.ErrInvalidScope, s)
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// impersonationClaims makes the claims of the requested user, with their roles, acted for by the subject.
+func (a *Authorizer) impersonationClaims(ctx context.Context, subject *Claims, req *models.TokenExchange) (*Claims, error) {
+	if a.audit == nil || !a.mayImpersonate(subject) {
+		return nil, fmt.Errorf("%w: %s", common.ErrImpersonationForbidden, subject.UUID)
+	}
+	if subject.Act != nil {
+		return nil, fmt.Errorf("%w: %s is impersonated by %s already",
+			common.ErrImpersonationForbidden, subject.UUID, subject.Act.Sub)
+	}
+	user, err := a.users.GetUserByUUID(ctx, req.RequestedSubject)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrUserNotFound):
+		return nil, fmt.Errorf("%w: %s", common.ErrUserNotFound, req.RequestedSubject)
+	default:
+		return nil, fmt.Errorf("err getting user %s: %w", req.RequestedSubject, err)
+	}
+	// users of other tenants are out of reach as if they didn't exist
+	if tenantOf(user) != subject.tenant() {
+		return nil, fmt.Errorf("%w: %s", common.ErrUserNotFound, req.RequestedSubject)
+	}
+	claims := userClaims(req.RequestedSubject, subject.tenant(), "", req.ClientID, "")
+	if err = a.grantRoles(ctx, claims); err != nil {
+		return nil, err
+	}
+	claims.Act = &models.Actor{Sub: subject.UUID}
+	return claims, nil
+}
+
+// mayImpersonate tells if the subject signed in to the service itself, tokens of OAuth clients never impersonate,
+// and is listed as support staff or was granted the permission.
+func (a *Authorizer) mayImpersonate(subject *Claims) bool {
+	if subject.ClientID != "" {
+		return false
+	}
+	return a.impersonators[subject.UUID] || HasScope(subject.Scope, ScopeImpersonate)
+}
+
+// auditImpersonation records the impersonation before the token is issued, failing it if it can't be recorded.
+func (a *Authorizer) auditImpersonation(ctx context.Context, claims *Claims) error {
+	err := a.audit.SaveAuditEntry(ctx, &models.AuditEntry{
+		ID:       uuid.New().String(),
+		Action:   AuditImpersonate,
+		Actor:    claims.Act.Sub,
+		Subject:  claims.UUID,
+		ClientID: claims.ClientID,
+		Details:  fmt.Sprintf("audience=%q scope=%q", claims.Audience, claims.Scope),
+		Created:  time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("err saving audit entry: %w", err)
+	}
+	a.log.Infof("user %s impersonates %s through client %s", claims.Act.Sub, claims.UUID, claims.ClientID)
+	return nil
+}
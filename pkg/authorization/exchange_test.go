@@ -0,0 +1,150 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+// failingAudit can't record anything.
+type failingAudit struct{}
+
+func (failingAudit) SaveAuditEntry(context.Context, *models.AuditEntry) error {
+	return errors.New("err audit log down")
+}
+
+func TestExchangeScope(t *testing.T) {
+	ctx := context.Background()
+	a, store := newTestAuthorizer(t)
+	user := addTestUser(t, store, common.DefaultTenant, "+70000000001")
+	scoped, err := a.IssueTokens(ctx, user, "app", "orders:read orders:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unscoped, err := a.IssueTokens(ctx, user, "app", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := a.ClientToken(ctx, "app", "", "orders:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		subject   string
+		scope     string
+		wantScope string
+		wantErr   error
+	}{
+		{"granted scope kept", scoped.AccessToken, "", "orders:read orders:write", nil},
+		{"narrowed", scoped.AccessToken, "orders:read", "orders:read", nil},
+		{"widened", scoped.AccessToken, "orders:read users:write", "", common.ErrInvalidScope},
+		{"nothing granted", unscoped.AccessToken, "orders:read", "", common.ErrInvalidScope},
+		{"nothing granted nor asked", unscoped.AccessToken, "", "", nil},
+		{"client token", client.AccessToken, "", "", common.ErrInvalidAccessToken},
+		{"invalid token", "token", "", "", common.ErrInvalidAccessToken},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tokens, err := a.ExchangeToken(ctx, &models.TokenExchange{
+				SubjectToken: test.subject,
+				ClientID:     "api",
+				Audience:     "orders",
+				Scope:        test.scope,
+			})
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tokens.Scope != test.wantScope {
+				t.Fatalf("got scope %q, want %q", tokens.Scope, test.wantScope)
+			}
+			claims, err := a.parseToken(tokens.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.UUID != user || claims.Audience != "orders" || claims.Act != nil {
+				t.Fatalf("got token of %s for %s acted by %v", claims.UUID, claims.Audience, claims.Act)
+			}
+		})
+	}
+}
+
+func TestExchangeImpersonation(t *testing.T) {
+	ctx := context.Background()
+	a, store := newTestAuthorizer(t)
+	staff := addTestUser(t, store, common.DefaultTenant, "+70000000001")
+	user := addTestUser(t, store, common.DefaultTenant, "+70000000002")
+	if err := store.SaveTenant(ctx, &models.Tenant{ID: "other", Hosts: []string{}}); err != nil {
+		t.Fatal(err)
+	}
+	stranger := addTestUser(t, store, "other", "+70000000003")
+	a.WithImpersonation([]string{staff}, store)
+
+	own, err := a.issueTokens(ctx, &models.RefreshToken{UUID: staff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ofClient, err := a.IssueTokens(ctx, staff, "app", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ofUser, err := a.issueTokens(ctx, &models.RefreshToken{UUID: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+	impersonated, err := a.ExchangeToken(ctx, &models.TokenExchange{SubjectToken: own.AccessToken, RequestedSubject: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		subject string
+		request string
+		wantErr error
+	}{
+		{"staff", own.AccessToken, user, nil},
+		{"staff through a client", ofClient.AccessToken, user, common.ErrImpersonationForbidden},
+		{"not staff", ofUser.AccessToken, staff, common.ErrImpersonationForbidden},
+		{"impersonated already", impersonated.AccessToken, staff, common.ErrImpersonationForbidden},
+		{"unknown user", own.AccessToken, newID(), common.ErrUserNotFound},
+		{"user of another tenant", own.AccessToken, stranger, common.ErrUserNotFound},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tokens, err := a.ExchangeToken(ctx, &models.TokenExchange{
+				SubjectToken:     test.subject,
+				ClientID:         "api",
+				RequestedSubject: test.request,
+			})
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			claims, err := a.parseToken(tokens.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.UUID != test.request || claims.Act == nil || claims.Act.Sub != staff {
+				t.Fatalf("got token of %s acted by %v", claims.UUID, claims.Act)
+			}
+		})
+	}
+
+	a.WithImpersonation([]string{staff}, failingAudit{})
+	req := models.TokenExchange{SubjectToken: own.AccessToken, RequestedSubject: user}
+	if _, err = a.ExchangeToken(ctx, &req); err == nil {
+		t.Fatal("impersonated with the audit log down")
+	}
+	a.WithImpersonation([]string{staff}, nil)
+	if _, err = a.ExchangeToken(ctx, &req); !errors.Is(err, common.ErrImpersonationForbidden) {
+		t.Fatalf("got %v impersonating without an audit log, want %v", err, common.ErrImpersonationForbidden)
+	}
+}
@@ -0,0 +1,47 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+type UserStore interface {
+	GetUserByUUID(ctx context.Context, uuid string) (*models.User, error)
+}
+
+// Identify validates the access token and resolves the user it was issued to.
+// Tokens of users no longer present are treated as invalid.
+func (a *Authorizer) Identify(ctx context.Context, accessToken string) (*models.Identity, error) {
+	claims, err := a.parseValidToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	identity := models.Identity{
+		UUID:     claims.UUID,
+		Scopes:   strings.Fields(claims.Scope),
+		ClientID: claims.ClientID,
+		Roles:    claims.Roles,
+		TenantID: claims.tenant(),
+	}
+	if claims.Act != nil {
+		identity.Actor = claims.Act.Sub
+	}
+	if claims.UUID == "" {
+		return &identity, nil
+	}
+	user, err := a.users.GetUserByUUID(ctx, claims.UUID)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrUserNotFound):
+		return nil, fmt.Errorf("%w: %s", common.ErrInvalidAccessToken, err)
+	default:
+		return nil, fmt.Errorf("err getting user %s: %w", claims.UUID, err)
+	}
+	identity.Phone = user.Phone
+	return &identity, nil
+}
@@ -0,0 +1,89 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+const (
+	TokenTypeHintAccess  = "access_token"
+	TokenTypeHintRefresh = "refresh_token"
+)
+
+// Introspect describes the token as RFC 7662 does. Tokens that are invalid for any reason are inactive,
+// errors are only returned if it couldn't be checked. hint is only an order to try token types in.
+func (a *Authorizer) Introspect(ctx context.Context, token, hint string) (*models.Introspection, error) {
+	try := []func(context.Context, string) (*models.Introspection, error){a.introspectAccess, a.introspectRefresh}
+	if hint == TokenTypeHintRefresh {
+		try[0], try[1] = try[1], try[0]
+	}
+	for _, introspect := range try {
+		result, err := introspect(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		if result.Active {
+			return result, nil
+		}
+	}
+	return &models.Introspection{Active: false}, nil
+}
+
+func (a *Authorizer) introspectAccess(ctx context.Context, token string) (*models.Introspection, error) {
+	claims, err := a.parseValidToken(ctx, token)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidAccessToken), errors.Is(err, common.ErrTokenRevoked):
+		return &models.Introspection{Active: false}, nil
+	default:
+		return nil, err
+	}
+	return &models.Introspection{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		TokenType: "Bearer",
+		Exp:       claims.ExpiresAt,
+		Iat:       claims.IssuedAt,
+		Sub:       claims.Subject,
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.Id,
+		UUID:      claims.UUID,
+		Tid:       claims.tenant(),
+		Act:       claims.Act,
+		Roles:     claims.Roles,
+	}, nil
+}
+
+func (a *Authorizer) introspectRefresh(ctx context.Context, token string) (*models.Introspection, error) {
+	stored, err := a.refreshTokens.GetRefreshToken(ctx, hashRefreshToken(token))
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidRefreshToken):
+		return &models.Introspection{Active: false}, nil
+	default:
+		return nil, err
+	}
+	if stored.Used || stored.Revoked || time.Now().UTC().After(stored.Expires) || !servesTenant(ctx, storedTenant(stored)) {
+		return &models.Introspection{Active: false}, nil
+	}
+	issuer, _, err := a.signing(stored.TenantID)
+	if err != nil {
+		return &models.Introspection{Active: false}, nil
+	}
+	return &models.Introspection{
+		Active:    true,
+		TokenType: TokenTypeHintRefresh,
+		Exp:       stored.Expires.Unix(),
+		Iat:       stored.Created.Unix(),
+		Sub:       stored.UUID,
+		Iss:       issuer,
+		UUID:      stored.UUID,
+		Tid:       storedTenant(stored),
+	}, nil
+}
@@ -0,0 +1,50 @@
+package authorization
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+// JWKS returns the public keys tokens of the tenant the request is served for are signed with, for the verifiers
+// to check tokens locally.
+func (a *Authorizer) JWKS(ctx context.Context) models.JSONWebKeySet {
+	_, keyring, err := a.signing(common.RequestTenant(ctx))
+	if err != nil {
+		return models.JSONWebKeySet{Keys: []models.JSONWebKey{}}
+	}
+	keys := keyring.PublicKeys()
+	set := models.JSONWebKeySet{Keys: make([]models.JSONWebKey, 0, len(keys))}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, publicJWK(key, kid))
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+func publicJWK(key *rsa.PublicKey, kid string) models.JSONWebKey {
+	return models.JSONWebKey{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// keyID is the RFC 7638 thumbprint of the key, so it stays the same across restarts and instances.
+func keyID(key *rsa.PublicKey) string {
+	jwk := publicJWK(key, "")
+	// members in lexicographic order, no whitespace
+	canonical := fmt.Sprintf(`{"e":"%s","kty":"%s","n":"%s"}`, jwk.E, jwk.Kty, jwk.N)
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
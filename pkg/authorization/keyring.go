@@ -0,0 +1,420 @@
+package authorization
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	keyBits        = 2048
+	keyFileExt     = ".pem"
+	keyFileTimeFmt = "20060102T150405Z"
+	// rotateLockFile is held by the instance rotating the keys of a directory shared by several of them.
+	rotateLockFile = ".rotate.lock"
+	// staleLockAge is when a lock left by a rotation that didn't finish is taken over, rotating takes a second.
+	staleLockAge = time.Minute
+)
+
+// JWKSMaxAge is how long verifiers may cache the key set. Rotated keys are published that long before they sign.
+const JWKSMaxAge = time.Hour
+
+// Keyring holds the key tokens are signed with and the keys they are verified with, all keyed by kid.
+//
+// Loaded from a directory, every *.pem file is a key. Files with a private key sign from the time they are named
+// after, the time they were written if they aren't, the latest to have started is the active one. Rotation writes
+// a new private key named after the time it starts signing at, JWKSMaxAge from now, so that verifiers have it
+// by then and the previous keys keep verifying the tokens issued before it. Keys named after a time to come only
+// verify until then. Public-only files just verify.
+type Keyring struct {
+	log          *logrus.Entry
+	dir          string
+	publishAhead time.Duration
+	mu           sync.RWMutex
+	activeKID    string
+	active       *rsa.PrivateKey
+	public       map[string]*rsa.PublicKey
+}
+
+// NewKeyring creates a keyring of the single key, unable to rotate.
+func NewKeyring(log *logrus.Logger, key *rsa.PrivateKey) *Keyring {
+	kid := keyID(&key.PublicKey)
+	return &Keyring{
+		log:       log.WithField("module", "keyring"),
+		activeKID: kid,
+		active:    key,
+		public:    map[string]*rsa.PublicKey{kid: &key.PublicKey},
+	}
+}
+
+// MustGetKeyring creates a keyring of the base64 encoded PEM of a single private key.
+func MustGetKeyring(log *logrus.Logger, encodedKey string) *Keyring {
+	return NewKeyring(log, mustGetPrivateKey(encodedKey))
+}
+
+// LoadKeyring reads the keys from the directory.
+func LoadKeyring(log *logrus.Logger, dir string) (*Keyring, error) {
+	k := Keyring{
+		log:          log.WithField("module", "keyring"),
+		dir:          dir,
+		publishAhead: JWKSMaxAge,
+	}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// Signer returns the active key and its kid.
+func (k *Keyring) Signer() (string, *rsa.PrivateKey) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.activeKID, k.active
+}
+
+func (k *Keyring) PublicKey(kid string) (*rsa.PublicKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.public[kid]
+	return key, ok
+}
+
+// PublicKeys returns all the verification keys by kid.
+func (k *Keyring) PublicKeys() map[string]*rsa.PublicKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	result := make(map[string]*rsa.PublicKey, len(k.public))
+	for kid, key := range k.public {
+		result[kid] = key
+	}
+	return result
+}
+
+// Reload rereads the directory. The keyring is left as is on errors.
+func (k *Keyring) Reload() error {
+	if k.dir == "" {
+		return nil
+	}
+	files, err := k.keyFiles()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var activeKID, pendingKID string
+	var active, pending *rsa.PrivateKey
+	public := make(map[string]*rsa.PublicKey, len(files))
+	for _, file := range files {
+		kid := keyID(file.public)
+		public[kid] = file.public
+		switch {
+		case file.private == nil:
+		case file.activates.After(now):
+			if pending == nil {
+				pendingKID, pending = kid, file.private
+			}
+		default:
+			activeKID, active = kid, file.private
+		}
+	}
+	// with nothing signing yet there is nobody to publish the key to in advance
+	if active == nil {
+		activeKID, active = pendingKID, pending
+	}
+	if active == nil {
+		return fmt.Errorf("err no private key in %s", k.dir)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if activeKID != k.activeKID {
+		k.log.Infof("signing with key %s, %d keys verify", activeKID, len(public))
+	}
+	k.activeKID, k.active, k.public = activeKID, active, public
+	return nil
+}
+
+// Rotate publishes a new key signing from JWKSMaxAge on. The previous keys are kept for verification.
+// Instances sharing the directory rotate one at a time.
+func (k *Keyring) Rotate() error {
+	if k.dir == "" {
+		return errors.New("err keyring without directory can't rotate")
+	}
+	unlock, err := k.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return k.rotate()
+}
+
+func (k *Keyring) rotate() error {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return fmt.Errorf("err generating key: %w", err)
+	}
+	name := time.Now().UTC().Add(k.publishAhead).Format(keyFileTimeFmt) + keyFileExt
+	path := filepath.Join(k.dir, name)
+	if _, err = os.Stat(path); err == nil {
+		return fmt.Errorf("err key %s exists", name)
+	}
+	// written aside and renamed not to be read half written by another instance
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err = os.WriteFile(path+".tmp", pem.EncodeToMemory(block), 0o600); err != nil {
+		return fmt.Errorf("err writing key: %w", err)
+	}
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("err writing key: %w", err)
+	}
+	k.log.Infof("key %s published, signs from %s on", keyID(&key.PublicKey), strings.TrimSuffix(name, keyFileExt))
+	return k.Reload()
+}
+
+// lock takes the rotation lock of the directory, failing with common.ErrKeysRotating if another instance holds it.
+func (k *Keyring) lock() (func(), error) {
+	path := filepath.Join(k.dir, rotateLockFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		if info, e := os.Stat(path); e == nil && time.Since(info.ModTime()) > staleLockAge {
+			k.log.Warnf("taking over stale rotation lock %s", path)
+			if e = os.Remove(path); e == nil {
+				f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+			}
+		}
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrExist):
+		return nil, common.ErrKeysRotating
+	default:
+		return nil, fmt.Errorf("err locking keys: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		k.log.Warnf("err closing rotation lock: %v", err)
+	}
+	return func() {
+		if e := os.Remove(path); e != nil {
+			k.log.Warnf("err removing rotation lock: %v", e)
+		}
+	}, nil
+}
+
+// Watch reloads the keyring every reloadInterval until ctx is done. A new key is published once the newest
+// one is older than rotateEvery, if it's not zero, and keys that stopped signing longer than retain ago are
+// removed, if it's not zero either. retain is to be the longest lifetime of the tokens signed.
+func (k *Keyring) Watch(ctx context.Context, reloadInterval, rotateEvery, retain time.Duration) {
+	if k.dir == "" {
+		return
+	}
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := k.Reload(); err != nil {
+			k.log.Warnf("err reloading keys: %v", err)
+			continue
+		}
+		if rotateEvery != 0 {
+			if err := k.rotateIfDue(rotateEvery); err != nil && !errors.Is(err, common.ErrKeysRotating) {
+				k.log.Warnf("err rotating keys: %v", err)
+			}
+		}
+		if retain != 0 {
+			if err := k.prune(retain); err != nil && !errors.Is(err, common.ErrKeysRotating) {
+				k.log.Warnf("err removing expired keys: %v", err)
+			}
+		}
+	}
+}
+
+// rotateIfDue rotates the keys if the newest one, published or signing, is older than rotateEvery. It's checked
+// again under the lock, another instance may have rotated them in between.
+func (k *Keyring) rotateIfDue(rotateEvery time.Duration) error {
+	due := func() (bool, error) {
+		keys, err := k.privateKeys()
+		if err != nil || len(keys) == 0 {
+			return false, err
+		}
+		return time.Since(keys[len(keys)-1].activates) > rotateEvery, nil
+	}
+	if ok, err := due(); !ok || err != nil {
+		return err
+	}
+	unlock, err := k.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if ok, err := due(); !ok || err != nil {
+		return err
+	}
+	return k.rotate()
+}
+
+// prune removes the private keys replaced by the next one longer than retain ago, the tokens they signed have
+// expired. Public-only keys are left, they are put in the directory by hand.
+func (k *Keyring) prune(retain time.Duration) error {
+	keys, err := k.privateKeys()
+	if err != nil {
+		return err
+	}
+	expired := 0
+	for i := 0; i+1 < len(keys); i++ {
+		if time.Since(keys[i+1].activates) > retain {
+			expired = i + 1
+		}
+	}
+	if expired == 0 {
+		return nil
+	}
+	unlock, err := k.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, key := range keys[:expired] {
+		if err = os.Remove(key.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("err removing key: %w", err)
+		}
+		k.log.Infof("expired key %s removed", filepath.Base(key.path))
+	}
+	return k.Reload()
+}
+
+type keyFile struct {
+	path    string
+	private *rsa.PrivateKey
+	public  *rsa.PublicKey
+	// activates is when a private key signs from.
+	activates time.Time
+}
+
+// keyFiles reads the keys of the directory ordered by the time they sign from, the time they were written
+// for keys not named after a time.
+func (k *Keyring) keyFiles() ([]keyFile, error) {
+	paths, err := filepath.Glob(filepath.Join(k.dir, "*"+keyFileExt))
+	if err != nil {
+		return nil, fmt.Errorf("err listing keys: %w", err)
+	}
+	sort.Strings(paths)
+	result := make([]keyFile, 0, len(paths))
+	for _, path := range paths {
+		private, public, e := readKeyFile(path)
+		if e != nil {
+			return nil, fmt.Errorf("err reading key %s: %w", path, e)
+		}
+		activates, ok := keyActivation(path)
+		if !ok {
+			info, e := os.Stat(path)
+			if e != nil {
+				return nil, fmt.Errorf("err reading key %s: %w", path, e)
+			}
+			activates = info.ModTime()
+		}
+		result = append(result, keyFile{path: path, private: private, public: public, activates: activates})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].activates.Before(result[j].activates) })
+	return result, nil
+}
+
+// privateKeys lists the files of private keys ordered by the time they sign from, see keyFiles.
+func (k *Keyring) privateKeys() ([]keyFile, error) {
+	files, err := k.keyFiles()
+	if err != nil {
+		return nil, err
+	}
+	result := files[:0]
+	for _, file := range files {
+		if file.private != nil {
+			result = append(result, file)
+		}
+	}
+	return result, nil
+}
+
+// keyActivation parses the time the key signs from out of its file name, false if it isn't named after one.
+func keyActivation(path string) (time.Time, bool) {
+	activates, err := time.Parse(keyFileTimeFmt, strings.TrimSuffix(filepath.Base(path), keyFileExt))
+	return activates, err == nil
+}
+
+// RotateKeys makes a new key active for the tenant the request is served for, see Keyring.Rotate.
+// Tenants without keys of their own rotate the service's ones.
+func (a *Authorizer) RotateKeys(ctx context.Context) error {
+	_, keys, err := a.signing(common.RequestTenant(ctx))
+	if err != nil {
+		return err
+	}
+	return keys.Rotate()
+}
+
+// ReloadKeys rereads the keys, the tenants' ones along, see Keyring.Reload.
+func (a *Authorizer) ReloadKeys(ctx context.Context) error {
+	if err := a.keys.Reload(); err != nil {
+		return err
+	}
+	if a.tenants == nil {
+		return nil
+	}
+	return a.tenants.Reload(ctx)
+}
+
+func readKeyFile(path string) (*rsa.PrivateKey, *rsa.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, nil, errors.New("unable to decode key to blocks")
+	}
+	switch strings.ToUpper(block.Type) {
+	case "RSA PRIVATE KEY":
+		key, e := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if e != nil {
+			return nil, nil, e
+		}
+		return key, &key.PublicKey, nil
+	case "PRIVATE KEY":
+		parsed, e := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if e != nil {
+			return nil, nil, e
+		}
+		key, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, nil, errors.New("not an rsa key")
+		}
+		return key, &key.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		key, e := x509.ParsePKCS1PublicKey(block.Bytes)
+		return nil, key, e
+	case "PUBLIC KEY":
+		parsed, e := x509.ParsePKIXPublicKey(block.Bytes)
+		if e != nil {
+			return nil, nil, e
+		}
+		key, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			return nil, nil, errors.New("not an rsa key")
+		}
+		return nil, key, nil
+	default:
+		return nil, nil, fmt.Errorf("unexpected pem block %s", block.Type)
+	}
+}
@@ -0,0 +1,165 @@
+package authorization
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/sirupsen/logrus"
+)
+
+// TestKeyringRotate checks that a rotated key is published while the previous one keeps signing.
+func TestKeyringRotate(t *testing.T) {
+	dir := t.TempDir()
+	signing := writeKey(t, dir, time.Now().Add(-time.Hour))
+	k, err := LoadKeyring(logrus.New(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = k.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if kid, _ := k.Signer(); kid != signing {
+		t.Fatalf("got key %s signing right after rotation, want %s", kid, signing)
+	}
+	if keys := k.PublicKeys(); len(keys) != 2 {
+		t.Fatalf("got %d keys published, want 2", len(keys))
+	}
+	if err = k.rotateIfDue(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if keys := k.PublicKeys(); len(keys) != 2 {
+		t.Fatalf("rotated again while a key is published, got %d keys", len(keys))
+	}
+
+	// past the max age of the key set the new key signs
+	k.publishAhead = 0
+	pending := writeKey(t, dir, time.Now().Add(-time.Second))
+	if err = k.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if kid, _ := k.Signer(); kid != pending {
+		t.Fatalf("got key %s signing, want %s", kid, pending)
+	}
+}
+
+func TestKeyringLock(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, time.Now().Add(-time.Hour))
+	first, err := LoadKeyring(logrus.New(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := LoadKeyring(logrus.New(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := first.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = second.Rotate(); !errors.Is(err, common.ErrKeysRotating) {
+		t.Fatalf("got %v rotating while locked, want %v", err, common.ErrKeysRotating)
+	}
+	unlock()
+	if err = second.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a lock left behind is taken over
+	path := filepath.Join(dir, rotateLockFile)
+	if err = os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-2 * staleLockAge)
+	if err = os.Chtimes(path, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	if unlock, err = first.lock(); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+// TestKeyringPrune checks that only the keys replaced longer than the tokens live are removed.
+func TestKeyringPrune(t *testing.T) {
+	dir := t.TempDir()
+	expired := writeKey(t, dir, time.Now().Add(-3*time.Hour))
+	verifying := writeKey(t, dir, time.Now().Add(-2*time.Hour))
+	signing := writeKey(t, dir, time.Now().Add(-time.Minute))
+	k, err := LoadKeyring(logrus.New(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = k.prune(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := k.PublicKey(expired); ok {
+		t.Fatal("expired key left")
+	}
+	for _, kid := range []string{verifying, signing} {
+		if _, ok := k.PublicKey(kid); !ok {
+			t.Fatalf("key %s removed", kid)
+		}
+	}
+}
+
+// TestKeyringUndated checks that a key not named after a time is ordered by the time it was written, not by name.
+func TestKeyringUndated(t *testing.T) {
+	dir := t.TempDir()
+	undated := writeKeyFile(t, filepath.Join(dir, "signing"+keyFileExt))
+	written := time.Now().Add(-3 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "signing"+keyFileExt), written, written); err != nil {
+		t.Fatal(err)
+	}
+	rotated := writeKey(t, dir, time.Now().Add(-time.Hour))
+	k, err := LoadKeyring(logrus.New(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid, _ := k.Signer(); kid != rotated {
+		t.Fatalf("got key %s signing, want the rotated one %s", kid, rotated)
+	}
+	if err = k.rotateIfDue(2 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if keys := k.PublicKeys(); len(keys) != 2 {
+		t.Fatalf("rotated while the rotated key is fresh, got %d keys", len(keys))
+	}
+	if err = k.prune(30 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := k.PublicKey(undated); ok {
+		t.Fatal("replaced undated key left")
+	}
+	if _, ok := k.PublicKey(rotated); !ok {
+		t.Fatal("signing key removed")
+	}
+}
+
+// writeKey writes a private key signing from activates on and returns its kid.
+func writeKey(t *testing.T, dir string, activates time.Time) string {
+	t.Helper()
+	return writeKeyFile(t, filepath.Join(dir, activates.UTC().Format(keyFileTimeFmt)+keyFileExt))
+}
+
+// writeKeyFile writes a private key to path and returns its kid.
+func writeKeyFile(t *testing.T, path string) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err = os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return keyID(&key.PublicKey)
+}
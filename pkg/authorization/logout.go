@@ -0,0 +1,46 @@
+package authorization
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type Revocations interface {
+	IsRevoked(ctx context.Context, jti, uuid string, issuedAt time.Time) (bool, error)
+	RevokeToken(ctx context.Context, jti string, expires time.Time) error
+	RevokeUser(ctx context.Context, uuid string) error
+}
+
+// Logout revokes the access token and the refresh tokens of its session.
+func (a *Authorizer) Logout(ctx context.Context, accessToken string) error {
+	claims, err := a.parseUserToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	if err = a.revocations.RevokeToken(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return fmt.Errorf("err revoking token %s: %w", claims.Id, err)
+	}
+	if claims.SessionID == "" {
+		return nil
+	}
+	if err = a.refreshTokens.RevokeTokenFamily(ctx, claims.SessionID); err != nil {
+		return fmt.Errorf("err revoking session %s: %w", claims.SessionID, err)
+	}
+	return nil
+}
+
+// LogoutAll revokes every token of the token's user issued so far, on all devices.
+func (a *Authorizer) LogoutAll(ctx context.Context, accessToken string) error {
+	claims, err := a.parseUserToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	if err = a.refreshTokens.RevokeUserRefreshTokens(ctx, claims.UUID); err != nil {
+		return fmt.Errorf("err revoking refresh tokens of %s: %w", claims.UUID, err)
+	}
+	if err = a.revocations.RevokeUser(ctx, claims.UUID); err != nil {
+		return fmt.Errorf("err revoking tokens of %s: %w", claims.UUID, err)
+	}
+	return nil
+}
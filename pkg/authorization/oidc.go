@@ -0,0 +1,104 @@
+package authorization
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/golang-jwt/jwt"
+)
+
+// IDTokenClaims are the claims of OpenID Connect id tokens.
+type IDTokenClaims struct {
+	jwt.StandardClaims
+	AuthTime            int64  `json:"auth_time,omitempty"`
+	Nonce               string `json:"nonce,omitempty"`
+	PhoneNumber         string `json:"phone_number,omitempty"`
+	PhoneNumberVerified bool   `json:"phone_number_verified,omitempty"`
+}
+
+// IDToken creates an id token of the user for the client, signed for the user's tenant. The phone is only
+// included for the phone scope, it's always verified as users sign in with it.
+func (a *Authorizer) IDToken(
+	ctx context.Context,
+	userUUID, clientID, scope, nonce string,
+	authTime time.Time,
+) (string, error) {
+	user, err := a.users.GetUserByUUID(ctx, userUUID)
+	if err != nil {
+		return "", fmt.Errorf("err getting user %s: %w", userUUID, err)
+	}
+	issuer, keys, err := a.signing(user.TenantID)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	claims := IDTokenClaims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  clientID,
+			ExpiresAt: now.Add(a.config.AccessTTL).Unix(),
+			IssuedAt:  now.Unix(),
+			Issuer:    issuer,
+			Subject:   userUUID,
+		},
+		AuthTime: authTime.Unix(),
+		Nonce:    nonce,
+	}
+	if HasScope(scope, models.ScopePhone) {
+		claims.PhoneNumber = user.Phone
+		claims.PhoneNumberVerified = true
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &claims)
+	kid, key := keys.Signer()
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("err signing id token: %w", err)
+	}
+	return signed, nil
+}
+
+// OpenIDConfiguration describes the provider for the tenant the request is served for, the issuer has to be
+// the url the tenant is reachable at.
+func (a *Authorizer) OpenIDConfiguration(ctx context.Context) models.OpenIDConfiguration {
+	iss, _, err := a.signing(common.RequestTenant(ctx))
+	if err != nil {
+		iss = a.config.Issuer
+	}
+	issuer := strings.TrimSuffix(iss, "/")
+	return models.OpenIDConfiguration{
+		Issuer:                      iss,
+		AuthorizationEndpoint:       issuer + "/oauth/authorize",
+		TokenEndpoint:               issuer + "/oauth/token",
+		UserinfoEndpoint:            issuer + "/userinfo",
+		JWKSURI:                     issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:       issuer + "/oauth/introspect",
+		DeviceAuthorizationEndpoint: issuer + "/oauth/device_authorization",
+		ScopesSupported:             []string{models.ScopeOpenID, models.ScopePhone},
+		ResponseTypesSupported:      []string{"code"},
+		GrantTypesSupported: []string{
+			"authorization_code", "refresh_token", "client_credentials", "urn:ietf:params:oauth:grant-type:device_code",
+			"urn:ietf:params:oauth:grant-type:token-exchange",
+		},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodRS256.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "phone_number", "phone_number_verified",
+		},
+	}
+}
+
+// HasScope tells if the space separated scope contains the one.
+func HasScope(scope, one string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == one {
+			return true
+		}
+	}
+	return false
+}
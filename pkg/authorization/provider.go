@@ -8,6 +8,7 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/gerladeno/authorization-service/pkg/common"
 	"github.com/gerladeno/authorization-service/pkg/models"
@@ -15,59 +16,242 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Authenticator sends codes to phones and checks them. Both get the challenge of the code, those sending
+// through one of several providers name the one that sent it there, see models.Challenge.SetProvider.
 type Authenticator interface {
-	Authenticate(ctx context.Context, phone, code string) error
-	VerifyPhone(ctx context.Context, phone string) error
+	Authenticate(ctx context.Context, challenge *models.Challenge, phone, code string) error
+	VerifyPhone(ctx context.Context, challenge *models.Challenge, phone string) error
 }
 
+type VerificationSessions interface {
+	Start(ctx context.Context, phone, channel string, send func(ctx context.Context, challenge *models.Challenge) error) error
+	Verify(ctx context.Context, phone string, check func(ctx context.Context, challenge *models.Challenge) error) error
+}
+
+const (
+	ChannelFlashCall = "flashcall"
+	ChannelSMS       = "sms"
+	ChannelAuto      = "auto"
+	DefaultChannel   = ChannelFlashCall
+)
+
+// Subject types tell user tokens from the ones issued to OAuth clients for themselves.
+const (
+	SubjectUser   = "user"
+	SubjectClient = "client"
+)
+
 type Claims struct {
 	jwt.StandardClaims
+	// UUID is the user, empty for client tokens whose subject is the client.
 	UUID string `json:"uuid"`
+	// TenantID is the tenant of the user, or the one the client token was requested for. Empty in tokens
+	// issued before tenants were introduced, meaning the default one.
+	TenantID string `json:"tid,omitempty"`
+	// SessionID is the family of the refresh token issued along, empty for tokens issued by GetToken.
+	SessionID string `json:"sid,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	// SubjectType is SubjectUser or SubjectClient, tokens issued before it was introduced are user ones.
+	SubjectType string `json:"sub_type,omitempty"`
+	// Act is set for impersonation tokens, telling who really acts.
+	Act *models.Actor `json:"act,omitempty"`
+	// Roles of the user are set for tokens of the service's own sign in, their permissions make the scope.
+	Roles []string `json:"roles,omitempty"`
+	// IssuedAtMicro is iat to the microsecond, to tell tokens issued right after the user's were revoked
+	// from the revoked ones. Tokens issued before it was introduced have iat only.
+	IssuedAtMicro int64 `json:"iat_us,omitempty"`
+}
+
+// tenant returns the tenant of the token, the default one for tokens without tid.
+func (c *Claims) tenant() string {
+	if c.TenantID == "" {
+		return common.DefaultTenant
+	}
+	return c.TenantID
+}
+
+// issued returns the time the token was issued at, as precisely as it's known.
+func (c *Claims) issued() time.Time {
+	if c.IssuedAtMicro != 0 {
+		return time.UnixMicro(c.IssuedAtMicro)
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// IsClient tells if the token was issued to an OAuth client for itself rather than for a user.
+func (c *Claims) IsClient() bool {
+	return c.SubjectType == SubjectClient
+}
+
+type Config struct {
+	Issuer     string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
 }
 
 type Authorizer struct {
-	log  *logrus.Entry
-	auth Authenticator
-	key  *rsa.PrivateKey
+	log            *logrus.Entry
+	channels       map[string]Authenticator
+	defaultChannel string
+	sessions       VerificationSessions
+	users          UserStore
+	refreshTokens  RefreshTokenStore
+	revocations    Revocations
+	keys           *Keyring
+	config         Config
+	impersonators  map[string]bool
+	audit          AuditLog
+	roles          RoleStore
+	tenants        *Tenants
 }
 
-func New(log *logrus.Logger, auth Authenticator, key string) *Authorizer {
+// New creates an Authorizer able to send codes through any of the channels, keyed by channel name.
+func New(
+	log *logrus.Logger,
+	channels map[string]Authenticator,
+	sessions VerificationSessions,
+	users UserStore,
+	refreshTokens RefreshTokenStore,
+	revocations Revocations,
+	keys *Keyring,
+	config Config,
+) *Authorizer {
 	a := Authorizer{
-		log:  log.WithField("module", "authorizer"),
-		auth: auth,
-		key:  mustGetPrivateKey(key),
+		log:            log.WithField("module", "authorizer"),
+		channels:       channels,
+		defaultChannel: DefaultChannel,
+		sessions:       sessions,
+		users:          users,
+		refreshTokens:  refreshTokens,
+		revocations:    revocations,
+		keys:           keys,
+		config:         config,
 	}
 	return &a
 }
 
-func (a *Authorizer) SignIn(ctx context.Context, user *models.User, code string) (string, error) {
-	err := a.auth.Authenticate(ctx, user.Phone, code)
+// WithDefaultChannel sets the channel used when a request doesn't name one.
+func (a *Authorizer) WithDefaultChannel(channel string) *Authorizer {
+	if _, ok := a.channels[channel]; !ok {
+		panic("unknown default channel " + channel)
+	}
+	a.defaultChannel = channel
+	return a
+}
+
+func (a *Authorizer) SignIn(ctx context.Context, user *models.User, code string) (*models.Tokens, error) {
+	if err := a.VerifyCode(ctx, user, code); err != nil {
+		return nil, err
+	}
+	return a.issueTokens(ctx, &models.RefreshToken{UUID: user.UUID, TenantID: user.TenantID})
+}
+
+// VerifyCode checks the code sent to the user by StartAuthentication.
+func (a *Authorizer) VerifyCode(ctx context.Context, user *models.User, code string) error {
+	err := a.sessions.Verify(ctx, challengeKey(user), func(ctx context.Context, challenge *models.Challenge) error {
+		channel, _ := challenge.SplitChannel()
+		auth, err := a.channel(channel)
+		if err != nil {
+			return err
+		}
+		return auth.Authenticate(ctx, challenge, user.Phone, code)
+	})
 	switch {
 	case err == nil:
-	case errors.Is(err, common.ErrUnauthenticated):
-		return "", err
+	case errors.Is(err, common.ErrUnauthenticated),
+		errors.Is(err, common.ErrInvalidCode),
+		errors.Is(err, common.ErrCodeExpired),
+		errors.Is(err, common.ErrAttemptsExhausted),
+		errors.Is(err, common.ErrChallengeNotFound):
+		return err
 	default:
 		err = fmt.Errorf("err authenticating %s: %w", user.Phone, err)
 		a.log.Warn(err)
+		return err
+	}
+	return nil
+}
+
+func (a *Authorizer) GetToken(ctx context.Context, uuid string) (string, error) {
+	tenantID, err := a.userTenant(ctx, uuid)
+	if err != nil {
 		return "", err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &Claims{
-		StandardClaims: jwt.StandardClaims{},
-		UUID:           user.UUID,
-	})
-	return token.SignedString(a.key)
+	if !servesTenant(ctx, tenantID) {
+		return "", fmt.Errorf("%w: %s", common.ErrUserNotFound, uuid)
+	}
+	claims := userClaims(uuid, tenantID, "", "", "")
+	if err = a.grantRoles(ctx, claims); err != nil {
+		return "", err
+	}
+	token, _, err := a.accessToken(claims)
+	return token, err
 }
 
-func (a *Authorizer) GetToken(uuid string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &Claims{
-		StandardClaims: jwt.StandardClaims{},
+func userClaims(uuid, tenantID, sessionID, clientID, scope string) *Claims {
+	return &Claims{
+		StandardClaims: jwt.StandardClaims{Subject: uuid},
 		UUID:           uuid,
+		TenantID:       tenantID,
+		SessionID:      sessionID,
+		Scope:          scope,
+		ClientID:       clientID,
+		SubjectType:    SubjectUser,
+	}
+}
+
+// accessToken signs the claims with the keys of their tenant, filling in id, issuer and times.
+func (a *Authorizer) accessToken(claims *Claims) (string, time.Time, error) {
+	issuer, keys, err := a.signing(claims.TenantID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now().UTC()
+	expires := now.Add(a.config.AccessTTL)
+	claims.Id = newID()
+	claims.ExpiresAt = expires.Unix()
+	claims.IssuedAt = now.Unix()
+	claims.IssuedAtMicro = now.UnixMicro()
+	claims.Issuer = issuer
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	kid, key := keys.Signer()
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("err signing token: %w", err)
+	}
+	return signed, expires, nil
+}
+
+// StartAuthentication sends a code to the user through the channel, the default one if it's empty.
+func (a *Authorizer) StartAuthentication(ctx context.Context, user *models.User, channel string) error {
+	if channel == "" {
+		channel = a.defaultChannel
+	}
+	auth, err := a.channel(channel)
+	if err != nil {
+		return err
+	}
+	return a.sessions.Start(ctx, challengeKey(user), channel, func(ctx context.Context, challenge *models.Challenge) error {
+		return auth.VerifyPhone(ctx, challenge, user.Phone)
 	})
-	return token.SignedString(a.key)
 }
 
-func (a *Authorizer) StartAuthentication(ctx context.Context, user *models.User) error {
-	return a.auth.VerifyPhone(ctx, user.Phone)
+// challengeKey keeps the codes sent to the same phone for different tenants apart.
+func challengeKey(user *models.User) string {
+	if tenantOf(user) == common.DefaultTenant {
+		return user.Phone
+	}
+	return user.TenantID + "/" + user.Phone
+}
+
+func (a *Authorizer) channel(name string) (Authenticator, error) {
+	auth, ok := a.channels[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", common.ErrUnknownChannel, name)
+	}
+	return auth, nil
 }
 
 func mustGetPrivateKey(encodedKey string) *rsa.PrivateKey {
@@ -89,22 +273,79 @@ func mustGetPrivateKey(encodedKey string) *rsa.PrivateKey {
 	return key
 }
 
-func (a *Authorizer) ParseToken(accessToken string) (string, error) {
-	return parseToken(accessToken, a.key)
+// ParseToken returns the uuid of the user the token was issued to if it's valid and not revoked.
+// Client tokens are not accepted, having no user.
+func (a *Authorizer) ParseToken(ctx context.Context, accessToken string) (string, error) {
+	claims, err := a.parseUserToken(ctx, accessToken)
+	if err != nil {
+		return "", err
+	}
+	return claims.UUID, nil
 }
 
-func parseToken(accessToken string, key *rsa.PrivateKey) (string, error) {
+func (a *Authorizer) parseUserToken(ctx context.Context, accessToken string) (*Claims, error) {
+	claims, err := a.parseValidToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.IsClient() {
+		return nil, fmt.Errorf("%w: client token of %s", common.ErrInvalidAccessToken, claims.ClientID)
+	}
+	return claims, nil
+}
+
+// parseValidToken parses the token unless it's revoked or of another tenant than the request is served for.
+func (a *Authorizer) parseValidToken(ctx context.Context, accessToken string) (*Claims, error) {
+	claims, err := a.parseToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if tenantID := claims.tenant(); !servesTenant(ctx, tenantID) {
+		return nil, fmt.Errorf("%w: token of tenant %s", common.ErrInvalidAccessToken, tenantID)
+	}
+	revoked, err := a.revocations.IsRevoked(ctx, claims.Id, claims.UUID, claims.issued())
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, common.ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// parseToken verifies the token with the keys and the issuer of the tenant it claims to be of.
+func (a *Authorizer) parseToken(accessToken string) (*Claims, error) {
+	var issuer string
 	token, err := jwt.ParseWithClaims(accessToken, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, common.ErrInvalidSigningMethod
 		}
-		return &key.PublicKey, nil
+		claims, ok := token.Claims.(*Claims)
+		if !ok {
+			return nil, common.ErrInvalidAccessToken
+		}
+		var keys *Keyring
+		var err error
+		if issuer, keys, err = a.signing(claims.TenantID); err != nil {
+			return nil, err
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.PublicKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		return key, nil
 	})
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("%w: %v", common.ErrInvalidAccessToken, err)
+	}
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, common.ErrInvalidAccessToken
 	}
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims.UUID, nil
+	// tokens issued before expiry was introduced must not live forever
+	if claims.ExpiresAt == 0 || claims.Id == "" || !claims.VerifyIssuer(issuer, true) {
+		return nil, common.ErrInvalidAccessToken
 	}
-	return "", common.ErrInvalidAccessToken
+	return claims, nil
 }
@@ -0,0 +1,153 @@
+package authorization
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+const refreshTokenBytes = 32
+
+type RefreshTokenStore interface {
+	SaveRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error)
+	// UseRefreshToken marks the token used, common.ErrRefreshTokenReused if it already was.
+	UseRefreshToken(ctx context.Context, hash string) error
+	RevokeTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, uuid string) error
+}
+
+// Refresh exchanges a refresh token issued to the OAuth client, empty for the service's own sign in, for a new pair
+// of tokens. Every refresh token can be used once, presenting a used one revokes all the tokens descending from
+// the same sign in. Tokens of other clients are refused before they are used up.
+func (a *Authorizer) Refresh(ctx context.Context, refreshToken, clientID string) (*models.Tokens, error) {
+	hash := hashRefreshToken(refreshToken)
+	stored, err := a.refreshTokens.GetRefreshToken(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Revoked || time.Now().UTC().After(stored.Expires) || !servesTenant(ctx, storedTenant(stored)) {
+		return nil, common.ErrInvalidRefreshToken
+	}
+	if stored.ClientID != clientID {
+		a.log.Warnf("client %q presented a refresh token of %q", clientID, stored.ClientID)
+		return nil, fmt.Errorf("%w: issued to another client", common.ErrInvalidRefreshToken)
+	}
+	err = a.refreshTokens.UseRefreshToken(ctx, hash)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrRefreshTokenReused):
+		a.log.Warnf("refresh token of %s reused, revoking family %s", stored.UUID, stored.FamilyID)
+		if e := a.refreshTokens.RevokeTokenFamily(ctx, stored.FamilyID); e != nil {
+			return nil, fmt.Errorf("err revoking token family %s: %w", stored.FamilyID, e)
+		}
+		return nil, err
+	default:
+		return nil, err
+	}
+	return a.issueTokens(ctx, stored)
+}
+
+// ClientToken creates an access token of the OAuth client itself, for the audience and the tenant the request
+// is served for. No refresh token is issued, clients get a new one the same way.
+func (a *Authorizer) ClientToken(ctx context.Context, clientID, audience, scope string) (*models.Tokens, error) {
+	access, accessExpires, err := a.accessToken(&Claims{
+		StandardClaims: jwt.StandardClaims{Subject: clientID, Audience: audience},
+		TenantID:       common.RequestTenant(ctx),
+		Scope:          scope,
+		ClientID:       clientID,
+		SubjectType:    SubjectClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.Tokens{
+		AccessToken:   access,
+		AccessExpires: accessExpires,
+		ClientID:      clientID,
+		Scope:         scope,
+	}, nil
+}
+
+// IssueTokens creates a pair of tokens for the user, signed in by other means, to the OAuth client.
+func (a *Authorizer) IssueTokens(ctx context.Context, userUUID, clientID, scope string) (*models.Tokens, error) {
+	tenantID, err := a.userTenant(ctx, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	return a.issueTokens(ctx, &models.RefreshToken{UUID: userUUID, TenantID: tenantID, ClientID: clientID, Scope: scope})
+}
+
+// issueTokens creates an access token and a refresh token continuing the family of the parent,
+// a new one if its FamilyID is empty. Tokens of the service's own sign in get the user's current roles.
+func (a *Authorizer) issueTokens(ctx context.Context, parent *models.RefreshToken) (*models.Tokens, error) {
+	familyID := parent.FamilyID
+	if familyID == "" {
+		familyID = newID()
+	}
+	claims := userClaims(parent.UUID, storedTenant(parent), familyID, parent.ClientID, parent.Scope)
+	if parent.ClientID == "" {
+		if err := a.grantRoles(ctx, claims); err != nil {
+			return nil, err
+		}
+	}
+	access, accessExpires, err := a.accessToken(claims)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, refreshTokenBytes)
+	if _, err = rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("err generating refresh token: %w", err)
+	}
+	refresh := base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now().UTC()
+	stored := models.RefreshToken{
+		Hash:     hashRefreshToken(refresh),
+		FamilyID: familyID,
+		UUID:     parent.UUID,
+		TenantID: claims.TenantID,
+		ClientID: parent.ClientID,
+		Scope:    parent.Scope,
+		Created:  now,
+		Expires:  now.Add(a.config.RefreshTTL),
+	}
+	if err = a.refreshTokens.SaveRefreshToken(ctx, &stored); err != nil {
+		return nil, fmt.Errorf("err saving refresh token: %w", err)
+	}
+	return &models.Tokens{
+		UUID:           parent.UUID,
+		AccessToken:    access,
+		AccessExpires:  accessExpires,
+		RefreshToken:   refresh,
+		RefreshExpires: stored.Expires,
+		ClientID:       parent.ClientID,
+		Scope:          claims.Scope,
+	}, nil
+}
+
+// storedTenant returns the tenant of the refresh token, the default one if it's empty.
+func storedTenant(token *models.RefreshToken) string {
+	if token.TenantID == "" {
+		return common.DefaultTenant
+	}
+	return token.TenantID
+}
+
+func newID() string {
+	return uuid.New().String()
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,87 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/gerladeno/authorization-service/pkg/profilestore"
+	"github.com/gerladeno/authorization-service/pkg/revocation"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestAuthorizer returns an Authorizer signing with a fresh key and keeping everything in memory.
+func newTestAuthorizer(t *testing.T) (*Authorizer, *profilestore.MemoryStore) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	dir := t.TempDir()
+	writeKey(t, dir, time.Now().Add(-time.Hour))
+	keys, err := LoadKeyring(logrus.New(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := profilestore.NewMemoryStore(ctx)
+	revocations, err := revocation.NewChecker(ctx, logrus.New(), store, revocation.Config{TokenTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{Issuer: "test", AccessTTL: time.Hour, RefreshTTL: time.Hour}
+	return New(logrus.New(), nil, nil, store, store, revocations, keys, config), store
+}
+
+// addTestUser adds a user of the tenant and returns its uuid.
+func addTestUser(t *testing.T, store *profilestore.MemoryStore, tenantID, phone string) string {
+	t.Helper()
+	user := models.User{UUID: newID(), TenantID: tenantID, Phone: phone}
+	if err := store.UpsertUser(context.Background(), &user); err != nil {
+		t.Fatal(err)
+	}
+	return user.UUID
+}
+
+// TestRefresh checks that refresh tokens rotate and that reusing one revokes every token of the sign in.
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	a, store := newTestAuthorizer(t)
+	user := addTestUser(t, store, common.DefaultTenant, "+70000000001")
+	first, err := a.IssueTokens(ctx, user, "app", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := map[string]string{"first": first.RefreshToken, "unknown": "unknown"}
+
+	for _, test := range []struct {
+		name     string
+		token    string
+		clientID string
+		// issued names the refresh token issued, if any
+		issued  string
+		wantErr error
+	}{
+		{"unknown token", "unknown", "app", "", common.ErrInvalidRefreshToken},
+		{"another client", "first", "other", "", common.ErrInvalidRefreshToken},
+		// refused for another client, the token is still unused
+		{"rotation", "first", "app", "second", nil},
+		{"rotated token", "second", "app", "third", nil},
+		{"reuse", "first", "app", "", common.ErrRefreshTokenReused},
+		{"family revoked on reuse", "third", "app", "", common.ErrInvalidRefreshToken},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			refreshed, err := a.Refresh(ctx, tokens[test.token], test.clientID)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if refreshed.RefreshToken == tokens[test.token] {
+				t.Fatal("refresh token not rotated")
+			}
+			tokens[test.issued] = refreshed.RefreshToken
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package authorization
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+// Permissions of the service itself.
+const (
+	// ScopeRolesWrite allows managing roles and their assignments at /admin/v1.
+	ScopeRolesWrite = "roles:write"
+	// ScopeImpersonate allows impersonating users by token exchange.
+	ScopeImpersonate = "users:impersonate"
+)
+
+// RoleStore gives the roles assigned to users.
+type RoleStore interface {
+	GetUserRoles(ctx context.Context, tenantID, uuid string) ([]models.Role, error)
+}
+
+// WithRoles makes tokens of the service's own sign in carry the user's roles, and their permissions as the scope.
+// Roles are looked up on every sign in and refresh, so changes apply with the next refresh.
+func (a *Authorizer) WithRoles(roles RoleStore) *Authorizer {
+	a.roles = roles
+	return a
+}
+
+// grantRoles puts the roles of the claims' user into them, and their permissions as the scope.
+func (a *Authorizer) grantRoles(ctx context.Context, claims *Claims) error {
+	if a.roles == nil {
+		return nil
+	}
+	roles, err := a.roles.GetUserRoles(ctx, claims.tenant(), claims.UUID)
+	if err != nil {
+		return fmt.Errorf("err getting roles of %s: %w", claims.UUID, err)
+	}
+	permissions := make(map[string]bool)
+	claims.Roles = make([]string, 0, len(roles))
+	for _, role := range roles {
+		claims.Roles = append(claims.Roles, role.Name)
+		for _, permission := range role.Permissions {
+			permissions[permission] = true
+		}
+	}
+	scope := make([]string, 0, len(permissions))
+	for permission := range permissions {
+		scope = append(scope, permission)
+	}
+	sort.Strings(scope)
+	claims.Scope = strings.Join(scope, " ")
+	return nil
+}
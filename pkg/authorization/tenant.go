@@ -0,0 +1,235 @@
+package authorization
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+type TenantStore interface {
+	ListTenants(ctx context.Context) ([]models.Tenant, error)
+}
+
+// Tenants keeps the tenants along with their signing keys, read from the subdirectory of the keys directory
+// named after the tenant. Tenants without one sign with the service's keys, their tokens told apart by the
+// tid claim and the issuer.
+type Tenants struct {
+	log     *logrus.Entry
+	store   TenantStore
+	keysDir string
+	mu      sync.RWMutex
+	tenants map[string]*tenant
+	hosts   map[string]string
+}
+
+type tenant struct {
+	models.Tenant
+	// keys are nil for tenants signing with the service's keys.
+	keys *Keyring
+}
+
+// LoadTenants reads the tenants from the store and their keys from keysDir, if it's not empty.
+func LoadTenants(ctx context.Context, log *logrus.Logger, store TenantStore, keysDir string) (*Tenants, error) {
+	t := Tenants{
+		log:     log.WithField("module", "tenants"),
+		store:   store,
+		keysDir: keysDir,
+	}
+	if err := t.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Reload rereads the tenants and their keys. The tenants are left as is on errors.
+func (t *Tenants) Reload(ctx context.Context) error {
+	list, err := t.store.ListTenants(ctx)
+	if err != nil {
+		return fmt.Errorf("err listing tenants: %w", err)
+	}
+	tenants := make(map[string]*tenant, len(list))
+	hosts := make(map[string]string)
+	for i := range list {
+		keys, err := t.loadKeys(list[i].ID)
+		if err != nil {
+			return fmt.Errorf("err loading keys of tenant %s: %w", list[i].ID, err)
+		}
+		tenants[list[i].ID] = &tenant{Tenant: list[i], keys: keys}
+		for _, host := range list[i].Hosts {
+			hosts[strings.ToLower(host)] = list[i].ID
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(tenants) != len(t.tenants) {
+		t.log.Infof("serving %d tenants", len(tenants))
+	}
+	t.tenants, t.hosts = tenants, hosts
+	return nil
+}
+
+// loadKeys reads the keys of the tenant, reusing its keyring if it was loaded before. It's nil if the tenant
+// has no keys of its own.
+func (t *Tenants) loadKeys(id string) (*Keyring, error) {
+	if t.keysDir == "" || id == common.DefaultTenant {
+		return nil, nil
+	}
+	dir := filepath.Join(t.keysDir, id)
+	_, err := os.Stat(dir)
+	switch {
+	case err == nil:
+	case errors.Is(err, os.ErrNotExist):
+		return nil, nil
+	default:
+		return nil, err
+	}
+	if prev, ok := t.get(id); ok && prev.keys != nil {
+		return prev.keys, prev.keys.Reload()
+	}
+	return LoadKeyring(t.log.Logger, dir)
+}
+
+// Watch reloads the tenants every interval until ctx is done, removing the keys of theirs that stopped signing
+// longer than retain ago if it's not zero, see Keyring.Watch.
+func (t *Tenants) Watch(ctx context.Context, interval, retain time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.Reload(ctx); err != nil {
+				t.log.Warnf("err reloading tenants: %v", err)
+			}
+			if retain != 0 {
+				t.prune(retain)
+			}
+		}
+	}
+}
+
+func (t *Tenants) prune(retain time.Duration) {
+	t.mu.RLock()
+	var keyrings []*Keyring
+	for _, found := range t.tenants {
+		if found.keys != nil {
+			keyrings = append(keyrings, found.keys)
+		}
+	}
+	t.mu.RUnlock()
+	for _, keys := range keyrings {
+		if err := keys.prune(retain); err != nil && !errors.Is(err, common.ErrKeysRotating) {
+			t.log.Warnf("err removing expired keys of %s: %v", keys.dir, err)
+		}
+	}
+}
+
+// Resolve finds the tenant by the host the request came to or, for hosts of no tenant, by id. Requests naming
+// no tenant are served for the default one. An id of another tenant than the host's is refused with
+// common.ErrTenantMismatch, so that the host of a tenant serves its users only.
+func (t *Tenants) Resolve(id, host string) (*models.Tenant, error) {
+	t.mu.RLock()
+	hostTenant := t.hosts[strings.ToLower(host)]
+	t.mu.RUnlock()
+	switch {
+	case hostTenant == "":
+	case id == "" || id == hostTenant:
+		id = hostTenant
+	default:
+		return nil, fmt.Errorf("%w: %s at %s", common.ErrTenantMismatch, id, host)
+	}
+	if id == "" {
+		id = common.DefaultTenant
+	}
+	found, ok := t.get(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", common.ErrTenantNotFound, id)
+	}
+	result := found.Tenant
+	return &result, nil
+}
+
+func (t *Tenants) get(id string) (*tenant, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	found, ok := t.tenants[id]
+	return found, ok
+}
+
+// WithTenants isolates the users of the tenants, their tokens are signed with the keys and the issuer
+// of the user's tenant. Without tenants everyone is of the default one.
+func (a *Authorizer) WithTenants(tenants *Tenants) *Authorizer {
+	a.tenants = tenants
+	return a
+}
+
+// ResolveTenant finds the tenant a request is served for, see Tenants.Resolve.
+func (a *Authorizer) ResolveTenant(id, host string) (*models.Tenant, error) {
+	if a.tenants != nil {
+		return a.tenants.Resolve(id, host)
+	}
+	if id != "" && id != common.DefaultTenant {
+		return nil, fmt.Errorf("%w: %s", common.ErrTenantNotFound, id)
+	}
+	return &models.Tenant{ID: common.DefaultTenant}, nil
+}
+
+// signing returns the issuer and the keys of the tenant's tokens, empty being the default tenant
+// as in tokens issued before tenants were introduced.
+func (a *Authorizer) signing(tenantID string) (string, *Keyring, error) {
+	if tenantID == "" {
+		tenantID = common.DefaultTenant
+	}
+	issuer, keys := a.config.Issuer, a.keys
+	if a.tenants == nil {
+		if tenantID != common.DefaultTenant {
+			return "", nil, fmt.Errorf("%w: %s", common.ErrTenantNotFound, tenantID)
+		}
+		return issuer, keys, nil
+	}
+	found, ok := a.tenants.get(tenantID)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s", common.ErrTenantNotFound, tenantID)
+	}
+	if found.Issuer != "" {
+		issuer = found.Issuer
+	}
+	if found.keys != nil {
+		keys = found.keys
+	}
+	return issuer, keys, nil
+}
+
+// servesTenant tells if tokens of the tenant are accepted by the request. Requests that aren't resolved
+// to any tenant, as grpc ones, accept all of them.
+func servesTenant(ctx context.Context, tenantID string) bool {
+	requested, ok := common.TenantFromContext(ctx)
+	return !ok || requested == tenantID
+}
+
+// userTenant looks up the tenant of the user.
+func (a *Authorizer) userTenant(ctx context.Context, uuid string) (string, error) {
+	user, err := a.users.GetUserByUUID(ctx, uuid)
+	if err != nil {
+		return "", fmt.Errorf("err getting user %s: %w", uuid, err)
+	}
+	return tenantOf(user), nil
+}
+
+// tenantOf returns the tenant of the user, the default one if it's empty.
+func tenantOf(user *models.User) string {
+	if user.TenantID == "" {
+		return common.DefaultTenant
+	}
+	return user.TenantID
+}
@@ -0,0 +1,365 @@
+package clients
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	secretBytes = 32
+	// touchEvery limits writes of the last used time to one per client per interval.
+	touchEvery = time.Minute
+)
+
+// AllEndpoints lets a client call the whole private api, the admin endpoints included.
+const AllEndpoints = "/private/*"
+
+type Store interface {
+	SaveClient(ctx context.Context, client *models.APIClient) error
+	GetClient(ctx context.Context, id string) (*models.APIClient, error)
+	ListClients(ctx context.Context, tenantID string) ([]models.APIClient, error)
+	UpdateClientSecret(ctx context.Context, id, secretHash string) error
+	DisableClient(ctx context.Context, id string) error
+	TouchClient(ctx context.Context, id string, at time.Time) error
+	// UseNonce fails with common.ErrNonceReused if the client used the nonce before it expired.
+	UseNonce(ctx context.Context, clientID, nonce string, expires time.Time) error
+}
+
+// Registry manages the clients of the private api. Secrets are only shown once, on creation and rotation,
+// and kept as sha256 hashes.
+type Registry struct {
+	log          *logrus.Entry
+	store        Store
+	certificates map[string][]string
+	pepper       []byte
+	mu           sync.Mutex
+	touched      map[string]time.Time
+}
+
+func NewRegistry(log *logrus.Logger, store Store) *Registry {
+	r := Registry{
+		log:     log.WithField("module", "api_clients"),
+		store:   store,
+		touched: make(map[string]time.Time),
+	}
+	return &r
+}
+
+// WithCertificates lets callers presenting a verified client certificate of one of the identities call its
+// endpoints, see ParseCertificates.
+func (r *Registry) WithCertificates(certificates map[string][]string) *Registry {
+	r.certificates = certificates
+	return r
+}
+
+// ParseCertificates reads the identities of client certificates and the endpoints they may call in the
+// identity1=endpoint1,endpoint2;identity2=endpoint3 form. An identity is either a URI SAN such as
+// spiffe://cluster.local/ns/billing/sa/api or the whole subject such as CN=billing,O=acme, common names alone
+// aren't accepted as anyone may get a certificate for a name from a shared CA.
+func ParseCertificates(spec string) (map[string][]string, error) {
+	certificates := make(map[string][]string)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// endpoints have no =, subjects do
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("err malformed client certificate %q", entry)
+		}
+		identity, endpoints := entry[:i], strings.Split(entry[i+1:], ",")
+		if !isCertificateIdentity(identity) {
+			return nil, fmt.Errorf("err client certificate %q is neither a uri nor a subject", identity)
+		}
+		for _, endpoint := range endpoints {
+			if !strings.HasPrefix(endpoint, "/") {
+				return nil, fmt.Errorf("err malformed endpoint %q of client certificate %q", endpoint, identity)
+			}
+		}
+		certificates[identity] = endpoints
+	}
+	return certificates, nil
+}
+
+// isCertificateIdentity tells a URI, which has a scheme, or a subject, starting with an attribute=value pair,
+// from a bare name.
+func isCertificateIdentity(identity string) bool {
+	if u, err := url.Parse(identity); err == nil && u.Scheme != "" {
+		return true
+	}
+	key, value, ok := strings.Cut(identity, "=")
+	return ok && key != "" && value != "" && !strings.ContainsAny(key, ", ")
+}
+
+// WithSigningPepper lets clients sign requests. The signing key of a client is the HMAC of the hash of its secret
+// under pepper, so that what the database keeps isn't enough to sign.
+func (r *Registry) WithSigningPepper(pepper []byte) *Registry {
+	r.pepper = pepper
+	return r
+}
+
+// SigningKey is the key the client with the secret signs requests with, empty if signed requests are off.
+func (r *Registry) SigningKey(secret string) string {
+	return r.signingKey(HashSecret(secret))
+}
+
+func (r *Registry) signingKey(secretHash string) string {
+	if len(r.pepper) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, r.pepper)
+	mac.Write([]byte(secretHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AuthenticateCertificate checks the certificate, already verified by TLS, against the allowed identities
+// and the path against the endpoints of the identity. URI SANs are matched first, then the whole subject.
+// Certificate clients are of the default tenant.
+func (r *Registry) AuthenticateCertificate(
+	ctx context.Context,
+	cert *x509.Certificate,
+	path string,
+) (*models.APIClient, error) {
+	if common.RequestTenant(ctx) != common.DefaultTenant {
+		return nil, common.ErrInvalidClient
+	}
+	identities := make([]string, 0, len(cert.URIs)+1)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	identities = append(identities, cert.Subject.String())
+	for _, identity := range identities {
+		endpoints, ok := r.certificates[identity]
+		if !ok || identity == "" {
+			continue
+		}
+		if !Allowed(endpoints, path) {
+			return nil, fmt.Errorf("%w: %s", common.ErrClientForbidden, path)
+		}
+		return &models.APIClient{
+			ID:               identity,
+			TenantID:         common.DefaultTenant,
+			Name:             "certificate",
+			AllowedEndpoints: endpoints,
+		}, nil
+	}
+	return nil, common.ErrInvalidClient
+}
+
+// Create registers a client of the tenant the request is served for allowed to call the endpoints, returning it
+// along with its secret.
+func (r *Registry) Create(ctx context.Context, name string, endpoints []string) (*models.APIClient, string, error) {
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	client := models.APIClient{
+		ID:               uuid.New().String(),
+		TenantID:         common.RequestTenant(ctx),
+		Name:             name,
+		SecretHash:       HashSecret(secret),
+		AllowedEndpoints: endpoints,
+		Created:          now,
+		Updated:          now,
+	}
+	if err = r.store.SaveClient(ctx, &client); err != nil {
+		return nil, "", fmt.Errorf("err saving client %s: %w", name, err)
+	}
+	return &client, secret, nil
+}
+
+// Bootstrap creates a client of the default tenant with access to everything unless it already exists,
+// giving the way to create the rest through the admin endpoints.
+func (r *Registry) Bootstrap(ctx context.Context, id, secret string) error {
+	_, err := r.store.GetClient(ctx, id)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, common.ErrClientNotFound):
+	default:
+		return fmt.Errorf("err getting client %s: %w", id, err)
+	}
+	now := time.Now().UTC()
+	client := models.APIClient{
+		ID:               id,
+		TenantID:         common.DefaultTenant,
+		Name:             "bootstrap",
+		SecretHash:       HashSecret(secret),
+		AllowedEndpoints: []string{AllEndpoints},
+		Created:          now,
+		Updated:          now,
+	}
+	if err = r.store.SaveClient(ctx, &client); err != nil {
+		return fmt.Errorf("err saving client %s: %w", id, err)
+	}
+	r.log.Infof("bootstrap client %s created", id)
+	return nil
+}
+
+// Rotate replaces the secret of the client, the old one stops working at once.
+func (r *Registry) Rotate(ctx context.Context, id string) (string, error) {
+	if err := r.ofTenant(ctx, id); err != nil {
+		return "", err
+	}
+	secret, err := NewSecret()
+	if err != nil {
+		return "", err
+	}
+	if err = r.store.UpdateClientSecret(ctx, id, HashSecret(secret)); err != nil {
+		return "", fmt.Errorf("err rotating secret of %s: %w", id, err)
+	}
+	return secret, nil
+}
+
+func (r *Registry) Disable(ctx context.Context, id string) error {
+	if err := r.ofTenant(ctx, id); err != nil {
+		return err
+	}
+	if err := r.store.DisableClient(ctx, id); err != nil {
+		return fmt.Errorf("err disabling client %s: %w", id, err)
+	}
+	return nil
+}
+
+// List returns the clients of the tenant the request is served for.
+func (r *Registry) List(ctx context.Context) ([]models.APIClient, error) {
+	return r.store.ListClients(ctx, common.RequestTenant(ctx))
+}
+
+// ofTenant checks that the client is of the tenant the request is served for, clients of others aren't found.
+func (r *Registry) ofTenant(ctx context.Context, id string) error {
+	client, err := r.store.GetClient(ctx, id)
+	if err != nil {
+		return fmt.Errorf("err getting client %s: %w", id, err)
+	}
+	if client.TenantID != common.RequestTenant(ctx) {
+		return fmt.Errorf("%w: %s", common.ErrClientNotFound, id)
+	}
+	return nil
+}
+
+// Authenticate checks the client's secret and that it may call the path.
+func (r *Registry) Authenticate(ctx context.Context, id, secret, path string) (*models.APIClient, error) {
+	return r.authenticate(ctx, id, path, func(client *models.APIClient) bool {
+		return subtle.ConstantTimeCompare([]byte(HashSecret(secret)), []byte(client.SecretHash)) == 1
+	})
+}
+
+// AuthenticateSigned checks the hex HMAC-SHA256 signature of the payload and that the client may call the path.
+// The nonce the payload is signed with is used up until it expires, not to let the request be replayed.
+func (r *Registry) AuthenticateSigned(
+	ctx context.Context,
+	id, signature, nonce string,
+	payload []byte,
+	path string,
+	expires time.Time,
+) (*models.APIClient, error) {
+	given, err := hex.DecodeString(signature)
+	if err != nil || len(r.pepper) == 0 {
+		return nil, common.ErrInvalidClient
+	}
+	client, err := r.authenticate(ctx, id, path, func(client *models.APIClient) bool {
+		mac := hmac.New(sha256.New, []byte(r.signingKey(client.SecretHash)))
+		mac.Write(payload)
+		return hmac.Equal(given, mac.Sum(nil))
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.store.UseNonce(ctx, id, nonce, expires)
+	switch {
+	case err == nil:
+		return client, nil
+	case errors.Is(err, common.ErrNonceReused):
+		return nil, fmt.Errorf("%w: nonce %s reused", common.ErrInvalidClient, nonce)
+	default:
+		return nil, fmt.Errorf("err using nonce of %s: %w", id, err)
+	}
+}
+
+func (r *Registry) authenticate(
+	ctx context.Context,
+	id, path string,
+	check func(client *models.APIClient) bool,
+) (*models.APIClient, error) {
+	client, err := r.store.GetClient(ctx, id)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrClientNotFound):
+		return nil, common.ErrInvalidClient
+	default:
+		return nil, fmt.Errorf("err getting client %s: %w", id, err)
+	}
+	if client.Disabled || client.TenantID != common.RequestTenant(ctx) || !check(client) {
+		return nil, common.ErrInvalidClient
+	}
+	if !Allowed(client.AllowedEndpoints, path) {
+		return nil, fmt.Errorf("%w: %s", common.ErrClientForbidden, path)
+	}
+	r.touch(ctx, id)
+	return client, nil
+}
+
+func (r *Registry) touch(ctx context.Context, id string) {
+	now := time.Now().UTC()
+	r.mu.Lock()
+	if now.Sub(r.touched[id]) < touchEvery {
+		r.mu.Unlock()
+		return
+	}
+	r.touched[id] = now
+	r.mu.Unlock()
+	if err := r.store.TouchClient(ctx, id, now); err != nil {
+		r.log.Warnf("err updating last use of client %s: %v", id, err)
+	}
+}
+
+// Allowed tells if the path matches any of the endpoints, either exactly or by a /* suffix.
+func Allowed(endpoints []string, path string) bool {
+	for _, endpoint := range endpoints {
+		if prefix := strings.TrimSuffix(endpoint, "/*"); prefix != endpoint {
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if path == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSecret generates a random url safe secret.
+func NewSecret() (string, error) {
+	b := make([]byte, secretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("err generating client secret: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashSecret is the form secrets are stored in.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
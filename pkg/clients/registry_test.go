@@ -0,0 +1,159 @@
+package clients
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/profilestore"
+	"github.com/sirupsen/logrus"
+)
+
+const spiffeBilling = "spiffe://cluster.local/ns/billing/sa/api"
+
+func TestParseCertificates(t *testing.T) {
+	certificates, err := ParseCertificates(spiffeBilling + "=/private/v1/token/*,/private/v1/authorize; CN=ops,O=acme=/private/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := certificates[spiffeBilling]; len(got) != 2 || got[0] != "/private/v1/token/*" ||
+		got[1] != "/private/v1/authorize" {
+		t.Fatalf("got endpoints %v of %s", got, spiffeBilling)
+	}
+	if got := certificates["CN=ops,O=acme"]; len(got) != 1 || got[0] != AllEndpoints {
+		t.Fatalf("got endpoints %v of CN=ops,O=acme", got)
+	}
+
+	for _, spec := range []string{
+		"reporting=/private/*",
+		"CN=ops,O=acme",
+		spiffeBilling,
+		spiffeBilling + "=private/*",
+		"=/private/*",
+	} {
+		if _, err = ParseCertificates(spec); err == nil {
+			t.Errorf("parsed %q", spec)
+		}
+	}
+}
+
+func TestAuthenticateCertificate(t *testing.T) {
+	certificates, err := ParseCertificates(spiffeBilling + "=/private/v1/token/*;CN=ops,O=acme=/private/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewRegistry(logrus.New(), nil).WithCertificates(certificates)
+	spiffe, err := url.Parse(spiffeBilling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		subject pkix.Name
+		uris    []*url.URL
+		path    string
+		want    string
+		err     error
+	}{
+		{"uri", pkix.Name{CommonName: "billing"}, []*url.URL{spiffe}, "/private/v1/token/1", spiffeBilling, nil},
+		{"uri other endpoint", pkix.Name{CommonName: "billing"}, []*url.URL{spiffe}, "/private/v1/keys/rotate", "",
+			common.ErrClientForbidden},
+		{"subject", pkix.Name{CommonName: "ops", Organization: []string{"acme"}}, nil, "/private/v1/keys/rotate",
+			"CN=ops,O=acme", nil},
+		{"common name only", pkix.Name{CommonName: "ops"}, nil, "/private/v1/token/1", "", common.ErrInvalidClient},
+		{"other organization", pkix.Name{CommonName: "ops", Organization: []string{"evil"}}, nil,
+			"/private/v1/token/1", "", common.ErrInvalidClient},
+		{"common name of uri", pkix.Name{CommonName: spiffeBilling}, nil, "/private/v1/token/1", "",
+			common.ErrInvalidClient},
+	}
+	_, err = registry.AuthenticateCertificate(common.WithTenant(ctx, "acme"), newCertificate(t, pkix.Name{}, []*url.URL{spiffe}),
+		"/private/v1/token/1")
+	if !errors.Is(err, common.ErrInvalidClient) {
+		t.Fatalf("got %v authenticating a certificate for another tenant, want %v", err, common.ErrInvalidClient)
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			client, err := registry.AuthenticateCertificate(ctx, newCertificate(t, test.subject, test.uris), test.path)
+			switch {
+			case test.err != nil:
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got %v, want %v", err, test.err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case client.ID != test.want:
+				t.Fatalf("got client %s, want %s", client.ID, test.want)
+			}
+		})
+	}
+}
+
+// TestTenantClients checks that the clients of a tenant are neither let in nor managed at another one.
+func TestTenantClients(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry(logrus.New(), profilestore.NewMemoryStore(ctx))
+	acme := common.WithTenant(ctx, "acme")
+	client, secret, err := registry.Create(acme, "billing", []string{AllEndpoints})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = registry.Authenticate(acme, client.ID, secret, "/private/v1/roles"); err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range []context.Context{ctx, common.WithTenant(ctx, common.DefaultTenant)} {
+		if _, err = registry.Authenticate(other, client.ID, secret, "/private/v1/roles"); !errors.Is(err, common.ErrInvalidClient) {
+			t.Fatalf("got %v authenticating at another tenant, want %v", err, common.ErrInvalidClient)
+		}
+		if _, err = registry.Rotate(other, client.ID); !errors.Is(err, common.ErrClientNotFound) {
+			t.Fatalf("got %v rotating at another tenant, want %v", err, common.ErrClientNotFound)
+		}
+		if err = registry.Disable(other, client.ID); !errors.Is(err, common.ErrClientNotFound) {
+			t.Fatalf("got %v disabling at another tenant, want %v", err, common.ErrClientNotFound)
+		}
+		if list, err := registry.List(other); err != nil || len(list) != 0 {
+			t.Fatalf("got %d clients listed at another tenant, %v", len(list), err)
+		}
+	}
+	if list, err := registry.List(acme); err != nil || len(list) != 1 {
+		t.Fatalf("got %d clients of the tenant, %v", len(list), err)
+	}
+}
+
+// newCertificate issues a self-signed client certificate, TLS has verified it by the time it's authenticated.
+func newCertificate(t *testing.T, subject pkix.Name, uris []*url.URL) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		URIs:         uris,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
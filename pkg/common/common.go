@@ -11,11 +11,35 @@ const (
 )
 
 var (
-	ErrUnauthenticated      = errors.New("err user failed to authenticate")
-	ErrInvalidSigningMethod = errors.New("err invalid signing method")
-	ErrInvalidAccessToken   = errors.New("err invalid access token")
-	ErrInvalidPhoneNumber   = errors.New("err invalid phone number")
-	ErrPhoneNotFound        = errors.New("err phone not found")
+	ErrUnauthenticated        = errors.New("err user failed to authenticate")
+	ErrInvalidSigningMethod   = errors.New("err invalid signing method")
+	ErrInvalidAccessToken     = errors.New("err invalid access token")
+	ErrInvalidPhoneNumber     = errors.New("err invalid phone number")
+	ErrPhoneNotFound          = errors.New("err phone not found")
+	ErrUserNotFound           = errors.New("err user not found")
+	ErrChallengeNotFound      = errors.New("err verification challenge not found")
+	ErrCodeExpired            = errors.New("err verification code expired")
+	ErrAttemptsExhausted      = errors.New("err verification attempts exhausted")
+	ErrInvalidCode            = errors.New("err invalid verification code")
+	ErrResendTooSoon          = errors.New("err verification code requested too soon")
+	ErrUnknownChannel         = errors.New("err unknown verification channel")
+	ErrInvalidRefreshToken    = errors.New("err invalid refresh token")
+	ErrRefreshTokenReused     = errors.New("err refresh token reused")
+	ErrTokenRevoked           = errors.New("err token revoked")
+	ErrInvalidClient          = errors.New("err invalid client credentials")
+	ErrClientNotFound         = errors.New("err client not found")
+	ErrClientForbidden        = errors.New("err client not allowed to call the endpoint")
+	ErrNonceReused            = errors.New("err request nonce reused")
+	ErrInvalidRedirectURI     = errors.New("err redirect uri not registered")
+	ErrInvalidClientMetadata  = errors.New("err invalid client metadata")
+	ErrInvalidGrant           = errors.New("err invalid authorization grant")
+	ErrInvalidScope           = errors.New("err scope not granted")
+	ErrImpersonationForbidden = errors.New("err impersonation not allowed")
+	ErrRoleNotFound           = errors.New("err role not found")
+	ErrTenantNotFound         = errors.New("err tenant not found")
+	ErrTenantMismatch         = errors.New("err tenant not served at the host")
+	ErrUserModified           = errors.New("err user modified since read")
+	ErrKeysRotating           = errors.New("err keys are being rotated by another instance")
 )
 
 type CountingReader struct {
@@ -0,0 +1,27 @@
+package common
+
+import "context"
+
+// DefaultTenant has the users from before tenants were introduced and serves requests naming no tenant.
+const DefaultTenant = "default"
+
+type tenantKey struct{}
+
+// WithTenant tells the tenant the request is served for to the layers below the http one.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant of the request, false if it wasn't resolved, as for grpc calls.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantKey{}).(string)
+	return tenantID, ok
+}
+
+// RequestTenant is the tenant the request is served for, the default one for requests that aren't resolved to any.
+func RequestTenant(ctx context.Context) string {
+	if tenantID, ok := TenantFromContext(ctx); ok {
+		return tenantID
+	}
+	return DefaultTenant
+}
@@ -5,6 +5,16 @@ import (
 	"os"
 )
 
+// Contains tells whether the list has s.
+func Contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 func RunsInContainer() bool {
 	_, err := os.Stat("/.dockerenv")
 	if err == nil {
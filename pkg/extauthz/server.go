@@ -0,0 +1,98 @@
+// Package extauthz implements the Envoy ext_authz gRPC service on top of the access tokens,
+// the same way /auth/forward does over http.
+package extauthz
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/sirupsen/logrus"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type Identifier interface {
+	Identify(ctx context.Context, accessToken string) (*models.Identity, error)
+}
+
+type Server struct {
+	log        *logrus.Entry
+	identifier Identifier
+}
+
+func New(log *logrus.Logger, identifier Identifier) *Server {
+	s := Server{
+		log:        log.WithField("module", "ext_authz"),
+		identifier: identifier,
+	}
+	return &s
+}
+
+// Register adds the service to the grpc server.
+func (s *Server) Register(server *grpc.Server) {
+	authv3.RegisterAuthorizationServer(server, s)
+}
+
+// Check lets the request through with X-User-*, X-Client-Id, X-Actor-Id, X-Roles and X-Tenant-Id headers set
+// if it bears a valid access token. Tokens of any tenant are accepted, the upstream checks X-Tenant-Id.
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	// envoy passes header names lowercased
+	authHeader := req.GetAttributes().GetRequest().GetHttp().GetHeaders()["authorization"]
+	scheme, token, ok := strings.Cut(authHeader, " ")
+	if !ok || scheme != "Bearer" || token == "" {
+		return denied(`Bearer`), nil
+	}
+	identity, err := s.identifier.Identify(ctx, token)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidAccessToken), errors.Is(err, common.ErrTokenRevoked):
+		return denied(`Bearer error="invalid_token"`), nil
+	default:
+		s.log.Warnf("err identifying token: %v", err)
+		return nil, err
+	}
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{
+				Headers: []*corev3.HeaderValueOption{
+					header("X-User-Id", identity.UUID),
+					header("X-User-Phone", identity.Phone),
+					header("X-Scopes", strings.Join(identity.Scopes, " ")),
+					header("X-Client-Id", identity.ClientID),
+					header("X-Actor-Id", identity.Actor),
+					header("X-Roles", strings.Join(identity.Roles, " ")),
+					header("X-Tenant-Id", identity.TenantID),
+				},
+			},
+		},
+	}, nil
+}
+
+func denied(challenge string) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.Unauthenticated)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: typev3.StatusCode_Unauthorized},
+				Headers: []*corev3.HeaderValueOption{header("WWW-Authenticate", challenge)},
+			},
+		},
+	}
+}
+
+// header replaces the header, so the ones sent by the client can't pass for ours.
+func header(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header: &corev3.HeaderValue{Key: key, Value: value},
+		Append: wrapperspb.Bool(false),
+	}
+}
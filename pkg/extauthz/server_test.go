@@ -0,0 +1,172 @@
+package extauthz
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/gerladeno/authorization-service/pkg/authorization"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/gerladeno/authorization-service/pkg/profilestore"
+	"github.com/gerladeno/authorization-service/pkg/revocation"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const otherTenant = "other"
+
+// newTestClient serves the authorizer over grpc in memory and returns a client of it.
+func newTestClient(t *testing.T, auth *authorization.Authorizer) authv3.AuthorizationClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	New(logrus.New(), auth).Register(server)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return authv3.NewAuthorizationClient(conn)
+}
+
+// TestCheck checks that the user is told by headers replacing the ones of the request and that tokens of every
+// tenant are let through.
+func TestCheck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := logrus.New()
+	store := profilestore.NewMemoryStore(ctx)
+	if err := store.SaveTenant(ctx, &models.Tenant{ID: otherTenant, Hosts: []string{}}); err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := authorization.LoadTenants(ctx, log, store, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocations, err := revocation.NewChecker(ctx, log, store, revocation.Config{TokenTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := authorization.Config{Issuer: "test", AccessTTL: time.Hour, RefreshTTL: time.Hour}
+	auth := authorization.New(log, nil, nil, store, store, revocations, authorization.NewKeyring(log, key), config).
+		WithTenants(tenants)
+	signIn := func(tenantID, phone string) (string, string) {
+		user := models.User{UUID: uuid.New().String(), TenantID: tenantID, Phone: phone}
+		if err := store.UpsertUser(ctx, &user); err != nil {
+			t.Fatal(err)
+		}
+		tokens, err := auth.IssueTokens(ctx, user.UUID, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.UUID, tokens.AccessToken
+	}
+	user, token := signIn(common.DefaultTenant, "+70000000001")
+	otherUser, otherToken := signIn(otherTenant, "+70000000001")
+	_, revoked := signIn(common.DefaultTenant, "+70000000002")
+	if err = auth.Logout(ctx, revoked); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, auth)
+
+	for _, test := range []struct {
+		name          string
+		authorization string
+		wantChallenge string
+		wantHeaders   map[string]string
+	}{
+		{"default tenant", "Bearer " + token, "", map[string]string{
+			"X-User-Id": user, "X-User-Phone": "+70000000001", "X-Tenant-Id": common.DefaultTenant,
+			"X-Actor-Id": "", "X-Roles": "", "X-Client-Id": "", "X-Scopes": "",
+		}},
+		{"other tenant", "Bearer " + otherToken, "", map[string]string{
+			"X-User-Id": otherUser, "X-User-Phone": "+70000000001", "X-Tenant-Id": otherTenant,
+			"X-Actor-Id": "", "X-Roles": "", "X-Client-Id": "", "X-Scopes": "",
+		}},
+		{"no token", "", "Bearer", nil},
+		{"basic", "Basic dXNlcjpwYXNz", "Bearer", nil},
+		{"revoked", "Bearer " + revoked, `Bearer error="invalid_token"`, nil},
+		{"garbage", "Bearer token", `Bearer error="invalid_token"`, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := client.Check(ctx, &authv3.CheckRequest{
+				Attributes: &authv3.AttributeContext{
+					Request: &authv3.AttributeContext_Request{
+						Http: &authv3.AttributeContext_HttpRequest{
+							Headers: map[string]string{
+								"authorization": test.authorization,
+								"x-user-id":     uuid.New().String(),
+								"x-actor-id":    uuid.New().String(),
+								"x-roles":       "admin",
+							},
+						},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantChallenge != "" {
+				checkDenied(t, resp, test.wantChallenge)
+				return
+			}
+			if code := codes.Code(resp.GetStatus().GetCode()); code != codes.OK {
+				t.Fatalf("got %s, want %s", code, codes.OK)
+			}
+			headers := resp.GetOkResponse().GetHeaders()
+			if len(headers) != len(test.wantHeaders) {
+				t.Fatalf("got %d headers, want %d", len(headers), len(test.wantHeaders))
+			}
+			for _, header := range headers {
+				key, value := header.GetHeader().GetKey(), header.GetHeader().GetValue()
+				if want, ok := test.wantHeaders[key]; !ok || value != want {
+					t.Errorf("got %s %q, want %q", key, value, want)
+				}
+				if header.GetAppend().GetValue() {
+					t.Errorf("%s appended to the one of the request", key)
+				}
+			}
+		})
+	}
+}
+
+func checkDenied(t *testing.T, resp *authv3.CheckResponse, challenge string) {
+	t.Helper()
+	if code := codes.Code(resp.GetStatus().GetCode()); code != codes.Unauthenticated {
+		t.Fatalf("got %s, want %s", code, codes.Unauthenticated)
+	}
+	denied := resp.GetDeniedResponse()
+	if status := denied.GetStatus().GetCode(); status != typev3.StatusCode_Unauthorized {
+		t.Fatalf("got status %s, want %s", status, typev3.StatusCode_Unauthorized)
+	}
+	headers := denied.GetHeaders()
+	if len(headers) != 1 || headers[0].GetHeader().GetKey() != "WWW-Authenticate" ||
+		headers[0].GetHeader().GetValue() != challenge {
+		t.Fatalf("got headers %v, want WWW-Authenticate %q", headers, challenge)
+	}
+}
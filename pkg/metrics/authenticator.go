@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Authenticator struct {
+	SuccessTotal *prometheus.CounterVec
+	FailureTotal *prometheus.CounterVec
+	CircuitOpen  *prometheus.GaugeVec
+}
+
+func NewAuthenticator() *Authenticator {
+	return &Authenticator{
+		SuccessTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "authenticator_success_total",
+			Help: "How many calls to an authentication provider succeeded",
+		}, []string{"authenticator_provider", "authenticator_operation"}),
+		FailureTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "authenticator_failure_total",
+			Help: "How many calls to an authentication provider failed on the provider side",
+		}, []string{"authenticator_provider", "authenticator_operation"}),
+		CircuitOpen: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "authenticator_circuit_open",
+			Help: "1 if the provider is skipped after consecutive failures, 0 otherwise",
+		}, []string{"authenticator_provider"}),
+	}
+}
+
+var authenticatorOnce sync.Once
+
+func (a *Authenticator) AutoRegister() *Authenticator {
+	authenticatorOnce.Do(func() {
+		a.mustRegister(prometheus.DefaultRegisterer)
+	})
+	return a
+}
+
+func (a *Authenticator) mustRegister(registerer prometheus.Registerer) {
+	registerer.MustRegister(a.SuccessTotal, a.FailureTotal, a.CircuitOpen)
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Cache struct {
+	HitsTotal   *prometheus.CounterVec
+	MissesTotal *prometheus.CounterVec
+	ErrsTotal   *prometheus.CounterVec
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		HitsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_hits_total",
+			Help: "How many lookups were answered by the cache, unknown keys remembered as such included",
+		}, []string{"cache_tier", "cache_lookup"}),
+		MissesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_misses_total",
+			Help: "How many lookups went past the cache",
+		}, []string{"cache_tier", "cache_lookup"}),
+		ErrsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_errors_total",
+			Help: "How many calls to the cache failed, the lookup falls through to the store then",
+		}, []string{"cache_tier", "cache_lookup"}),
+	}
+}
+
+var cacheOnce sync.Once
+
+func (c *Cache) AutoRegister() *Cache {
+	cacheOnce.Do(func() {
+		c.mustRegister(prometheus.DefaultRegisterer)
+	})
+	return c
+}
+
+func (c *Cache) mustRegister(registerer prometheus.Registerer) {
+	registerer.MustRegister(c.HitsTotal, c.MissesTotal, c.ErrsTotal)
+}
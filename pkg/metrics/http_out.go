@@ -14,6 +14,7 @@ import (
 )
 
 type HTTPOut struct {
+	host                string
 	DNSResolveTime      *prometheus.GaugeVec
 	ConnectTime         *prometheus.CounterVec
 	HandshakeTime       *prometheus.CounterVec
@@ -30,6 +31,7 @@ type HTTPOut struct {
 func NewHTTPOut(host string) *HTTPOut { //nolint:funlen
 	constLabels := prometheus.Labels{"host": host}
 	return &HTTPOut{
+		host: host,
 		DNSResolveTime: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name:        "http_out_dns_time",
@@ -164,12 +166,21 @@ func NewHTTPOut(host string) *HTTPOut { //nolint:funlen
 	}
 }
 
-var httpOutOnce sync.Once
+var (
+	httpOutMu     sync.Mutex
+	httpOutByHost = make(map[string]*HTTPOut)
+)
 
+// AutoRegister registers the metrics once per host, the services called each export theirs under the host label.
+// The metrics registered for the host before are returned if there are any.
 func (h *HTTPOut) AutoRegister() *HTTPOut {
-	httpOutOnce.Do(func() {
-		h.mustRegister(prometheus.DefaultRegisterer)
-	})
+	httpOutMu.Lock()
+	defer httpOutMu.Unlock()
+	if registered, ok := httpOutByHost[h.host]; ok {
+		return registered
+	}
+	h.mustRegister(prometheus.DefaultRegisterer)
+	httpOutByHost[h.host] = h
 	return h
 }
 
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// AuditEntry records an action taken by one user on the account of another.
+type AuditEntry struct {
+	ID       string
+	Action   string
+	Actor    string
+	Subject  string
+	ClientID string
+	Details  string
+	Created  time.Time
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+type Challenge struct {
+	Phone    string
+	Channel  string
+	Attempts int
+	Created  time.Time
+	Expires  time.Time
+}
+
+// SetProvider names the provider that sent the code when the channel sends through one of several, it's kept
+// in the channel as in auto:sms.
+func (c *Challenge) SetProvider(provider string) {
+	channel, _ := c.SplitChannel()
+	c.Channel = channel + ":" + provider
+}
+
+// SplitChannel returns the channel the code was sent through and the provider that sent it, empty if the channel
+// has the only one.
+func (c *Challenge) SplitChannel() (string, string) {
+	channel, provider, _ := strings.Cut(c.Channel, ":")
+	return channel, provider
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// APIClient is a service allowed to call the private api.
+type APIClient struct {
+	ID         string
+	TenantID   string
+	Name       string
+	SecretHash string
+	// AllowedEndpoints are paths the client may call, a trailing /* allows everything under the path.
+	AllowedEndpoints []string
+	Disabled         bool
+	Created          time.Time
+	Updated          time.Time
+	LastUsed         *time.Time
+}
@@ -0,0 +1,18 @@
+package models
+
+// Actor is the one acting on behalf of the token's subject, RFC 8693 4.1. Actors of tokens exchanged
+// in turn nest, the outermost being the current one.
+type Actor struct {
+	Sub string `json:"sub"`
+	Act *Actor `json:"act,omitempty"`
+}
+
+// TokenExchange asks for an access token in place of the subject token, for another audience or fewer scopes,
+// or, with RequestedSubject set, for the user support staff impersonate.
+type TokenExchange struct {
+	SubjectToken     string
+	ClientID         string
+	Audience         string
+	Scope            string
+	RequestedSubject string
+}
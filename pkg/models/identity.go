@@ -0,0 +1,14 @@
+package models
+
+// Identity is who an access token was issued to, as passed on to the services behind the forward auth.
+// UUID is empty for tokens OAuth clients got for themselves.
+type Identity struct {
+	UUID     string
+	Phone    string
+	Scopes   []string
+	ClientID string
+	// Actor is the support staff member impersonating the user, if any.
+	Actor    string
+	Roles    []string
+	TenantID string
+}
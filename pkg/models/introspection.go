@@ -0,0 +1,19 @@
+package models
+
+// Introspection is the RFC 7662 token introspection response.
+type Introspection struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       string   `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	UUID      string   `json:"uuid,omitempty"`
+	Tid       string   `json:"tid,omitempty"`
+	Act       *Actor   `json:"act,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// OAuthClient is an app signing users in through /oauth/authorize.
+// Public clients, such as mobile or single page apps, have no secret.
+type OAuthClient struct {
+	ID           string
+	TenantID     string
+	Name         string
+	SecretHash   string
+	RedirectURIs []string
+	// Scopes are the ones the client may ask for.
+	Scopes     []string
+	GrantTypes []string
+	// Audiences are the services the client may get client_credentials tokens for.
+	Audiences []string
+	Created   time.Time
+}
+
+// AuthorizationCode is a stored authorization code, only its hash is kept.
+type AuthorizationCode struct {
+	Hash     string
+	ClientID string
+	// RedirectURI is the one the authorization request gave, empty if it gave none.
+	RedirectURI   string
+	UUID          string
+	Scope         string
+	CodeChallenge string
+	Nonce         string
+	AuthTime      time.Time
+	Expires       time.Time
+}
+
+// Device code statuses.
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+)
+
+// DeviceCode is a device authorization waiting for the user, RFC 8628. Only the hash of the device code is kept,
+// the user code is looked up as typed.
+type DeviceCode struct {
+	Hash     string
+	UserCode string
+	ClientID string
+	Scope    string
+	Status   string
+	// UUID is the user who approved the device.
+	UUID string
+	// PollInterval is the minimum number of seconds between polls.
+	PollInterval int
+	LastPolled   *time.Time
+	AuthTime     *time.Time
+	Expires      time.Time
+}
+
+// DeviceAuthorization is the response of /oauth/device_authorization.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
@@ -0,0 +1,18 @@
+package models
+
+// Attributes describe the subject or the resource of a policy decision. Values are strings, numbers, booleans
+// or lists of them.
+type Attributes map[string]interface{}
+
+// PolicyRequest asks whether the subject may perform the action on the resource.
+type PolicyRequest struct {
+	Subject  Attributes `json:"subject"`
+	Action   string     `json:"action"`
+	Resource Attributes `json:"resource"`
+}
+
+// Decision is the policy engine answer, Reasons name the rules that decided it.
+type Decision struct {
+	Allow   bool     `json:"allow"`
+	Reasons []string `json:"reasons"`
+}
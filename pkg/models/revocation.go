@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// RevokedUser means every token of the user issued at or before RevokedAt is revoked.
+type RevokedUser struct {
+	UUID      string
+	RevokedAt time.Time
+}
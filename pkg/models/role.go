@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Role is a named set of permissions assigned to users of its tenant, granted to their tokens as scopes.
+type Role struct {
+	TenantID    string
+	Name        string
+	Description string
+	Permissions []string
+	Created     time.Time
+	Updated     time.Time
+}
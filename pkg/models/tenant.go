@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Tenant is an isolated user base, a brand, with its own token issuer and signing keys.
+type Tenant struct {
+	ID   string
+	Name string
+	// Hosts the tenant is served at, requests to other hosts name it in the X-Tenant-Id header.
+	Hosts []string
+	// Issuer of the tenant's tokens, the service's one if empty.
+	Issuer  string
+	Created time.Time
+	Updated time.Time
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+type Tokens struct {
+	UUID           string
+	AccessToken    string
+	AccessExpires  time.Time
+	RefreshToken   string
+	RefreshExpires time.Time
+	// ClientID and Scope are set for tokens issued to OAuth clients.
+	ClientID string
+	Scope    string
+	// IDToken is issued to OpenID Connect clients along.
+	IDToken string
+	// IssuedTokenType is set for tokens issued by token exchange.
+	IssuedTokenType string
+}
+
+// RefreshToken is a stored refresh token. Only the hash of the token itself is kept,
+// tokens issued by rotating one another share the FamilyID, as well as TenantID, ClientID and Scope.
+type RefreshToken struct {
+	Hash     string
+	FamilyID string
+	UUID     string
+	TenantID string
+	ClientID string
+	Scope    string
+	Used     bool
+	Revoked  bool
+	Created  time.Time
+	Expires  time.Time
+}
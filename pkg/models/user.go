@@ -1,10 +1,23 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
+// User is unique by phone within the tenant, the same phone makes different users of different tenants.
+// The profile fields are filled in by the user, empty until then.
 type User struct {
-	UUID    string
-	Phone   string
-	Created time.Time
-	Updated time.Time
+	UUID      string
+	TenantID  string
+	Phone     string
+	Name      string
+	Email     string
+	AvatarURL string
+	Locale    string
+	TimeZone  string
+	// Metadata is a JSON object the apps keep about the user, {} if they don't.
+	Metadata json.RawMessage
+	Created  time.Time
+	Updated  time.Time
 }
@@ -0,0 +1,194 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/clients"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+const (
+	deviceCodeTTL      = 10 * time.Minute
+	devicePollInterval = 5 // seconds
+	// deviceSlowDown is added to the interval of devices polling too often, RFC 8628 section 3.5.
+	deviceSlowDown = 5 // seconds
+	// user codes are typed on another device, so they are short and of consonants only to not spell words,
+	// RFC 8628 6.1
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// AuthorizeDevice starts the device authorization grant, RFC 8628. The device shows the user code and
+// the verification uri and polls the token endpoint with the device code until the user approves it.
+// common.ErrInvalidClient is returned if the client fails to authenticate.
+func (s *Server) AuthorizeDevice(
+	ctx context.Context,
+	clientID, clientSecret, scope string,
+) (*models.DeviceAuthorization, error) {
+	client, err := s.authenticateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !common.Contains(client.GrantTypes, GrantDeviceCode) {
+		return nil, newError("unauthorized_client", "device_code is not allowed for the client")
+	}
+	if err = checkScope(client, scope); err != nil {
+		return nil, err
+	}
+	deviceCode, err := clients.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		return nil, err
+	}
+	err = s.store.SaveDeviceCode(ctx, &models.DeviceCode{
+		Hash:         clients.HashSecret(deviceCode),
+		UserCode:     userCode,
+		ClientID:     client.ID,
+		Scope:        strings.Join(strings.Fields(scope), " "),
+		Status:       models.DeviceCodePending,
+		PollInterval: devicePollInterval,
+		Expires:      time.Now().UTC().Add(deviceCodeTTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("err saving device code: %w", err)
+	}
+	return &models.DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationURI:         s.verificationURI,
+		VerificationURIComplete: s.verificationURI + "?" + url.Values{"user_code": {formatUserCode(userCode)}}.Encode(),
+		ExpiresIn:               int(deviceCodeTTL.Seconds()),
+		Interval:                devicePollInterval,
+	}, nil
+}
+
+// DeviceRequest returns the client asking for access and the pending request by the user code as typed.
+// common.ErrInvalidGrant is returned for unknown and expired codes.
+func (s *Server) DeviceRequest(ctx context.Context, userCode string) (*models.OAuthClient, *models.DeviceCode, error) {
+	code, err := s.store.GetDeviceCode(ctx, normalizeUserCode(userCode))
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := s.getClient(ctx, code.ClientID)
+	switch {
+	case err == nil:
+		return client, code, nil
+	case errors.Is(err, common.ErrClientNotFound):
+		// codes of other tenants' clients are as unknown
+		return nil, nil, fmt.Errorf("%w: %v", common.ErrInvalidGrant, err)
+	default:
+		return nil, nil, fmt.Errorf("err getting client %s: %w", code.ClientID, err)
+	}
+}
+
+// ResolveDevice approves or denies the request for the user who has signed in.
+// common.ErrInvalidGrant is returned for unknown, expired and already resolved codes.
+func (s *Server) ResolveDevice(ctx context.Context, userCode, userUUID string, approved bool) error {
+	status := models.DeviceCodeDenied
+	if approved {
+		status = models.DeviceCodeApproved
+	}
+	return s.store.ResolveDeviceCode(ctx, normalizeUserCode(userCode), userUUID, status, time.Now().UTC())
+}
+
+// pollDevice answers the device polling for tokens, which it gets once the user approves the request.
+// Devices polling more often than the interval are told to slow down and have to wait 5 seconds longer from then on.
+func (s *Server) pollDevice(ctx context.Context, client *models.OAuthClient, req *TokenRequest) (*models.Tokens, error) {
+	if req.DeviceCode == "" {
+		return nil, newError("invalid_request", "device_code is required")
+	}
+	now := time.Now().UTC()
+	hash := clients.HashSecret(req.DeviceCode)
+	code, err := s.store.PollDeviceCode(ctx, hash, now)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidGrant):
+		return nil, newError("invalid_grant", "unknown device code")
+	default:
+		return nil, fmt.Errorf("err polling device code: %w", err)
+	}
+	if code.ClientID != client.ID {
+		return nil, newError("invalid_grant", "device code was issued to another client")
+	}
+	if now.After(code.Expires) {
+		return nil, newError("expired_token", "device code expired")
+	}
+	switch code.Status {
+	case models.DeviceCodePending:
+		if code.LastPolled != nil && now.Sub(*code.LastPolled) < time.Duration(code.PollInterval)*time.Second {
+			if err = s.store.SlowDownDeviceCode(ctx, hash, deviceSlowDown); err != nil &&
+				!errors.Is(err, common.ErrInvalidGrant) {
+				return nil, fmt.Errorf("err slowing down device: %w", err)
+			}
+			return nil, newError("slow_down", "polled too often")
+		}
+		return nil, newError("authorization_pending", "the user hasn't approved the device yet")
+	case models.DeviceCodeDenied:
+		if err = s.store.DeleteDeviceCode(ctx, hash); err != nil && !errors.Is(err, common.ErrInvalidGrant) {
+			return nil, fmt.Errorf("err deleting device code: %w", err)
+		}
+		return nil, newError("access_denied", "the user denied the device")
+	}
+	// the code is deleted first so that concurrent polls can't get tokens twice
+	err = s.store.DeleteDeviceCode(ctx, hash)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidGrant):
+		return nil, newError("invalid_grant", "device code was used")
+	default:
+		return nil, fmt.Errorf("err deleting device code: %w", err)
+	}
+	tokens, err := s.issuer.IssueTokens(ctx, code.UUID, client.ID, code.Scope)
+	if err != nil {
+		return nil, err
+	}
+	if common.Contains(strings.Fields(code.Scope), models.ScopeOpenID) {
+		tokens.IDToken, err = s.issuer.IDToken(ctx, code.UUID, client.ID, code.Scope, "", *code.AuthTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tokens, nil
+}
+
+func newUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("err generating user code: %w", err)
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// formatUserCode makes the code easier to read as XXXX-XXXX.
+func formatUserCode(code string) string {
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// normalizeUserCode undoes formatting and whatever the user typed along: dashes, spaces, lower case.
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		if r < 'A' || r > 'Z' {
+			return -1
+		}
+		return r
+	}, code)
+}
@@ -0,0 +1,99 @@
+package oauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/clients"
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+// TestPollDevice checks the answers to a device polling while the user approves or denies it.
+func TestPollDevice(t *testing.T) {
+	ctx := context.Background()
+	s, store, clientID := newTestServer(t, []string{GrantDeviceCode})
+	expired := models.DeviceCode{
+		Hash:         clients.HashSecret("expired"),
+		UserCode:     "BCDFGHJK",
+		ClientID:     clientID,
+		Status:       models.DeviceCodePending,
+		PollInterval: devicePollInterval,
+		Expires:      time.Now().UTC().Add(-time.Second),
+	}
+	if err := store.SaveDeviceCode(ctx, &expired); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		// resolve approves or denies the device before it polls, polls is the answers to the polls in a row
+		resolve func(auth *models.DeviceAuthorization) error
+		polls   []string
+	}{
+		{"pending", nil, []string{"authorization_pending", "slow_down", "slow_down"}},
+		{"approved", func(auth *models.DeviceAuthorization) error {
+			return s.ResolveDevice(ctx, auth.UserCode, "user", true)
+		}, []string{"", "invalid_grant"}},
+		{"approved by a code typed in lower case", func(auth *models.DeviceAuthorization) error {
+			return s.ResolveDevice(ctx, " "+strings.ToLower(auth.UserCode), "user", true)
+		}, []string{""}},
+		{"denied", func(auth *models.DeviceAuthorization) error {
+			return s.ResolveDevice(ctx, auth.UserCode, "user", false)
+		}, []string{"access_denied", "invalid_grant"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			auth, err := s.AuthorizeDevice(ctx, clientID, "", "orders:read")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.resolve != nil {
+				if err = test.resolve(auth); err != nil {
+					t.Fatal(err)
+				}
+			}
+			req := TokenRequest{GrantType: GrantDeviceCode, ClientID: clientID, DeviceCode: auth.DeviceCode}
+			for i, want := range test.polls {
+				tokens, err := s.Token(ctx, &req)
+				if got := oauthErrorCode(err); got != want || (got == "" && err != nil) {
+					t.Fatalf("got %v at poll %d, want %q", err, i+1, want)
+				}
+				if err == nil && (tokens.UUID != "user" || tokens.Scope != "orders:read") {
+					t.Fatalf("got tokens of %s for %q", tokens.UUID, tokens.Scope)
+				}
+			}
+		})
+	}
+
+	req := TokenRequest{GrantType: GrantDeviceCode, ClientID: clientID, DeviceCode: "expired"}
+	if _, err := s.Token(ctx, &req); oauthErrorCode(err) != "expired_token" {
+		t.Fatalf("got %v polling with an expired code, want expired_token", err)
+	}
+	if err := s.ResolveDevice(ctx, expired.UserCode, "user", true); err == nil {
+		t.Fatal("approved an expired code")
+	}
+}
+
+// TestSlowDown checks that the interval of a device polling too often grows.
+func TestSlowDown(t *testing.T) {
+	ctx := context.Background()
+	s, store, clientID := newTestServer(t, []string{GrantDeviceCode})
+	auth, err := s.AuthorizeDevice(ctx, clientID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := TokenRequest{GrantType: GrantDeviceCode, ClientID: clientID, DeviceCode: auth.DeviceCode}
+	for i := 0; i < 3; i++ {
+		if _, err = s.Token(ctx, &req); err == nil {
+			t.Fatal("got tokens of a pending device")
+		}
+	}
+	code, err := store.PollDeviceCode(ctx, clients.HashSecret(auth.DeviceCode), time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := devicePollInterval + 2*deviceSlowDown; code.PollInterval != want {
+		t.Fatalf("got interval %d after polling too often twice, want %d", code.PollInterval, want)
+	}
+}
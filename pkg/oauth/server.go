@@ -0,0 +1,495 @@
+// Package oauth implements the OAuth 2.0 authorization code grant with PKCE and the device authorization grant
+// on top of the phone sign in.
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/clients"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ResponseTypeCode        = "code"
+	GrantAuthorizationCode  = "authorization_code"
+	GrantRefreshToken       = "refresh_token"
+	GrantClientCredentials  = "client_credentials"
+	GrantDeviceCode         = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTokenExchange      = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken    = "urn:ietf:params:oauth:token-type:access_token"
+	CodeChallengeMethodS256 = "S256"
+
+	codeTTL = time.Minute
+	// verifiers and thus S256 challenges are 43 to 128 characters, RFC 7636 4.1
+	minVerifierLength = 43
+	maxVerifierLength = 128
+)
+
+// Error is an RFC 6749 error, reported to the client as is.
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("err oauth %s: %s", e.Code, e.Description)
+}
+
+func newError(code, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+type Store interface {
+	SaveOAuthClient(ctx context.Context, client *models.OAuthClient) error
+	GetOAuthClient(ctx context.Context, id string) (*models.OAuthClient, error)
+	ListOAuthClients(ctx context.Context, tenantID string) ([]models.OAuthClient, error)
+	SaveAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error
+	// TakeAuthorizationCode returns the code deleting it, common.ErrInvalidGrant if there is none.
+	TakeAuthorizationCode(ctx context.Context, hash string) (*models.AuthorizationCode, error)
+	SaveDeviceCode(ctx context.Context, code *models.DeviceCode) error
+	// GetDeviceCode returns the pending code by the user code, common.ErrInvalidGrant if there is none or it expired.
+	GetDeviceCode(ctx context.Context, userCode string) (*models.DeviceCode, error)
+	ResolveDeviceCode(ctx context.Context, userCode, uuid, status string, at time.Time) error
+	// PollDeviceCode records the poll returning the code as it was before, common.ErrInvalidGrant if there is none.
+	PollDeviceCode(ctx context.Context, hash string, at time.Time) (*models.DeviceCode, error)
+	// SlowDownDeviceCode adds seconds to the poll interval of the code, common.ErrInvalidGrant if there is none.
+	SlowDownDeviceCode(ctx context.Context, hash string, seconds int) error
+	DeleteDeviceCode(ctx context.Context, hash string) error
+}
+
+type TokenIssuer interface {
+	IssueTokens(ctx context.Context, userUUID, clientID, scope string) (*models.Tokens, error)
+	IDToken(ctx context.Context, userUUID, clientID, scope, nonce string, authTime time.Time) (string, error)
+	ClientToken(ctx context.Context, clientID, audience, scope string) (*models.Tokens, error)
+	ExchangeToken(ctx context.Context, req *models.TokenExchange) (*models.Tokens, error)
+	Refresh(ctx context.Context, refreshToken, clientID string) (*models.Tokens, error)
+}
+
+type Server struct {
+	log    *logrus.Entry
+	store  Store
+	issuer TokenIssuer
+	// verificationURI is the page users approve devices at.
+	verificationURI string
+}
+
+func New(log *logrus.Logger, store Store, issuer TokenIssuer) *Server {
+	s := Server{
+		log:    log.WithField("module", "oauth"),
+		store:  store,
+		issuer: issuer,
+	}
+	return &s
+}
+
+// WithVerificationURI sets the absolute url of the page devices send users to.
+func (s *Server) WithVerificationURI(uri string) *Server {
+	s.verificationURI = uri
+	return s
+}
+
+// AuthorizationRequest holds the parameters of /oauth/authorize.
+type AuthorizationRequest struct {
+	ResponseType string
+	ClientID     string
+	// RedirectURI is as given, it may be left out by clients with the only one.
+	RedirectURI string
+	// RedirectTo is where the user is sent back to, filled in by Authorize.
+	RedirectTo          string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Nonce is passed on to the id token as is.
+	Nonce string
+}
+
+// Authorize validates the request, filling in where to redirect the user back to. Errors other than *Error mean
+// the redirect uri can't be trusted and the user should be shown the error instead.
+func (s *Server) Authorize(ctx context.Context, req *AuthorizationRequest) (*models.OAuthClient, error) {
+	client, err := s.getClient(ctx, req.ClientID)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrClientNotFound):
+		return nil, fmt.Errorf("%w: %s", common.ErrInvalidClient, req.ClientID)
+	default:
+		return nil, fmt.Errorf("err getting client %s: %w", req.ClientID, err)
+	}
+	switch {
+	case req.RedirectURI == "" && len(client.RedirectURIs) == 1:
+		req.RedirectTo = client.RedirectURIs[0]
+	case common.Contains(client.RedirectURIs, req.RedirectURI):
+		req.RedirectTo = req.RedirectURI
+	default:
+		return nil, fmt.Errorf("%w: %s", common.ErrInvalidRedirectURI, req.RedirectURI)
+	}
+	if req.ResponseType != ResponseTypeCode {
+		return client, newError("unsupported_response_type", "only code is supported")
+	}
+	if !common.Contains(client.GrantTypes, GrantAuthorizationCode) {
+		return client, newError("unauthorized_client", "authorization_code is not allowed for the client")
+	}
+	if req.CodeChallengeMethod != CodeChallengeMethodS256 || !validVerifier(req.CodeChallenge) {
+		return client, newError("invalid_request", "S256 code_challenge is required")
+	}
+	if err = checkScope(client, req.Scope); err != nil {
+		return client, err
+	}
+	return client, nil
+}
+
+func checkScope(client *models.OAuthClient, scope string) error {
+	for _, s := range strings.Fields(scope) {
+		if !common.Contains(client.Scopes, s) {
+			return newError("invalid_scope", "scope "+s+" is not allowed")
+		}
+	}
+	return nil
+}
+
+// IssueCode creates an authorization code for the user who has signed in and approved the request,
+// which must have passed Authorize.
+func (s *Server) IssueCode(ctx context.Context, req *AuthorizationRequest, userUUID string) (string, error) {
+	code, err := clients.NewSecret()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	err = s.store.SaveAuthorizationCode(ctx, &models.AuthorizationCode{
+		Hash:          clients.HashSecret(code),
+		ClientID:      req.ClientID,
+		RedirectURI:   req.RedirectURI,
+		UUID:          userUUID,
+		Scope:         strings.Join(strings.Fields(req.Scope), " "),
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      now,
+		Expires:       now.Add(codeTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("err saving authorization code: %w", err)
+	}
+	return code, nil
+}
+
+// TokenRequest holds the parameters of /oauth/token.
+type TokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string
+	Audience     string
+	DeviceCode   string
+	// token exchange parameters, RFC 8693 2.1, RequestedSubject is the user to impersonate
+	SubjectToken       string
+	SubjectTokenType   string
+	RequestedTokenType string
+	RequestedSubject   string
+}
+
+// Token exchanges an authorization code or a refresh token for tokens, or issues the client its own.
+// common.ErrInvalidClient is returned if the client fails to authenticate.
+func (s *Server) Token(ctx context.Context, req *TokenRequest) (*models.Tokens, error) {
+	client, err := s.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	var grant func(ctx context.Context, client *models.OAuthClient, req *TokenRequest) (*models.Tokens, error)
+	switch req.GrantType {
+	case GrantAuthorizationCode:
+		grant = s.exchangeCode
+	case GrantRefreshToken:
+		grant = s.refresh
+	case GrantClientCredentials:
+		grant = s.clientCredentials
+	case GrantDeviceCode:
+		grant = s.pollDevice
+	case GrantTokenExchange:
+		grant = s.exchangeToken
+	default:
+		return nil, newError("unsupported_grant_type", req.GrantType+" is not supported")
+	}
+	if !common.Contains(client.GrantTypes, req.GrantType) {
+		return nil, newError("unauthorized_client", req.GrantType+" is not allowed for the client")
+	}
+	return grant(ctx, client, req)
+}
+
+func (s *Server) exchangeCode(ctx context.Context, client *models.OAuthClient, req *TokenRequest) (*models.Tokens, error) {
+	if req.Code == "" || !validVerifier(req.CodeVerifier) {
+		return nil, newError("invalid_request", "code and code_verifier are required")
+	}
+	code, err := s.store.TakeAuthorizationCode(ctx, clients.HashSecret(req.Code))
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidGrant):
+		return nil, newError("invalid_grant", "unknown or used code")
+	default:
+		return nil, fmt.Errorf("err getting authorization code: %w", err)
+	}
+	switch {
+	case time.Now().UTC().After(code.Expires):
+		return nil, newError("invalid_grant", "code expired")
+	case code.ClientID != client.ID:
+		return nil, newError("invalid_grant", "code was issued to another client")
+	// the redirect uri has to be the same only if the authorization request gave one, RFC 6749 4.1.3
+	case code.RedirectURI != "" && code.RedirectURI != req.RedirectURI:
+		return nil, newError("invalid_grant", "redirect_uri doesn't match")
+	case !verifyChallenge(code.CodeChallenge, req.CodeVerifier):
+		return nil, newError("invalid_grant", "code_verifier doesn't match")
+	}
+	tokens, err := s.issuer.IssueTokens(ctx, code.UUID, client.ID, code.Scope)
+	if err != nil {
+		return nil, err
+	}
+	if common.Contains(strings.Fields(code.Scope), models.ScopeOpenID) {
+		tokens.IDToken, err = s.issuer.IDToken(ctx, code.UUID, client.ID, code.Scope, code.Nonce, code.AuthTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tokens, nil
+}
+
+func (s *Server) refresh(ctx context.Context, client *models.OAuthClient, req *TokenRequest) (*models.Tokens, error) {
+	if req.RefreshToken == "" {
+		return nil, newError("invalid_request", "refresh_token is required")
+	}
+	tokens, err := s.issuer.Refresh(ctx, req.RefreshToken, client.ID)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidRefreshToken), errors.Is(err, common.ErrRefreshTokenReused):
+		return nil, newError("invalid_grant", "invalid refresh token")
+	default:
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// clientCredentials issues a token of the client itself for one of its audiences, the only one if not asked for.
+// All the client's scopes are granted unless asked for fewer.
+func (s *Server) clientCredentials(
+	ctx context.Context,
+	client *models.OAuthClient,
+	req *TokenRequest,
+) (*models.Tokens, error) {
+	if client.SecretHash == "" {
+		return nil, newError("unauthorized_client", "public clients can't use client_credentials")
+	}
+	audience, err := pickAudience(client, req.Audience)
+	if err != nil {
+		return nil, err
+	}
+	scope := strings.Join(client.Scopes, " ")
+	if req.Scope != "" {
+		if err := checkScope(client, req.Scope); err != nil {
+			return nil, err
+		}
+		scope = strings.Join(strings.Fields(req.Scope), " ")
+	}
+	return s.issuer.ClientToken(ctx, client.ID, audience, scope)
+}
+
+// exchangeToken swaps a user's access token for one for the audience, narrowed to the scope, or for a token
+// of the requested subject if the user may impersonate.
+func (s *Server) exchangeToken(ctx context.Context, client *models.OAuthClient, req *TokenRequest) (*models.Tokens, error) {
+	if client.SecretHash == "" {
+		return nil, newError("unauthorized_client", "public clients can't exchange tokens")
+	}
+	if req.SubjectToken == "" || req.SubjectTokenType != TokenTypeAccessToken {
+		return nil, newError("invalid_request", "subject_token of the access_token type is required")
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != TokenTypeAccessToken {
+		return nil, newError("invalid_request", "only access tokens are issued")
+	}
+	audience, err := pickAudience(client, req.Audience)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkScope(client, req.Scope); err != nil {
+		return nil, err
+	}
+	tokens, err := s.issuer.ExchangeToken(ctx, &models.TokenExchange{
+		SubjectToken:     req.SubjectToken,
+		ClientID:         client.ID,
+		Audience:         audience,
+		Scope:            req.Scope,
+		RequestedSubject: req.RequestedSubject,
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidAccessToken), errors.Is(err, common.ErrTokenRevoked):
+		return nil, newError("invalid_grant", "invalid subject_token")
+	case errors.Is(err, common.ErrInvalidScope):
+		return nil, newError("invalid_scope", "scope exceeds the one of subject_token")
+	case errors.Is(err, common.ErrImpersonationForbidden):
+		s.log.Warnf("client %s: %v", client.ID, err)
+		return nil, newError("access_denied", "impersonation is not allowed")
+	case errors.Is(err, common.ErrUserNotFound):
+		return nil, newError("invalid_request", "unknown requested_subject")
+	default:
+		return nil, err
+	}
+	tokens.IssuedTokenType = TokenTypeAccessToken
+	return tokens, nil
+}
+
+// pickAudience checks the audience is one of the client's, picking the only one if none is asked for.
+func pickAudience(client *models.OAuthClient, audience string) (string, error) {
+	switch {
+	case audience == "" && len(client.Audiences) == 1:
+		return client.Audiences[0], nil
+	case audience == "" && len(client.Audiences) == 0:
+		return "", nil
+	case !common.Contains(client.Audiences, audience):
+		return "", newError("invalid_target", "audience "+audience+" is not allowed")
+	}
+	return audience, nil
+}
+
+// authenticateClient checks the secret of confidential clients, public ones only have to exist.
+func (s *Server) authenticateClient(ctx context.Context, id, secret string) (*models.OAuthClient, error) {
+	client, err := s.getClient(ctx, id)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrClientNotFound):
+		return nil, common.ErrInvalidClient
+	default:
+		return nil, fmt.Errorf("err getting client %s: %w", id, err)
+	}
+	if client.SecretHash == "" {
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(clients.HashSecret(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, common.ErrInvalidClient
+	}
+	return client, nil
+}
+
+// AuthenticateClient checks the secret of a confidential client, as the callers of the introspection endpoint.
+// Public clients have no secret to check and are refused.
+func (s *Server) AuthenticateClient(ctx context.Context, id, secret string) error {
+	client, err := s.authenticateClient(ctx, id, secret)
+	if err != nil {
+		return err
+	}
+	if client.SecretHash == "" {
+		return fmt.Errorf("%w: %s is public", common.ErrInvalidClient, id)
+	}
+	return nil
+}
+
+// getClient returns the client if it's of the tenant the request is served for, clients of others aren't found
+// not to let them get tokens of the tenant.
+func (s *Server) getClient(ctx context.Context, id string) (*models.OAuthClient, error) {
+	client, err := s.store.GetOAuthClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if client.TenantID != common.RequestTenant(ctx) {
+		return nil, fmt.Errorf("%w: %s is of another tenant", common.ErrClientNotFound, id)
+	}
+	return client, nil
+}
+
+// CreateClient registers the OAuth client of the tenant the request is served for filling in its id,
+// confidential ones get a secret returned.
+// Clients are allowed the authorization_code and refresh_token grants unless told otherwise.
+func (s *Server) CreateClient(ctx context.Context, client *models.OAuthClient, confidential bool) (string, error) {
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken}
+	}
+	for _, grant := range client.GrantTypes {
+		switch grant {
+		case GrantAuthorizationCode:
+			if len(client.RedirectURIs) == 0 {
+				return "", fmt.Errorf("%w: a redirect uri is required", common.ErrInvalidClientMetadata)
+			}
+		case GrantRefreshToken, GrantDeviceCode:
+		case GrantClientCredentials, GrantTokenExchange:
+			if !confidential {
+				return "", fmt.Errorf("%w: %s needs a confidential client", common.ErrInvalidClientMetadata, grant)
+			}
+		default:
+			return "", fmt.Errorf("%w: unknown grant type %s", common.ErrInvalidClientMetadata, grant)
+		}
+	}
+	for _, uri := range client.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return "", err
+		}
+	}
+	client.ID = uuid.New().String()
+	client.TenantID = common.RequestTenant(ctx)
+	client.Created = time.Now().UTC()
+	var secret string
+	if confidential {
+		var err error
+		if secret, err = clients.NewSecret(); err != nil {
+			return "", err
+		}
+		client.SecretHash = clients.HashSecret(secret)
+	}
+	if err := s.store.SaveOAuthClient(ctx, client); err != nil {
+		return "", fmt.Errorf("err saving client %s: %w", client.Name, err)
+	}
+	return secret, nil
+}
+
+// ListClients returns the clients of the tenant the request is served for.
+func (s *Server) ListClients(ctx context.Context) ([]models.OAuthClient, error) {
+	return s.store.ListOAuthClients(ctx, common.RequestTenant(ctx))
+}
+
+// validateRedirectURI allows https, http to the loopback for native apps and private-use schemes, RFC 8252.
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return fmt.Errorf("%w: %s", common.ErrInvalidRedirectURI, uri)
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if host := u.Hostname(); host != "localhost" && host != "127.0.0.1" && host != "::1" {
+			return fmt.Errorf("%w: http is only allowed for loopback: %s", common.ErrInvalidRedirectURI, uri)
+		}
+	case "javascript", "data", "file", "vbscript":
+		return fmt.Errorf("%w: %s", common.ErrInvalidRedirectURI, uri)
+	}
+	return nil
+}
+
+func validVerifier(verifier string) bool {
+	if len(verifier) < minVerifierLength || len(verifier) > maxVerifierLength {
+		return false
+	}
+	for _, c := range verifier {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.ContainsRune("-._~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func verifyChallenge(challenge, verifier string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
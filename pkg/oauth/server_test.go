@@ -0,0 +1,239 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/gerladeno/authorization-service/pkg/profilestore"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testRedirectURI = "https://app.example.com/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// stubIssuer issues tokens naming the user, the client and the scope they were issued for.
+type stubIssuer struct{}
+
+func (stubIssuer) IssueTokens(_ context.Context, userUUID, clientID, scope string) (*models.Tokens, error) {
+	return &models.Tokens{UUID: userUUID, AccessToken: "access", RefreshToken: "refresh", ClientID: clientID, Scope: scope}, nil
+}
+
+func (stubIssuer) IDToken(context.Context, string, string, string, string, time.Time) (string, error) {
+	return "id", nil
+}
+
+func (stubIssuer) ClientToken(_ context.Context, clientID, _, scope string) (*models.Tokens, error) {
+	return &models.Tokens{AccessToken: "access", ClientID: clientID, Scope: scope}, nil
+}
+
+func (stubIssuer) ExchangeToken(_ context.Context, req *models.TokenExchange) (*models.Tokens, error) {
+	return &models.Tokens{AccessToken: "access", ClientID: req.ClientID, Scope: req.Scope}, nil
+}
+
+func (stubIssuer) Refresh(_ context.Context, _, clientID string) (*models.Tokens, error) {
+	return &models.Tokens{AccessToken: "access", RefreshToken: "refresh", ClientID: clientID}, nil
+}
+
+// newTestServer returns a Server keeping everything in memory along with a public client of the default tenant
+// registered with the redirect uris.
+func newTestServer(t *testing.T, grants []string, redirectURIs ...string) (*Server, *profilestore.MemoryStore, string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	store := profilestore.NewMemoryStore(ctx)
+	s := New(logrus.New(), store, stubIssuer{}).WithVerificationURI("https://auth.example.com/device")
+	client := models.OAuthClient{
+		Name:         "app",
+		RedirectURIs: redirectURIs,
+		Scopes:       []string{models.ScopeOpenID, "orders:read"},
+		GrantTypes:   grants,
+	}
+	if _, err := s.CreateClient(ctx, &client, false); err != nil {
+		t.Fatal(err)
+	}
+	return s, store, client.ID
+}
+
+func challengeOf(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// oauthErrorCode returns the code of the *Error, empty if err is another one.
+func oauthErrorCode(err error) string {
+	var oauthErr *Error
+	if errors.As(err, &oauthErr) {
+		return oauthErr.Code
+	}
+	return ""
+}
+
+func TestAuthorizeRedirectURI(t *testing.T) {
+	ctx := context.Background()
+	single, _, singleID := newTestServer(t, nil, testRedirectURI)
+	several, _, severalID := newTestServer(t, nil, testRedirectURI, "myapp:/callback")
+
+	for _, test := range []struct {
+		name        string
+		server      *Server
+		clientID    string
+		ctx         context.Context
+		redirectURI string
+		wantTo      string
+		wantErr     error
+	}{
+		{"registered", several, severalID, ctx, "myapp:/callback", "myapp:/callback", nil},
+		{"the only one left out", single, singleID, ctx, "", testRedirectURI, nil},
+		{"one of several left out", several, severalID, ctx, "", "", common.ErrInvalidRedirectURI},
+		{"unregistered", single, singleID, ctx, "https://evil.example.com/callback", "", common.ErrInvalidRedirectURI},
+		{"registered prefix", single, singleID, ctx, testRedirectURI + "/more", "", common.ErrInvalidRedirectURI},
+		{"unknown client", single, "unknown", ctx, testRedirectURI, "", common.ErrInvalidClient},
+		{"client of another tenant", single, singleID, common.WithTenant(ctx, "other"), testRedirectURI, "",
+			common.ErrInvalidClient},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := AuthorizationRequest{
+				ResponseType:        ResponseTypeCode,
+				ClientID:            test.clientID,
+				RedirectURI:         test.redirectURI,
+				CodeChallenge:       challengeOf(testVerifier),
+				CodeChallengeMethod: CodeChallengeMethodS256,
+			}
+			_, err := test.server.Authorize(test.ctx, &req)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got %v, want %v", err, test.wantErr)
+			}
+			if req.RedirectTo != test.wantTo {
+				t.Fatalf("redirecting to %q, want %q", req.RedirectTo, test.wantTo)
+			}
+		})
+	}
+}
+
+func TestAuthorizePKCE(t *testing.T) {
+	s, _, clientID := newTestServer(t, nil, testRedirectURI)
+	for _, test := range []struct {
+		name      string
+		challenge string
+		method    string
+		wantCode  string
+	}{
+		{"S256", challengeOf(testVerifier), CodeChallengeMethodS256, ""},
+		{"no challenge", "", "", "invalid_request"},
+		{"plain", testVerifier, "plain", "invalid_request"},
+		{"short challenge", "abc", CodeChallengeMethodS256, "invalid_request"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := s.Authorize(context.Background(), &AuthorizationRequest{
+				ResponseType:        ResponseTypeCode,
+				ClientID:            clientID,
+				CodeChallenge:       test.challenge,
+				CodeChallengeMethod: test.method,
+			})
+			if code := oauthErrorCode(err); code != test.wantCode || (code == "" && err != nil) {
+				t.Fatalf("got %v, want %q", err, test.wantCode)
+			}
+		})
+	}
+}
+
+// TestExchangeCode checks that codes are given for tokens once, to the client they were issued to presenting
+// the verifier of the challenge, and the redirect uri if the authorization request had one.
+func TestExchangeCode(t *testing.T) {
+	ctx := context.Background()
+	s, _, clientID := newTestServer(t, nil, testRedirectURI)
+	other := models.OAuthClient{Name: "other", RedirectURIs: []string{testRedirectURI}}
+	if _, err := s.CreateClient(ctx, &other, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		// authorized is the redirect uri of the authorization request, redirect the one of the token request
+		authorized string
+		redirect   string
+		clientID   string
+		verifier   string
+		wantCode   string
+	}{
+		{"redirect uri given", testRedirectURI, testRedirectURI, clientID, testVerifier, ""},
+		{"redirect uri left out", "", "", clientID, testVerifier, ""},
+		{"redirect uri given only to the token endpoint", "", testRedirectURI, clientID, testVerifier, ""},
+		{"redirect uri left out at the token endpoint", testRedirectURI, "", clientID, testVerifier, "invalid_grant"},
+		{"another redirect uri", testRedirectURI, "https://evil.example.com/callback", clientID, testVerifier,
+			"invalid_grant"},
+		{"wrong verifier", testRedirectURI, testRedirectURI, clientID, strings.Repeat("a", minVerifierLength),
+			"invalid_grant"},
+		{"no verifier", testRedirectURI, testRedirectURI, clientID, "", "invalid_request"},
+		{"another client", testRedirectURI, testRedirectURI, other.ID, testVerifier, "invalid_grant"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := AuthorizationRequest{
+				ResponseType:        ResponseTypeCode,
+				ClientID:            clientID,
+				RedirectURI:         test.authorized,
+				Scope:               "orders:read",
+				CodeChallenge:       challengeOf(testVerifier),
+				CodeChallengeMethod: CodeChallengeMethodS256,
+			}
+			if _, err := s.Authorize(ctx, &req); err != nil {
+				t.Fatal(err)
+			}
+			code, err := s.IssueCode(ctx, &req, "user")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokenReq := TokenRequest{
+				GrantType:    GrantAuthorizationCode,
+				ClientID:     test.clientID,
+				Code:         code,
+				RedirectURI:  test.redirect,
+				CodeVerifier: test.verifier,
+			}
+			tokens, err := s.Token(ctx, &tokenReq)
+			if got := oauthErrorCode(err); got != test.wantCode || (got == "" && err != nil) {
+				t.Fatalf("got %v, want %q", err, test.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if tokens.UUID != "user" || tokens.ClientID != clientID || tokens.Scope != "orders:read" {
+				t.Fatalf("got tokens of %s to %s for %q", tokens.UUID, tokens.ClientID, tokens.Scope)
+			}
+			if _, err = s.Token(ctx, &tokenReq); oauthErrorCode(err) != "invalid_grant" {
+				t.Fatalf("got %v redeeming the code twice, want invalid_grant", err)
+			}
+		})
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	for _, test := range []struct {
+		uri   string
+		valid bool
+	}{
+		{testRedirectURI, true},
+		{"http://localhost:8080/callback", true},
+		{"http://127.0.0.1/callback", true},
+		{"http://[::1]/callback", true},
+		{"myapp:/callback", true},
+		{"http://app.example.com/callback", false},
+		{"https://app.example.com/callback#fragment", false},
+		{"/callback", false},
+		{"javascript:alert(1)", false},
+		{"data:text/html,hi", false},
+	} {
+		if err := validateRedirectURI(test.uri); (err == nil) != test.valid {
+			t.Errorf("got %v validating %s, want valid %t", err, test.uri, test.valid)
+		}
+	}
+}
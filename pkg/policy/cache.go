@@ -0,0 +1,69 @@
+package policy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+const cacheMaxEntries = 10000
+
+type cache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	decision models.Decision
+	expires  time.Time
+}
+
+func newCache(ttl time.Duration) *cache {
+	return &cache{ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+func (c *cache) get(key string) (models.Decision, bool) {
+	if c.ttl == 0 {
+		return models.Decision{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return models.Decision{}, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return models.Decision{}, false
+	}
+	return entry.decision, true
+}
+
+// put purges the expired entries once the cache is full and starts over if none of them are.
+func (c *cache) put(key string, decision models.Decision) {
+	if c.ttl == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= cacheMaxEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= cacheMaxEntries {
+			c.entries = make(map[string]cacheEntry)
+		}
+	}
+	c.entries[key] = cacheEntry{decision: decision, expires: now.Add(c.ttl)}
+}
+
+func (c *cache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+}
@@ -0,0 +1,135 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+const policyFileExt = ".json"
+
+// Engine decides requests by the rules of the policy files. A matching deny rule wins over the allow ones,
+// nothing is allowed unless a rule says so.
+type Engine struct {
+	log   *logrus.Entry
+	dir   string
+	mu    sync.RWMutex
+	rules []Rule
+	cache *cache
+}
+
+type policyFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Load reads the rules from the policy files in dir. Decisions are cached for cacheTTL, if it's not zero.
+func Load(log *logrus.Logger, dir string, cacheTTL time.Duration) (*Engine, error) {
+	e := Engine{
+		log:   log.WithField("module", "policy"),
+		dir:   dir,
+		cache: newCache(cacheTTL),
+	}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Reload rereads the directory and drops the cached decisions. The rules are left as is on errors.
+func (e *Engine) Reload() error {
+	if e.dir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(e.dir, "*"+policyFileExt))
+	if err != nil {
+		return fmt.Errorf("err listing policies: %w", err)
+	}
+	sort.Strings(paths)
+	var rules []Rule
+	names := make(map[string]string)
+	for _, path := range paths {
+		fileRules, err := readPolicyFile(path)
+		if err != nil {
+			return fmt.Errorf("err reading policy %s: %w", path, err)
+		}
+		for i := range fileRules {
+			if other, ok := names[fileRules[i].Name]; ok {
+				return fmt.Errorf("err rule %s in %s is already defined in %s", fileRules[i].Name, path, other)
+			}
+			names[fileRules[i].Name] = path
+		}
+		rules = append(rules, fileRules...)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = rules
+	e.cache.reset()
+	e.log.Infof("loaded %d rules from %d policies", len(rules), len(paths))
+	return nil
+}
+
+func readPolicyFile(path string) ([]Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file policyFile
+	if err = json.Unmarshal(b, &file); err != nil {
+		return nil, err
+	}
+	for i := range file.Rules {
+		if err = file.Rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return file.Rules, nil
+}
+
+// Decide returns whether the request is allowed and the rules that decided it.
+func (e *Engine) Decide(req *models.PolicyRequest) models.Decision {
+	key, err := json.Marshal(req)
+	if err != nil {
+		e.log.Warnf("err marshalling policy request: %v", err)
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if err == nil {
+		if decision, ok := e.cache.get(string(key)); ok {
+			return decision
+		}
+	}
+	decision := decide(e.rules, req)
+	if err == nil {
+		e.cache.put(string(key), decision)
+	}
+	return decision
+}
+
+func decide(rules []Rule, req *models.PolicyRequest) models.Decision {
+	var allowed, denied []string
+	for i := range rules {
+		if !rules[i].matches(req) {
+			continue
+		}
+		if rules[i].Effect == EffectDeny {
+			denied = append(denied, rules[i].reason())
+		} else {
+			allowed = append(allowed, rules[i].reason())
+		}
+	}
+	switch {
+	case len(denied) > 0:
+		return models.Decision{Allow: false, Reasons: denied}
+	case len(allowed) > 0:
+		return models.Decision{Allow: true, Reasons: allowed}
+	default:
+		return models.Decision{Allow: false, Reasons: []string{"no rule allows " + req.Action}}
+	}
+}
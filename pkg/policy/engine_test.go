@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+const testPolicy = `{"rules": [
+	{"name": "read-own", "description": "owners read their orders", "effect": "allow", "actions": ["orders:read"],
+		"when": ["subject.id == resource.owner"]},
+	{"name": "support", "effect": "allow", "actions": ["orders:*"], "when": ["subject.roles contains support"]},
+	{"name": "blocked", "effect": "deny", "actions": ["*"], "when": ["subject.blocked == true"]}
+]}`
+
+func TestDecide(t *testing.T) {
+	dir := t.TempDir()
+	writePolicy(t, dir, "orders", testPolicy)
+	e, err := Load(logrus.New(), dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		subject models.Attributes
+		action  string
+		want    models.Decision
+	}{
+		{"owner", models.Attributes{"id": "user"}, "orders:read",
+			models.Decision{Allow: true, Reasons: []string{"owners read their orders"}}},
+		{"owner writing", models.Attributes{"id": "user"}, "orders:write",
+			models.Decision{Allow: false, Reasons: []string{"no rule allows orders:write"}}},
+		{"support", models.Attributes{"id": "staff", "roles": []interface{}{"support"}}, "orders:write",
+			models.Decision{Allow: true, Reasons: []string{"support"}}},
+		{"both allow", models.Attributes{"id": "user", "roles": []interface{}{"support"}}, "orders:read",
+			models.Decision{Allow: true, Reasons: []string{"owners read their orders", "support"}}},
+		{"deny overrides allow", models.Attributes{"id": "user", "roles": []interface{}{"support"}, "blocked": true},
+			"orders:read", models.Decision{Allow: false, Reasons: []string{"blocked"}}},
+		{"stranger", models.Attributes{"id": "stranger"}, "orders:read",
+			models.Decision{Allow: false, Reasons: []string{"no rule allows orders:read"}}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := e.Decide(&models.PolicyRequest{
+				Subject:  test.subject,
+				Action:   test.action,
+				Resource: models.Attributes{"owner": "user"},
+			})
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestReload checks that cached decisions are dropped on Reload and that broken policies leave the rules as is.
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	writePolicy(t, dir, "orders", testPolicy)
+	e, err := Load(logrus.New(), dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := models.PolicyRequest{Subject: models.Attributes{"id": "user"}, Action: "orders:read",
+		Resource: models.Attributes{"owner": "user"}}
+	if !e.Decide(&req).Allow {
+		t.Fatal("owner denied")
+	}
+
+	writePolicy(t, dir, "block", `{"rules": [{"name": "all", "effect": "deny", "actions": ["orders:*"]}]}`)
+	if !e.Decide(&req).Allow {
+		t.Fatal("decision not cached")
+	}
+	if err = e.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if e.Decide(&req).Allow {
+		t.Fatal("cached decision kept after reload")
+	}
+
+	for name, policy := range map[string]string{
+		"broken":    `{"rules": [`,
+		"condition": `{"rules": [{"name": "bad", "effect": "allow", "actions": ["*"], "when": ["a.b == c"]}]}`,
+		"effect":    `{"rules": [{"name": "bad", "effect": "maybe", "actions": ["*"]}]}`,
+		"duplicate": `{"rules": [{"name": "all", "effect": "allow", "actions": ["*"]}]}`,
+	} {
+		writePolicy(t, dir, "z"+name, policy)
+		if err = e.Reload(); err == nil {
+			t.Errorf("reloaded %s policy", name)
+		}
+		if err = os.Remove(filepath.Join(dir, "z"+name+policyFileExt)); err != nil {
+			t.Fatal(err)
+		}
+		if e.Decide(&req).Allow {
+			t.Fatalf("rules changed by %s policy", name)
+		}
+	}
+}
+
+func writePolicy(t *testing.T, dir, name, policy string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name+policyFileExt), []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,253 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+const (
+	opEqual    = "=="
+	opNotEqual = "!="
+	opIn       = "in"
+	opContains = "contains"
+)
+
+const (
+	sourceSubject  = "subject"
+	sourceResource = "resource"
+	sourceAction   = "action"
+)
+
+// Rule applies its effect to the actions it lists once all of its When conditions hold. Conditions are
+// "<operand> <op> <operand>", operands being subject.<attr>, resource.<attr>, action or a literal, quoted
+// if it has spaces or dots, ops being ==, !=, in (a value in a list) and contains (a list has a value).
+// A condition on a missing attribute doesn't hold. Actions may end with * to match by prefix.
+type Rule struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Effect      string   `json:"effect"`
+	Actions     []string `json:"actions"`
+	When        []string `json:"when,omitempty"`
+
+	conditions []condition
+}
+
+type condition struct {
+	left  operand
+	op    string
+	right operand
+}
+
+// operand is an attribute reference or, with an empty source, a literal value.
+type operand struct {
+	source string
+	name   string
+	value  string
+}
+
+// value is an attribute resolved for a request, either a scalar or a list.
+type value struct {
+	scalar string
+	list   []string
+	isList bool
+}
+
+func (r *Rule) compile() error {
+	if r.Name == "" {
+		return errors.New("err rule without name")
+	}
+	if r.Effect != EffectAllow && r.Effect != EffectDeny {
+		return fmt.Errorf("err rule %s: unknown effect %q", r.Name, r.Effect)
+	}
+	if len(r.Actions) == 0 {
+		return fmt.Errorf("err rule %s: no actions", r.Name)
+	}
+	r.conditions = make([]condition, 0, len(r.When))
+	for _, when := range r.When {
+		c, err := parseCondition(when)
+		if err != nil {
+			return fmt.Errorf("err rule %s: %w", r.Name, err)
+		}
+		r.conditions = append(r.conditions, c)
+	}
+	return nil
+}
+
+func (r *Rule) matches(req *models.PolicyRequest) bool {
+	if !r.matchesAction(req.Action) {
+		return false
+	}
+	for i := range r.conditions {
+		if !r.conditions[i].holds(req) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Rule) matchesAction(action string) bool {
+	for _, pattern := range r.Actions {
+		if pattern == action {
+			return true
+		}
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(action, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) reason() string {
+	if r.Description != "" {
+		return r.Description
+	}
+	return r.Name
+}
+
+func parseCondition(s string) (condition, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return condition{}, err
+	}
+	if len(tokens) != 3 {
+		return condition{}, fmt.Errorf("err condition %q should be <operand> <op> <operand>", s)
+	}
+	c := condition{op: tokens[1]}
+	switch c.op {
+	case opEqual, opNotEqual, opIn, opContains:
+	default:
+		return condition{}, fmt.Errorf("err condition %q: unknown op %s", s, c.op)
+	}
+	if c.left, err = parseOperand(tokens[0]); err != nil {
+		return condition{}, fmt.Errorf("err condition %q: %w", s, err)
+	}
+	if c.right, err = parseOperand(tokens[2]); err != nil {
+		return condition{}, fmt.Errorf("err condition %q: %w", s, err)
+	}
+	return c, nil
+}
+
+// tokenize splits s by spaces, keeping double quoted strings whole.
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("err unterminated string in %q", s)
+			}
+			tokens = append(tokens, quoted)
+			s = s[len(quoted):]
+			continue
+		}
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			end = len(s)
+		}
+		tokens = append(tokens, s[:end])
+		s = s[end:]
+	}
+	return tokens, nil
+}
+
+func parseOperand(token string) (operand, error) {
+	if strings.HasPrefix(token, `"`) {
+		literal, err := strconv.Unquote(token)
+		if err != nil {
+			return operand{}, fmt.Errorf("err invalid string %s", token)
+		}
+		return operand{value: literal}, nil
+	}
+	if token == sourceAction {
+		return operand{source: sourceAction}, nil
+	}
+	source, name, ok := strings.Cut(token, ".")
+	if !ok {
+		return operand{value: token}, nil
+	}
+	if (source != sourceSubject && source != sourceResource) || name == "" {
+		return operand{}, fmt.Errorf("err unknown attribute %s, quote literals with dots", token)
+	}
+	return operand{source: source, name: name}, nil
+}
+
+func (c *condition) holds(req *models.PolicyRequest) bool {
+	left, ok := c.left.resolve(req)
+	if !ok {
+		return false
+	}
+	right, ok := c.right.resolve(req)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case opEqual:
+		return !left.isList && !right.isList && left.scalar == right.scalar
+	case opNotEqual:
+		return !left.isList && !right.isList && left.scalar != right.scalar
+	case opIn:
+		return !left.isList && right.isList && common.Contains(right.list, left.scalar)
+	case opContains:
+		return left.isList && !right.isList && common.Contains(left.list, right.scalar)
+	default:
+		return false
+	}
+}
+
+func (o *operand) resolve(req *models.PolicyRequest) (value, bool) {
+	switch o.source {
+	case sourceAction:
+		return value{scalar: req.Action}, true
+	case sourceSubject:
+		return attribute(req.Subject, o.name)
+	case sourceResource:
+		return attribute(req.Resource, o.name)
+	default:
+		return value{scalar: o.value}, true
+	}
+}
+
+func attribute(attributes models.Attributes, name string) (value, bool) {
+	switch v := attributes[name].(type) {
+	case []string:
+		return value{list: v, isList: true}, true
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := scalar(item)
+			if !ok {
+				return value{}, false
+			}
+			list = append(list, s)
+		}
+		return value{list: list, isList: true}, true
+	default:
+		s, ok := scalar(v)
+		return value{scalar: s}, ok
+	}
+}
+
+func scalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int:
+		return strconv.Itoa(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
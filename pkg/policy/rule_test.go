@@ -0,0 +1,154 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+func TestTokenize(t *testing.T) {
+	for _, test := range []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{"subject.id == resource.owner", []string{"subject.id", "==", "resource.owner"}, false},
+		{"  action \t!=  orders:read ", []string{"action", "!=", "orders:read"}, false},
+		{`resource.host == "api.example.com"`, []string{"resource.host", "==", `"api.example.com"`}, false},
+		{`subject.name == "Jane \"J\" Doe"`, []string{"subject.name", "==", `"Jane \"J\" Doe"`}, false},
+		{`subject.name == "Jane`, nil, true},
+		{"", nil, false},
+	} {
+		got, err := tokenize(test.s)
+		if (err != nil) != test.wantErr {
+			t.Errorf("got %v tokenizing %q, want error %t", err, test.s, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got %q tokenizing %q, want %q", got, test.s, test.want)
+		}
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	for _, test := range []struct {
+		s       string
+		want    condition
+		wantErr bool
+	}{
+		{"subject.id == resource.owner", condition{
+			left:  operand{source: sourceSubject, name: "id"},
+			op:    opEqual,
+			right: operand{source: sourceResource, name: "owner"},
+		}, false},
+		{"action in subject.actions", condition{
+			left:  operand{source: sourceAction},
+			op:    opIn,
+			right: operand{source: sourceSubject, name: "actions"},
+		}, false},
+		{`resource.host != "api.example.com"`, condition{
+			left:  operand{source: sourceResource, name: "host"},
+			op:    opNotEqual,
+			right: operand{value: "api.example.com"},
+		}, false},
+		{"subject.roles contains admin", condition{
+			left:  operand{source: sourceSubject, name: "roles"},
+			op:    opContains,
+			right: operand{value: "admin"},
+		}, false},
+		{"resource.host == api.example.com", condition{}, true},
+		{"subject. == admin", condition{}, true},
+		{"subject.role = admin", condition{}, true},
+		{"subject.role ==", condition{}, true},
+		{"subject.role == admin or", condition{}, true},
+		{`subject.role == "admin`, condition{}, true},
+	} {
+		got, err := parseCondition(test.s)
+		if (err != nil) != test.wantErr {
+			t.Errorf("got %v parsing %q, want error %t", err, test.s, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("got %+v parsing %q, want %+v", got, test.s, test.want)
+		}
+	}
+}
+
+func TestConditionHolds(t *testing.T) {
+	req := models.PolicyRequest{
+		Subject: models.Attributes{
+			"id":       "user",
+			"roles":    []interface{}{"admin", "support"},
+			"teams":    []string{"billing"},
+			"level":    float64(3),
+			"verified": true,
+			"mixed":    []interface{}{"admin", map[string]interface{}{}},
+		},
+		Action: "orders:read",
+		Resource: models.Attributes{
+			"owner":   "user",
+			"host":    "api.example.com",
+			"level":   3,
+			"actions": []string{"orders:read", "orders:write"},
+		},
+	}
+	for _, test := range []struct {
+		s    string
+		want bool
+	}{
+		{"subject.id == resource.owner", true},
+		{"subject.id != resource.owner", false},
+		{`resource.host == "api.example.com"`, true},
+		{"subject.level == resource.level", true},
+		{"subject.verified == true", true},
+		{"action in resource.actions", true},
+		{"subject.id in resource.actions", false},
+		{"subject.roles contains admin", true},
+		{"subject.teams contains admin", false},
+		{`subject.roles contains "support"`, true},
+		// missing attributes hold for no op
+		{"subject.missing == resource.missing", false},
+		{"subject.missing != admin", false},
+		{"subject.missing in resource.actions", false},
+		{"subject.missing contains admin", false},
+		// lists and scalars don't mix
+		{"subject.roles == admin", false},
+		{"subject.roles != admin", false},
+		{"subject.id in resource.owner", false},
+		{"subject.id contains user", false},
+		{"resource.actions in resource.actions", false},
+		// lists of values other than scalars are as missing
+		{"subject.mixed contains admin", false},
+	} {
+		c, err := parseCondition(test.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.holds(&req); got != test.want {
+			t.Errorf("%q holds is %t, want %t", test.s, got, test.want)
+		}
+	}
+}
+
+func TestMatchesAction(t *testing.T) {
+	r := Rule{Actions: []string{"orders:read", "users:*", "*"}}
+	prefixed := Rule{Actions: []string{"orders:*"}}
+	for _, test := range []struct {
+		rule   *Rule
+		action string
+		want   bool
+	}{
+		{&r, "orders:read", true},
+		{&r, "anything", true},
+		{&prefixed, "orders:read", true},
+		{&prefixed, "orders:", true},
+		{&prefixed, "orders", false},
+		{&prefixed, "users:read", false},
+		{&prefixed, "", false},
+	} {
+		if got := test.rule.matchesAction(test.action); got != test.want {
+			t.Errorf("%v matches %q is %t, want %t", test.rule.Actions, test.action, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package profilestore
+
+import (
+	"context"
+
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+func (pg *PG) SaveAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	query := `
+INSERT INTO audit_log (id, action, actor, subject, client_id, details, created)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+;`
+	return pg.exec(ctx, "SaveAuditEntry", query, entry.ID, entry.Action, entry.Actor, entry.Subject, entry.ClientID,
+		entry.Details, entry.Created)
+}
@@ -0,0 +1,290 @@
+package profilestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/metrics"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	cacheTierMemory = "memory"
+	cacheTierRedis  = "redis"
+	redisKeyPrefix  = "auth:user:"
+	// redisVersionTTL keeps the versions of the keys in redis far longer than a lookup may take.
+	redisVersionTTL = time.Hour
+)
+
+// errInvalidated skips caching a user that was invalidated while being loaded.
+var errInvalidated = errors.New("err user invalidated while loaded")
+
+type CacheConfig struct {
+	// Size is how many users are kept in process, 0 not to keep any.
+	Size int
+	// TTL is how long a user is cached. Profiles updated on another instance may be seen only after it, redis or not,
+	// as every instance keeps the users it read in process.
+	TTL time.Duration
+	// NegativeTTL is how long a phone the tenant has no user with is remembered as such, 0 not to.
+	NegativeTTL time.Duration
+	// Redis is asked on misses of the process and shared by the instances, if set. Unknown phones are only
+	// remembered there then, so that signing in on one instance isn't missed by the others.
+	Redis redis.UniversalClient
+}
+
+// CachedStore reads users through a cache in process and, optionally, in redis. UpsertUser drops the user
+// from both, the rest of the methods go to the store as they are, GetUserByUUIDUncached included. Every key in redis has a version bumped on
+// invalidation, users are written to redis only if the version is the one read before loading them, so that
+// a user loaded before an update on any instance isn't cached after it.
+type CachedStore struct {
+	Store
+	log     *logrus.Entry
+	config  CacheConfig
+	metrics *metrics.Cache
+	mu      sync.Mutex
+	local   *userLRU
+	// generation is bumped on every invalidation not to cache what was read before it.
+	generation uint64
+}
+
+func NewCachedStore(log *logrus.Logger, store Store, config CacheConfig) *CachedStore {
+	return &CachedStore{
+		Store:   store,
+		log:     log.WithField("module", "userCache"),
+		config:  config,
+		metrics: metrics.NewCache().AutoRegister(),
+		local:   newUserLRU(config.Size),
+	}
+}
+
+func (c *CachedStore) GetUser(ctx context.Context, tenantID, phone string) (*models.User, error) {
+	return c.lookup(ctx, "GetUser", userPhoneKey(tenantID, phone), common.ErrPhoneNotFound,
+		func(ctx context.Context) (*models.User, error) {
+			return c.Store.GetUser(ctx, tenantID, phone)
+		})
+}
+
+func (c *CachedStore) GetUserByUUID(ctx context.Context, uuid string) (*models.User, error) {
+	return c.lookup(ctx, "GetUserByUUID", userUUIDKey(uuid), nil,
+		func(ctx context.Context) (*models.User, error) {
+			return c.Store.GetUserByUUID(ctx, uuid)
+		})
+}
+
+// UpsertUser drops the user after the write, a phone remembered as unknown included.
+func (c *CachedStore) UpsertUser(ctx context.Context, user *models.User) error {
+	err := c.Store.UpsertUser(ctx, user)
+	c.invalidate(ctx, "UpsertUser", user)
+	return err
+}
+
+// UpdateProfile drops the user after the write, as UpsertUser does.
+func (c *CachedStore) UpdateProfile(ctx context.Context, user *models.User, ifUpdated time.Time) error {
+	err := c.Store.UpdateProfile(ctx, user, ifUpdated)
+	c.invalidate(ctx, "UpdateProfile", user)
+	return err
+}
+
+func (c *CachedStore) invalidate(ctx context.Context, name string, user *models.User) {
+	keys := []string{userPhoneKey(user.TenantID, user.Phone), userUUIDKey(user.UUID)}
+	c.mu.Lock()
+	c.generation++
+	for _, key := range keys {
+		c.local.remove(key)
+	}
+	c.mu.Unlock()
+	if c.config.Redis == nil {
+		return
+	}
+	// the keys may be of different slots of a cluster, the version goes first not to miss a write in between
+	_, err := c.config.Redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Incr(ctx, versionKey(key))
+			pipe.Expire(ctx, versionKey(key), redisVersionTTL)
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		c.failed(name, err)
+	}
+}
+
+// Close closes the store and redis, if set.
+func (c *CachedStore) Close() {
+	c.Store.Close()
+	if c.config.Redis != nil {
+		if err := c.config.Redis.Close(); err != nil {
+			c.log.Warnf("err closing redis: %v", err)
+		}
+	}
+}
+
+// lookup answers from the cache or loads the user and caches it. notFound is the error of the store that is
+// cached as an unknown user, nil not to cache those.
+func (c *CachedStore) lookup(ctx context.Context, name, key string, notFound error,
+	load func(ctx context.Context) (*models.User, error)) (*models.User, error) {
+	c.mu.Lock()
+	user, ok := c.local.get(key, time.Now())
+	generation := c.generation
+	c.mu.Unlock()
+	if ok {
+		c.metrics.HitsTotal.WithLabelValues(cacheTierMemory, name).Inc()
+		return found(user, notFound)
+	}
+	c.metrics.MissesTotal.WithLabelValues(cacheTierMemory, name).Inc()
+	var version int64
+	var err error
+	if c.config.Redis != nil {
+		user, ok, version, err = c.getRemote(ctx, key)
+		switch {
+		case err != nil:
+			c.failed(name, err)
+		case ok:
+			c.metrics.HitsTotal.WithLabelValues(cacheTierRedis, name).Inc()
+			if user != nil {
+				c.putLocal(key, user, generation)
+			}
+			return found(user, notFound)
+		default:
+			c.metrics.MissesTotal.WithLabelValues(cacheTierRedis, name).Inc()
+		}
+	}
+	user, err = load(ctx)
+	switch {
+	case err == nil:
+	case notFound != nil && c.config.NegativeTTL > 0 && errors.Is(err, notFound):
+		c.remember(ctx, name, key, nil, generation, version)
+		return nil, err
+	default:
+		return nil, err
+	}
+	c.remember(ctx, name, key, user, generation, version)
+	return user, nil
+}
+
+// remember caches the user, nil for an unknown one, unless it was invalidated since the lookup started: in
+// process by the generation, in redis by the version of the key.
+func (c *CachedStore) remember(ctx context.Context, name, key string, user *models.User, generation uint64,
+	version int64) {
+	if user != nil || c.config.Redis == nil {
+		c.putLocal(key, user, generation)
+	}
+	if c.config.Redis == nil {
+		return
+	}
+	c.mu.Lock()
+	invalidated := c.generation != generation
+	c.mu.Unlock()
+	if invalidated {
+		return
+	}
+	ttl := c.config.TTL
+	var value []byte
+	if user == nil {
+		ttl = c.config.NegativeTTL
+	} else {
+		var err error
+		if value, err = json.Marshal(user); err != nil {
+			c.failed(name, err)
+			return
+		}
+	}
+	err := c.config.Redis.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, versionKey(key)).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if current != version {
+			return errInvalidated
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, value, ttl)
+			return nil
+		})
+		return err
+	}, versionKey(key))
+	switch {
+	case err == nil, errors.Is(err, errInvalidated), errors.Is(err, redis.TxFailedErr):
+	default:
+		c.failed(name, err)
+	}
+}
+
+func (c *CachedStore) putLocal(key string, user *models.User, generation uint64) {
+	ttl := c.config.TTL
+	if user == nil {
+		ttl = c.config.NegativeTTL
+	} else {
+		cached := copyUser(*user)
+		user = &cached
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.local.put(key, user, time.Now().Add(ttl))
+	}
+}
+
+// getRemote reports whether redis has the key, the user is nil if it is remembered as unknown. The version of
+// the key is returned along, for the user loaded on a miss to be written only if it's still the same.
+func (c *CachedStore) getRemote(ctx context.Context, key string) (*models.User, bool, int64, error) {
+	values, err := c.config.Redis.MGet(ctx, key, versionKey(key)).Result()
+	if err != nil {
+		return nil, false, 0, err
+	}
+	var version int64
+	if s, ok := values[1].(string); ok {
+		if version, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, false, 0, err
+		}
+	}
+	value, ok := values[0].(string)
+	switch {
+	case !ok:
+		return nil, false, version, nil
+	case value == "":
+		return nil, true, version, nil
+	}
+	var user models.User
+	if err = json.Unmarshal([]byte(value), &user); err != nil {
+		return nil, false, version, err
+	}
+	return &user, true, version, nil
+}
+
+// failed counts the error of the cache, the store is still asked so that the cache going down slows lookups only.
+func (c *CachedStore) failed(name string, err error) {
+	c.metrics.ErrsTotal.WithLabelValues(cacheTierRedis, name).Inc()
+	c.log.Warnf("err calling redis in %s: %v", name, err)
+}
+
+// found copies the cached user not to share it with the caller, nil is the user that wasn't found.
+func found(user *models.User, notFound error) (*models.User, error) {
+	if user == nil {
+		return nil, notFound
+	}
+	result := copyUser(*user)
+	return &result, nil
+}
+
+// Keys are hash tagged so that a key and its version are of the same slot of a cluster, as WATCH and MGET need.
+
+func userPhoneKey(tenantID, phone string) string {
+	return redisKeyPrefix + "{phone:" + tenantID + ":" + phone + "}"
+}
+
+func userUUIDKey(uuid string) string {
+	return redisKeyPrefix + "{uuid:" + uuid + "}"
+}
+
+func versionKey(key string) string {
+	return key + ":version"
+}
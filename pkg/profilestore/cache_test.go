@@ -0,0 +1,197 @@
+package profilestore_test
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/gerladeno/authorization-service/pkg/profilestore"
+	"github.com/gerladeno/authorization-service/pkg/profilestore/storetest"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var testCacheConfig = profilestore.CacheConfig{Size: 100, TTL: time.Minute, NegativeTTL: time.Minute}
+
+func TestCachedStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) profilestore.Store {
+		return profilestore.NewCachedStore(logrus.New(), profilestore.NewMemoryStore(context.Background()),
+			testCacheConfig)
+	})
+}
+
+func TestCachedStoreRedis(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) profilestore.Store {
+		config := testCacheConfig
+		config.Redis = newRedis(t, miniredis.RunT(t))
+		return profilestore.NewCachedStore(logrus.New(), profilestore.NewMemoryStore(context.Background()), config)
+	})
+}
+
+// TestCachedStoreShared checks that instances sharing redis load a user once and see its updates.
+func TestCachedStoreShared(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	store := &countingStore{Store: profilestore.NewMemoryStore(ctx)}
+	user := newUser(t, store.Store)
+	first, second := newInstance(t, store, server), newInstance(t, store, server)
+
+	for _, instance := range []*profilestore.CachedStore{first, second, first} {
+		if _, err := instance.GetUserByUUID(ctx, user.UUID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loads := atomic.LoadInt32(&store.loads); loads != 1 {
+		t.Fatalf("user loaded %d times, want once", loads)
+	}
+	if !hasKeyOf(server, user.UUID) {
+		t.Fatalf("user isn't in redis, keys %v", server.Keys())
+	}
+
+	user.Name = "Ivan"
+	if err := second.UpdateProfile(ctx, user, user.Updated); err != nil {
+		t.Fatal(err)
+	}
+	if hasKeyOf(server, user.UUID) {
+		t.Fatal("updated user left in redis")
+	}
+	// the first instance keeps its copy until the ttl, a new one reads the update through redis
+	got, err := newInstance(t, store, server).GetUserByUUID(ctx, user.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != user.Name {
+		t.Fatalf("got name %q, want %q", got.Name, user.Name)
+	}
+	// reads past the cache see it on the first one
+	if got, err = first.GetUserByUUIDUncached(ctx, user.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != user.Name {
+		t.Fatalf("got name %q read past the cache, want %q", got.Name, user.Name)
+	}
+}
+
+// TestCachedStoreInvalidatedWhileLoading checks that a user loaded before an update on another instance isn't
+// written to redis after it.
+func TestCachedStoreInvalidatedWhileLoading(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	store := profilestore.NewMemoryStore(ctx)
+	user := newUser(t, store)
+	slow := &countingStore{Store: store, loaded: make(chan struct{}), resume: make(chan struct{})}
+	reader, writer := newInstance(t, slow, server), newInstance(t, store, server)
+
+	done := make(chan error)
+	go func() {
+		_, err := reader.GetUserByUUID(ctx, user.UUID)
+		done <- err
+	}()
+	<-slow.loaded
+	user.Name = "Ivan"
+	if err := writer.UpdateProfile(ctx, user, user.Updated); err != nil {
+		t.Fatal(err)
+	}
+	close(slow.resume)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if hasKeyOf(server, user.UUID) {
+		got, _ := server.Get(keyOf(server, user.UUID))
+		t.Fatalf("user loaded before the update cached in redis: %s", got)
+	}
+	got, err := newInstance(t, store, server).GetUserByUUID(ctx, user.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != user.Name {
+		t.Fatalf("got name %q, want %q", got.Name, user.Name)
+	}
+}
+
+// TestCachedStoreUnknownPhone checks that a phone remembered as unknown in redis is forgotten on sign in.
+func TestCachedStoreUnknownPhone(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	store := profilestore.NewMemoryStore(ctx)
+	first, second := newInstance(t, store, server), newInstance(t, store, server)
+	phone := "+7" + uuid.New().String()[:10]
+	if _, err := first.GetUser(ctx, "default", phone); err == nil {
+		t.Fatal("found unknown phone")
+	}
+	user := models.User{UUID: uuid.New().String(), TenantID: "default", Phone: phone}
+	if err := second.UpsertUser(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	got, err := first.GetUser(ctx, "default", phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UUID != user.UUID {
+		t.Fatalf("got user %s, want %s", got.UUID, user.UUID)
+	}
+}
+
+// countingStore counts loads of users by uuid, holding them between loaded and resume if those are set.
+type countingStore struct {
+	profilestore.Store
+	loads  int32
+	loaded chan struct{}
+	resume chan struct{}
+}
+
+func (s *countingStore) GetUserByUUID(ctx context.Context, id string) (*models.User, error) {
+	atomic.AddInt32(&s.loads, 1)
+	user, err := s.Store.GetUserByUUID(ctx, id)
+	if s.loaded != nil {
+		close(s.loaded)
+		<-s.resume
+	}
+	return user, err
+}
+
+func newRedis(t *testing.T, server *miniredis.Miniredis) redis.UniversalClient {
+	t.Helper()
+	return redis.NewClient(&redis.Options{Addr: server.Addr()})
+}
+
+func newInstance(t *testing.T, store profilestore.Store, server *miniredis.Miniredis) *profilestore.CachedStore {
+	t.Helper()
+	config := testCacheConfig
+	config.Redis = newRedis(t, server)
+	cached := profilestore.NewCachedStore(logrus.New(), store, config)
+	t.Cleanup(func() { _ = config.Redis.Close() })
+	return cached
+}
+
+func newUser(t *testing.T, store profilestore.Store) *models.User {
+	t.Helper()
+	user := models.User{UUID: uuid.New().String(), TenantID: "default", Phone: "+7" + uuid.New().String()[:10]}
+	if err := store.UpsertUser(context.Background(), &user); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := store.GetUserByUUID(context.Background(), user.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return saved
+}
+
+// keyOf finds the key the user is cached under by uuid, the versions of keys aside.
+func keyOf(server *miniredis.Miniredis, id string) string {
+	for _, key := range server.Keys() {
+		if strings.Contains(key, id) && !strings.HasSuffix(key, ":version") {
+			return key
+		}
+	}
+	return ""
+}
+
+func hasKeyOf(server *miniredis.Miniredis, id string) bool {
+	return keyOf(server, id) != ""
+}
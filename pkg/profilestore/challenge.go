@@ -0,0 +1,100 @@
+package profilestore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func (pg *PG) GetChallenge(ctx context.Context, phone string) (*models.Challenge, error) {
+	query := `SELECT phone, channel, attempts, created, expires
+FROM verification_challenge
+WHERE phone = $1;`
+	var result models.Challenge
+	err := pg.retry(ctx, "GetChallenge", func(ctx context.Context, conn *pgxpool.Conn) error {
+		return pgxscan.Get(ctx, conn, &result, query, phone)
+	})
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, common.ErrChallengeNotFound
+	default:
+		return nil, err
+	}
+}
+
+func (pg *PG) SaveChallenge(ctx context.Context, challenge *models.Challenge) error {
+	query := `
+INSERT INTO verification_challenge (phone, channel, attempts, created, expires)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (phone) DO UPDATE SET channel  = excluded.channel,
+                                  attempts = excluded.attempts,
+                                  created  = excluded.created,
+                                  expires  = excluded.expires
+;`
+	return pg.exec(ctx, "SaveChallenge", query,
+		challenge.Phone, challenge.Channel, challenge.Attempts, challenge.Created, challenge.Expires)
+}
+
+// ClaimChallenge saves the challenge unless the phone has one created after createdBefore,
+// common.ErrResendTooSoon then.
+func (pg *PG) ClaimChallenge(ctx context.Context, challenge *models.Challenge, createdBefore time.Time) error {
+	query := `
+INSERT INTO verification_challenge (phone, channel, attempts, created, expires)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (phone) DO UPDATE SET channel  = excluded.channel,
+                                  attempts = excluded.attempts,
+                                  created  = excluded.created,
+                                  expires  = excluded.expires
+WHERE verification_challenge.created <= $6
+;`
+	affected, err := pg.execAffected(ctx, "ClaimChallenge", query, challenge.Phone, challenge.Channel,
+		challenge.Attempts, challenge.Created, challenge.Expires, createdBefore)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrResendTooSoon
+	}
+	return nil
+}
+
+func (pg *PG) IncrementAttempts(ctx context.Context, phone string) (int, error) {
+	query := `
+UPDATE verification_challenge
+SET attempts = attempts + 1
+WHERE phone = $1
+RETURNING attempts;`
+	var attempts int
+	// an attempt repeated after the connection failed might count twice
+	err := pg.once(ctx, "IncrementAttempts", func(ctx context.Context, conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, query, phone).Scan(&attempts)
+	})
+	switch {
+	case err == nil:
+		return attempts, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return 0, common.ErrChallengeNotFound
+	default:
+		return 0, err
+	}
+}
+
+func (pg *PG) DeleteChallenge(ctx context.Context, phone string) error {
+	query := `DELETE FROM verification_challenge WHERE phone = $1;`
+	affected, err := pg.execAffected(ctx, "DeleteChallenge", query, phone)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrChallengeNotFound
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package profilestore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func (pg *PG) SaveClient(ctx context.Context, client *models.APIClient) error {
+	query := `
+INSERT INTO api_client (id, tenant_id, name, secret_hash, allowed_endpoints, disabled, created, updated)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+;`
+	return pg.exec(ctx, "SaveClient", query, client.ID, client.TenantID, client.Name, client.SecretHash,
+		client.AllowedEndpoints, client.Disabled, client.Created, client.Updated)
+}
+
+func (pg *PG) GetClient(ctx context.Context, id string) (*models.APIClient, error) {
+	query := `SELECT id, tenant_id, name, secret_hash, allowed_endpoints, disabled, created, updated, last_used
+FROM api_client
+WHERE id = $1;`
+	var result models.APIClient
+	err := pg.retry(ctx, "GetClient", func(ctx context.Context, conn *pgxpool.Conn) error {
+		return pgxscan.Get(ctx, conn, &result, query, id)
+	})
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, common.ErrClientNotFound
+	default:
+		return nil, err
+	}
+}
+
+func (pg *PG) ListClients(ctx context.Context, tenantID string) ([]models.APIClient, error) {
+	query := `SELECT id, tenant_id, name, secret_hash, allowed_endpoints, disabled, created, updated, last_used
+FROM api_client
+WHERE tenant_id = $1
+ORDER BY created;`
+	var result []models.APIClient
+	err := pg.retry(ctx, "ListClients", func(ctx context.Context, conn *pgxpool.Conn) error {
+		result = nil
+		return pgxscan.Select(ctx, conn, &result, query, tenantID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (pg *PG) UpdateClientSecret(ctx context.Context, id, secretHash string) error {
+	query := `UPDATE api_client SET secret_hash = $2, updated = NOW() WHERE id = $1;`
+	return pg.updateClient(ctx, "UpdateClientSecret", query, id, secretHash)
+}
+
+func (pg *PG) DisableClient(ctx context.Context, id string) error {
+	query := `UPDATE api_client SET disabled = true, updated = NOW() WHERE id = $1;`
+	return pg.updateClient(ctx, "DisableClient", query, id)
+}
+
+func (pg *PG) TouchClient(ctx context.Context, id string, at time.Time) error {
+	query := `UPDATE api_client SET last_used = $2 WHERE id = $1;`
+	return pg.exec(ctx, "TouchClient", query, id, at)
+}
+
+// UseNonce records the nonce of a signed request of the client, failing with ErrNonceReused if it was used before.
+// Nonces of the client that expired are forgotten on the way.
+func (pg *PG) UseNonce(ctx context.Context, clientID, nonce string, expires time.Time) error {
+	now := time.Now().UTC()
+	if err := pg.exec(ctx, "UseNonce", `DELETE FROM api_nonce WHERE client_id = $1 AND expires < $2;`, clientID, now); err != nil {
+		return err
+	}
+	query := `
+INSERT INTO api_nonce (client_id, nonce, expires)
+VALUES ($1, $2, $3)
+ON CONFLICT DO NOTHING
+;`
+	affected, err := pg.execAffected(ctx, "UseNonce", query, clientID, nonce, expires)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrNonceReused
+	}
+	return nil
+}
+
+func (pg *PG) updateClient(ctx context.Context, name, query string, args ...interface{}) error {
+	affected, err := pg.execAffected(ctx, name, query, args...)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrClientNotFound
+	}
+	return nil
+}
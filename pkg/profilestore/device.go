@@ -0,0 +1,114 @@
+package profilestore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func (pg *PG) SaveDeviceCode(ctx context.Context, code *models.DeviceCode) error {
+	query := `
+INSERT INTO device_code (hash, user_code, client_id, scope, status, poll_interval, expires)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+;`
+	return pg.exec(ctx, "SaveDeviceCode", query, code.Hash, code.UserCode, code.ClientID, code.Scope, code.Status,
+		code.PollInterval, code.Expires)
+}
+
+// GetDeviceCode returns the pending code by the user code, common.ErrInvalidGrant if there is none or it expired.
+func (pg *PG) GetDeviceCode(ctx context.Context, userCode string) (*models.DeviceCode, error) {
+	query := `SELECT hash, user_code, client_id, scope, status, uuid, poll_interval, last_polled, auth_time, expires
+FROM device_code
+WHERE user_code = $1
+  AND status = 'pending'
+  AND expires > now()
+ORDER BY expires DESC
+LIMIT 1;`
+	var result models.DeviceCode
+	err := pg.retry(ctx, "GetDeviceCode", func(ctx context.Context, conn *pgxpool.Conn) error {
+		return pgxscan.Get(ctx, conn, &result, query, userCode)
+	})
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, common.ErrInvalidGrant
+	default:
+		return nil, err
+	}
+}
+
+// ResolveDeviceCode approves or denies the pending code, common.ErrInvalidGrant if there is none or it expired.
+func (pg *PG) ResolveDeviceCode(ctx context.Context, userCode, uuid, status string, at time.Time) error {
+	query := `UPDATE device_code
+SET status    = $3,
+    uuid      = $2,
+    auth_time = $4
+WHERE user_code = $1
+  AND status = 'pending'
+  AND expires > $4;`
+	affected, err := pg.execAffected(ctx, "ResolveDeviceCode", query, userCode, uuid, status, at)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrInvalidGrant
+	}
+	return nil
+}
+
+// PollDeviceCode records the poll of the device returning the code as it was before,
+// common.ErrInvalidGrant if there is none.
+func (pg *PG) PollDeviceCode(ctx context.Context, hash string, at time.Time) (*models.DeviceCode, error) {
+	query := `UPDATE device_code d
+SET last_polled = $2
+FROM device_code prev
+WHERE d.hash = prev.hash
+  AND d.hash = $1
+RETURNING prev.hash, prev.user_code, prev.client_id, prev.scope, prev.status, prev.uuid, prev.poll_interval,
+    prev.last_polled, prev.auth_time, prev.expires;`
+	var result models.DeviceCode
+	err := pg.retry(ctx, "PollDeviceCode", func(ctx context.Context, conn *pgxpool.Conn) error {
+		return pgxscan.Get(ctx, conn, &result, query, hash, at)
+	})
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, common.ErrInvalidGrant
+	default:
+		return nil, err
+	}
+}
+
+// SlowDownDeviceCode makes the device poll the code seconds less often, common.ErrInvalidGrant if there is none.
+func (pg *PG) SlowDownDeviceCode(ctx context.Context, hash string, seconds int) error {
+	query := `UPDATE device_code SET poll_interval = poll_interval + $2 WHERE hash = $1;`
+	affected, err := pg.execAffected(ctx, "SlowDownDeviceCode", query, hash, seconds)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrInvalidGrant
+	}
+	return nil
+}
+
+// DeleteDeviceCode removes the code once tokens are issued for it, common.ErrInvalidGrant if it's gone already.
+func (pg *PG) DeleteDeviceCode(ctx context.Context, hash string) error {
+	query := `DELETE FROM device_code WHERE hash = $1;`
+	affected, err := pg.execAffected(ctx, "DeleteDeviceCode", query, hash)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrInvalidGrant
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package profilestore
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+type lruEntry struct {
+	key     string
+	user    *models.User
+	expires time.Time
+}
+
+// userLRU remembers the last size users looked up, nil for the unknown ones. Not safe for concurrent use.
+type userLRU struct {
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newUserLRU(size int) *userLRU {
+	return &userLRU{size: size, order: list.New(), items: make(map[string]*list.Element, size)}
+}
+
+func (l *userLRU) get(key string, now time.Time) (*models.User, bool) {
+	e, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*lruEntry) //nolint:forcetypeassert
+	if !now.Before(entry.expires) {
+		l.order.Remove(e)
+		delete(l.items, key)
+		return nil, false
+	}
+	l.order.MoveToFront(e)
+	return entry.user, true
+}
+
+func (l *userLRU) put(key string, user *models.User, expires time.Time) {
+	if l.size <= 0 {
+		return
+	}
+	if e, ok := l.items[key]; ok {
+		entry := e.Value.(*lruEntry) //nolint:forcetypeassert
+		entry.user, entry.expires = user, expires
+		l.order.MoveToFront(e)
+		return
+	}
+	l.items[key] = l.order.PushFront(&lruEntry{key: key, user: user, expires: expires})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruEntry).key) //nolint:forcetypeassert
+	}
+}
+
+func (l *userLRU) remove(key string) {
+	if e, ok := l.items[key]; ok {
+		l.order.Remove(e)
+		delete(l.items, key)
+	}
+}
@@ -0,0 +1,674 @@
+package profilestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/authentication"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/gerladeno/authorization-service/pkg/verification"
+)
+
+// defaultTenant is seeded by the tenant migration, users belong to it unless told otherwise.
+const defaultTenant = "default"
+
+// MemoryStore keeps everything PG does in memory, for local runs and tests without postgres.
+// Challenges and sms codes are kept by the verification and authentication memory stores.
+type MemoryStore struct {
+	*verification.MemoryStore
+	*authentication.MemoryCodeStore
+
+	mu            sync.Mutex
+	users         map[string]models.User
+	phones        map[string]string
+	refreshTokens map[string]models.RefreshToken
+	revokedTokens map[string]revokedToken
+	revokedUsers  map[string]time.Time
+	clients       map[string]models.APIClient
+	nonces        map[string]time.Time
+	oauthClients  map[string]models.OAuthClient
+	codes         map[string]models.AuthorizationCode
+	deviceCodes   map[string]models.DeviceCode
+	audit         []models.AuditEntry
+	roles         map[string]models.Role
+	userRoles     map[string]map[string]bool
+	tenants       map[string]models.Tenant
+}
+
+type revokedToken struct {
+	expires   time.Time
+	revokedAt time.Time
+}
+
+// NewMemoryStore returns a Store that is dropped on restart, with the default tenant as the migrations create it.
+// Expired challenges and sms codes are purged until ctx is done.
+func NewMemoryStore(ctx context.Context) *MemoryStore {
+	now := time.Now().UTC()
+	return &MemoryStore{
+		MemoryStore:     verification.NewMemoryStore(ctx),
+		MemoryCodeStore: authentication.NewMemoryCodeStore(ctx),
+		users:           make(map[string]models.User),
+		phones:          make(map[string]string),
+		refreshTokens:   make(map[string]models.RefreshToken),
+		revokedTokens:   make(map[string]revokedToken),
+		revokedUsers:    make(map[string]time.Time),
+		clients:         make(map[string]models.APIClient),
+		nonces:          make(map[string]time.Time),
+		oauthClients:    make(map[string]models.OAuthClient),
+		codes:           make(map[string]models.AuthorizationCode),
+		deviceCodes:     make(map[string]models.DeviceCode),
+		roles:           make(map[string]models.Role),
+		userRoles:       make(map[string]map[string]bool),
+		tenants: map[string]models.Tenant{
+			defaultTenant: {ID: defaultTenant, Name: "Default", Hosts: []string{}, Created: now, Updated: now},
+		},
+	}
+}
+
+func (m *MemoryStore) Close() {}
+
+func (m *MemoryStore) GetUser(_ context.Context, tenantID, phone string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	uuid, ok := m.phones[phoneKey(tenantID, phone)]
+	if !ok {
+		return nil, common.ErrPhoneNotFound
+	}
+	user := copyUser(m.users[uuid])
+	return &user, nil
+}
+
+// GetUserByUUIDUncached is GetUserByUUID, the store doesn't cache. CachedStore reads it past the cache.
+func (m *MemoryStore) GetUserByUUIDUncached(ctx context.Context, uuid string) (*models.User, error) {
+	return m.GetUserByUUID(ctx, uuid)
+}
+
+func (m *MemoryStore) GetUserByUUID(_ context.Context, uuid string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[uuid]
+	if !ok {
+		return nil, common.ErrUserNotFound
+	}
+	user = copyUser(user)
+	return &user, nil
+}
+
+// UpsertUser creates the user or, if the tenant has one with the phone already, touches it keeping its uuid.
+func (m *MemoryStore) UpsertUser(_ context.Context, user *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tenants[user.TenantID]; !ok {
+		return fmt.Errorf("err inserting user: %w: %s", common.ErrTenantNotFound, user.TenantID)
+	}
+	now := time.Now().UTC()
+	key := phoneKey(user.TenantID, user.Phone)
+	if uuid, ok := m.phones[key]; ok {
+		existing := m.users[uuid]
+		existing.Updated = now
+		m.users[uuid] = existing
+		return nil
+	}
+	if _, ok := m.users[user.UUID]; ok {
+		return fmt.Errorf("err inserting user: uuid %s is taken", user.UUID)
+	}
+	m.users[user.UUID] = models.User{UUID: user.UUID, TenantID: user.TenantID, Phone: user.Phone,
+		Metadata: profileMetadata(nil), Created: now, Updated: now}
+	m.phones[key] = user.UUID
+	return nil
+}
+
+// UpdateProfile saves the profile fields of the user unless it was updated after ifUpdated, the time it was read.
+// The user gets the new update time.
+func (m *MemoryStore) UpdateProfile(_ context.Context, user *models.User, ifUpdated time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.users[user.UUID]
+	if !ok {
+		return common.ErrUserNotFound
+	}
+	if !existing.Updated.Equal(ifUpdated) {
+		return common.ErrUserModified
+	}
+	existing.Name, existing.Email, existing.AvatarURL = user.Name, user.Email, user.AvatarURL
+	existing.Locale, existing.TimeZone = user.Locale, user.TimeZone
+	existing.Metadata = append(json.RawMessage(nil), profileMetadata(user.Metadata)...)
+	existing.Updated = time.Now().UTC()
+	m.users[user.UUID] = existing
+	user.Updated = existing.Updated
+	return nil
+}
+
+// copyUser doesn't share the metadata of the user kept with the caller.
+func copyUser(user models.User) models.User {
+	user.Metadata = append(json.RawMessage(nil), user.Metadata...)
+	return user
+}
+
+func phoneKey(tenantID, phone string) string {
+	return tenantID + "|" + phone
+}
+
+func (m *MemoryStore) SaveRefreshToken(_ context.Context, token *models.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.refreshTokens[token.Hash]; ok {
+		return fmt.Errorf("err inserting refresh token: %s exists", token.Hash)
+	}
+	m.refreshTokens[token.Hash] = *token
+	return nil
+}
+
+func (m *MemoryStore) GetRefreshToken(_ context.Context, hash string) (*models.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.refreshTokens[hash]
+	if !ok {
+		return nil, common.ErrInvalidRefreshToken
+	}
+	return &token, nil
+}
+
+func (m *MemoryStore) UseRefreshToken(_ context.Context, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.refreshTokens[hash]
+	if !ok || token.Used {
+		return common.ErrRefreshTokenReused
+	}
+	token.Used = true
+	m.refreshTokens[hash] = token
+	return nil
+}
+
+func (m *MemoryStore) RevokeTokenFamily(_ context.Context, familyID string) error {
+	m.revokeRefreshTokens(func(token *models.RefreshToken) bool { return token.FamilyID == familyID })
+	return nil
+}
+
+func (m *MemoryStore) RevokeUserRefreshTokens(_ context.Context, uuid string) error {
+	m.revokeRefreshTokens(func(token *models.RefreshToken) bool { return token.UUID == uuid })
+	return nil
+}
+
+func (m *MemoryStore) revokeRefreshTokens(match func(token *models.RefreshToken) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, token := range m.refreshTokens {
+		if match(&token) {
+			token.Revoked = true
+			m.refreshTokens[hash] = token
+		}
+	}
+}
+
+// RevokeToken records the revocation once, revoking a token again doesn't move it.
+func (m *MemoryStore) RevokeToken(_ context.Context, jti string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.revokedTokens[jti]; !ok {
+		m.revokedTokens[jti] = revokedToken{expires: expires, revokedAt: time.Now()}
+	}
+	return nil
+}
+
+func (m *MemoryStore) IsTokenRevoked(_ context.Context, jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.revokedTokens[jti]
+	return ok, nil
+}
+
+func (m *MemoryStore) RevokedTokensSince(_ context.Context, since time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var result []string
+	for jti, token := range m.revokedTokens {
+		if token.revokedAt.After(since) && token.expires.After(now) {
+			result = append(result, jti)
+		}
+	}
+	return result, nil
+}
+
+// DeleteRevokedTokens forgets the revocations of tokens expired before the time, they are refused anyway.
+func (m *MemoryStore) DeleteRevokedTokens(_ context.Context, expiredBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for jti, token := range m.revokedTokens {
+		if token.expires.Before(expiredBefore) {
+			delete(m.revokedTokens, jti)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) RevokeUser(_ context.Context, uuid string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revokedUsers[uuid] = at
+	return nil
+}
+
+func (m *MemoryStore) UsersRevokedSince(_ context.Context, since time.Time) ([]models.RevokedUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []models.RevokedUser
+	for uuid, at := range m.revokedUsers {
+		if at.After(since) {
+			result = append(result, models.RevokedUser{UUID: uuid, RevokedAt: at})
+		}
+	}
+	return result, nil
+}
+
+func (m *MemoryStore) SaveClient(_ context.Context, client *models.APIClient) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.clients[client.ID]; ok {
+		return fmt.Errorf("err inserting client: %s exists", client.ID)
+	}
+	m.clients[client.ID] = copyAPIClient(client)
+	return nil
+}
+
+func (m *MemoryStore) GetClient(_ context.Context, id string) (*models.APIClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client, ok := m.clients[id]
+	if !ok {
+		return nil, common.ErrClientNotFound
+	}
+	result := copyAPIClient(&client)
+	return &result, nil
+}
+
+func (m *MemoryStore) ListClients(_ context.Context, tenantID string) ([]models.APIClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []models.APIClient
+	for id := range m.clients {
+		client := m.clients[id]
+		if client.TenantID != tenantID {
+			continue
+		}
+		result = append(result, copyAPIClient(&client))
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Created.Before(result[j].Created) })
+	return result, nil
+}
+
+func (m *MemoryStore) UpdateClientSecret(_ context.Context, id, secretHash string) error {
+	return m.updateClient(id, func(client *models.APIClient) {
+		client.SecretHash = secretHash
+		client.Updated = time.Now().UTC()
+	})
+}
+
+func (m *MemoryStore) DisableClient(_ context.Context, id string) error {
+	return m.updateClient(id, func(client *models.APIClient) {
+		client.Disabled = true
+		client.Updated = time.Now().UTC()
+	})
+}
+
+// TouchClient records the use of the client, unknown clients are ignored.
+func (m *MemoryStore) TouchClient(_ context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if client, ok := m.clients[id]; ok {
+		client.LastUsed = &at
+		m.clients[id] = client
+	}
+	return nil
+}
+
+// UseNonce records the nonce of a signed request of the client, failing with ErrNonceReused if it was used before.
+// Expired nonces are forgotten on the way.
+func (m *MemoryStore) UseNonce(_ context.Context, clientID, nonce string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for key, at := range m.nonces {
+		if at.Before(now) {
+			delete(m.nonces, key)
+		}
+	}
+	key := clientID + "|" + nonce
+	if _, ok := m.nonces[key]; ok {
+		return common.ErrNonceReused
+	}
+	m.nonces[key] = expires
+	return nil
+}
+
+func (m *MemoryStore) updateClient(id string, update func(client *models.APIClient)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client, ok := m.clients[id]
+	if !ok {
+		return common.ErrClientNotFound
+	}
+	update(&client)
+	m.clients[id] = client
+	return nil
+}
+
+func copyAPIClient(client *models.APIClient) models.APIClient {
+	result := *client
+	result.AllowedEndpoints = copyStrings(client.AllowedEndpoints)
+	if client.LastUsed != nil {
+		lastUsed := *client.LastUsed
+		result.LastUsed = &lastUsed
+	}
+	return result
+}
+
+func (m *MemoryStore) SaveOAuthClient(_ context.Context, client *models.OAuthClient) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.oauthClients[client.ID]; ok {
+		return fmt.Errorf("err inserting oauth client: %s exists", client.ID)
+	}
+	m.oauthClients[client.ID] = copyOAuthClient(client)
+	return nil
+}
+
+func (m *MemoryStore) GetOAuthClient(_ context.Context, id string) (*models.OAuthClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client, ok := m.oauthClients[id]
+	if !ok {
+		return nil, common.ErrClientNotFound
+	}
+	result := copyOAuthClient(&client)
+	return &result, nil
+}
+
+func (m *MemoryStore) ListOAuthClients(_ context.Context, tenantID string) ([]models.OAuthClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []models.OAuthClient
+	for id := range m.oauthClients {
+		client := m.oauthClients[id]
+		if client.TenantID != tenantID {
+			continue
+		}
+		result = append(result, copyOAuthClient(&client))
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Created.Before(result[j].Created) })
+	return result, nil
+}
+
+func copyOAuthClient(client *models.OAuthClient) models.OAuthClient {
+	result := *client
+	result.RedirectURIs = copyStrings(client.RedirectURIs)
+	result.Scopes = copyStrings(client.Scopes)
+	result.GrantTypes = copyStrings(client.GrantTypes)
+	result.Audiences = copyStrings(client.Audiences)
+	return result
+}
+
+func (m *MemoryStore) SaveAuthorizationCode(_ context.Context, code *models.AuthorizationCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.codes[code.Hash]; ok {
+		return fmt.Errorf("err inserting authorization code: %s exists", code.Hash)
+	}
+	m.codes[code.Hash] = *code
+	return nil
+}
+
+// TakeAuthorizationCode deletes the code returning it, so that it can be exchanged once.
+func (m *MemoryStore) TakeAuthorizationCode(_ context.Context, hash string) (*models.AuthorizationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.codes[hash]
+	if !ok {
+		return nil, common.ErrInvalidGrant
+	}
+	delete(m.codes, hash)
+	return &code, nil
+}
+
+func (m *MemoryStore) SaveDeviceCode(_ context.Context, code *models.DeviceCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.deviceCodes[code.Hash]; ok {
+		return fmt.Errorf("err inserting device code: %s exists", code.Hash)
+	}
+	m.deviceCodes[code.Hash] = copyDeviceCode(code)
+	return nil
+}
+
+// GetDeviceCode returns the pending code by the user code, common.ErrInvalidGrant if there is none or it expired.
+func (m *MemoryStore) GetDeviceCode(_ context.Context, userCode string) (*models.DeviceCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found, ok := m.pendingDeviceCode(userCode, time.Now())
+	if !ok {
+		return nil, common.ErrInvalidGrant
+	}
+	result := copyDeviceCode(&found)
+	return &result, nil
+}
+
+// ResolveDeviceCode approves or denies the pending code, common.ErrInvalidGrant if there is none or it expired.
+func (m *MemoryStore) ResolveDeviceCode(_ context.Context, userCode, uuid, status string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found, ok := m.pendingDeviceCode(userCode, at)
+	if !ok {
+		return common.ErrInvalidGrant
+	}
+	found.Status, found.UUID, found.AuthTime = status, uuid, &at
+	m.deviceCodes[found.Hash] = found
+	return nil
+}
+
+// pendingDeviceCode finds the pending code by the user code not expired at the time, the one expiring
+// the last if there are several.
+func (m *MemoryStore) pendingDeviceCode(userCode string, at time.Time) (models.DeviceCode, bool) {
+	var found models.DeviceCode
+	var ok bool
+	for _, code := range m.deviceCodes {
+		if code.UserCode != userCode || code.Status != models.DeviceCodePending || !code.Expires.After(at) {
+			continue
+		}
+		if !ok || code.Expires.After(found.Expires) {
+			found, ok = code, true
+		}
+	}
+	return found, ok
+}
+
+// PollDeviceCode records the poll of the device returning the code as it was before,
+// common.ErrInvalidGrant if there is none.
+func (m *MemoryStore) PollDeviceCode(_ context.Context, hash string, at time.Time) (*models.DeviceCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.deviceCodes[hash]
+	if !ok {
+		return nil, common.ErrInvalidGrant
+	}
+	prev := copyDeviceCode(&code)
+	code.LastPolled = &at
+	m.deviceCodes[hash] = code
+	return &prev, nil
+}
+
+// SlowDownDeviceCode makes the device poll the code seconds less often, common.ErrInvalidGrant if there is none.
+func (m *MemoryStore) SlowDownDeviceCode(_ context.Context, hash string, seconds int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.deviceCodes[hash]
+	if !ok {
+		return common.ErrInvalidGrant
+	}
+	code.PollInterval += seconds
+	m.deviceCodes[hash] = code
+	return nil
+}
+
+// DeleteDeviceCode removes the code once tokens are issued for it, common.ErrInvalidGrant if it's gone already.
+func (m *MemoryStore) DeleteDeviceCode(_ context.Context, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.deviceCodes[hash]; !ok {
+		return common.ErrInvalidGrant
+	}
+	delete(m.deviceCodes, hash)
+	return nil
+}
+
+func copyDeviceCode(code *models.DeviceCode) models.DeviceCode {
+	result := *code
+	if code.LastPolled != nil {
+		lastPolled := *code.LastPolled
+		result.LastPolled = &lastPolled
+	}
+	if code.AuthTime != nil {
+		authTime := *code.AuthTime
+		result.AuthTime = &authTime
+	}
+	return result
+}
+
+func (m *MemoryStore) SaveAuditEntry(_ context.Context, entry *models.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.audit {
+		if m.audit[i].ID == entry.ID {
+			return fmt.Errorf("err inserting audit entry: %s exists", entry.ID)
+		}
+	}
+	m.audit = append(m.audit, *entry)
+	return nil
+}
+
+// SaveRole creates the role of its tenant or replaces the description and permissions of the existing one.
+func (m *MemoryStore) SaveRole(_ context.Context, role *models.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tenants[role.TenantID]; !ok {
+		return fmt.Errorf("err saving role: %w: %s", common.ErrTenantNotFound, role.TenantID)
+	}
+	saved := *role
+	saved.Permissions = copyStrings(role.Permissions)
+	key := roleKey(role.TenantID, role.Name)
+	if existing, ok := m.roles[key]; ok {
+		saved.Created = existing.Created
+	}
+	m.roles[key] = saved
+	return nil
+}
+
+func (m *MemoryStore) ListRoles(_ context.Context, tenantID string) ([]models.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sortedRoles(tenantID, func(string) bool { return true }), nil
+}
+
+// DeleteRole removes the role along with its assignments.
+func (m *MemoryStore) DeleteRole(_ context.Context, tenantID, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := roleKey(tenantID, name)
+	if _, ok := m.roles[key]; !ok {
+		return common.ErrRoleNotFound
+	}
+	delete(m.roles, key)
+	for _, roles := range m.userRoles {
+		delete(roles, key)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetUserRoles(_ context.Context, tenantID, uuid string) ([]models.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	assigned := m.userRoles[uuid]
+	return m.sortedRoles(tenantID, func(key string) bool { return assigned[key] }), nil
+}
+
+func (m *MemoryStore) sortedRoles(tenantID string, include func(key string) bool) []models.Role {
+	var result []models.Role
+	for key, role := range m.roles {
+		if role.TenantID == tenantID && include(key) {
+			role.Permissions = copyStrings(role.Permissions)
+			result = append(result, role)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// AssignRole gives the role of the tenant to the user, common.ErrRoleNotFound is returned if there is no such
+// role. Assigning a role the user has already is not an error.
+func (m *MemoryStore) AssignRole(_ context.Context, tenantID, uuid, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := roleKey(tenantID, role)
+	if _, ok := m.roles[key]; !ok {
+		return common.ErrRoleNotFound
+	}
+	if m.userRoles[uuid] == nil {
+		m.userRoles[uuid] = make(map[string]bool)
+	}
+	m.userRoles[uuid][key] = true
+	return nil
+}
+
+// UnassignRole takes the role from the user, common.ErrRoleNotFound is returned if the user doesn't have it.
+func (m *MemoryStore) UnassignRole(_ context.Context, tenantID, uuid, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := roleKey(tenantID, role)
+	if !m.userRoles[uuid][key] {
+		return common.ErrRoleNotFound
+	}
+	delete(m.userRoles[uuid], key)
+	return nil
+}
+
+func roleKey(tenantID, name string) string {
+	return tenantID + "|" + name
+}
+
+// SaveTenant creates the tenant or replaces the name, hosts and issuer of the existing one.
+func (m *MemoryStore) SaveTenant(_ context.Context, tenant *models.Tenant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved := *tenant
+	saved.Hosts = copyStrings(tenant.Hosts)
+	if existing, ok := m.tenants[tenant.ID]; ok {
+		saved.Created = existing.Created
+	}
+	m.tenants[tenant.ID] = saved
+	return nil
+}
+
+func (m *MemoryStore) ListTenants(_ context.Context) ([]models.Tenant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []models.Tenant
+	for _, tenant := range m.tenants {
+		tenant.Hosts = copyStrings(tenant.Hosts)
+		result = append(result, tenant)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func copyStrings(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append([]string{}, list...)
+}
@@ -0,0 +1,15 @@
+package profilestore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gerladeno/authorization-service/pkg/profilestore"
+	"github.com/gerladeno/authorization-service/pkg/profilestore/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) profilestore.Store {
+		return profilestore.NewMemoryStore(context.Background())
+	})
+}
@@ -0,0 +1,18 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table verification_challenge
+(
+    phone    text        not null
+        constraint verification_challenge_pk
+            primary key,
+    attempts integer     not null default 0,
+    created  timestamptz not null default now(),
+    expires  timestamptz not null
+);
+
+-- +migrate Down
+
+DROP TABLE verification_challenge;
@@ -0,0 +1,23 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table refresh_token
+(
+    hash      text        not null
+        constraint refresh_token_pk
+            primary key,
+    family_id text        not null,
+    uuid      text        not null,
+    used      boolean     not null default false,
+    revoked   boolean     not null default false,
+    created   timestamptz not null default now(),
+    expires   timestamptz not null
+);
+
+CREATE INDEX refresh_token_family_idx ON refresh_token (family_id);
+
+-- +migrate Down
+
+DROP TABLE refresh_token;
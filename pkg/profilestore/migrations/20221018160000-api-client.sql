@@ -0,0 +1,22 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table api_client
+(
+    id                text        not null
+        constraint api_client_pk
+            primary key,
+    name              text        not null,
+    secret_hash       text        not null,
+    allowed_endpoints text[]      not null default '{}',
+    disabled          boolean     not null default false,
+    created           timestamptz not null default now(),
+    updated           timestamptz not null default now(),
+    last_used         timestamptz
+);
+
+-- +migrate Down
+
+DROP TABLE api_client;
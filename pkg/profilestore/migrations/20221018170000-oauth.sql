@@ -0,0 +1,41 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+ALTER TABLE refresh_token
+    ADD COLUMN client_id text NOT NULL DEFAULT '',
+    ADD COLUMN scope     text NOT NULL DEFAULT '';
+
+create table oauth_client
+(
+    id            text        not null
+        constraint oauth_client_pk
+            primary key,
+    name          text        not null,
+    secret_hash   text        not null default '',
+    redirect_uris text[]      not null default '{}',
+    scopes        text[]      not null default '{}',
+    created       timestamptz not null default now()
+);
+
+create table authorization_code
+(
+    hash           text        not null
+        constraint authorization_code_pk
+            primary key,
+    client_id      text        not null,
+    redirect_uri   text        not null,
+    uuid           text        not null,
+    scope          text        not null default '',
+    code_challenge text        not null,
+    expires        timestamptz not null
+);
+
+-- +migrate Down
+
+DROP TABLE authorization_code;
+DROP TABLE oauth_client;
+ALTER TABLE refresh_token
+    DROP COLUMN client_id,
+    DROP COLUMN scope;
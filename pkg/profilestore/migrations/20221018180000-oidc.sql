@@ -0,0 +1,14 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+ALTER TABLE authorization_code
+    ADD COLUMN nonce     text        NOT NULL DEFAULT '',
+    ADD COLUMN auth_time timestamptz NOT NULL DEFAULT now();
+
+-- +migrate Down
+
+ALTER TABLE authorization_code
+    DROP COLUMN nonce,
+    DROP COLUMN auth_time;
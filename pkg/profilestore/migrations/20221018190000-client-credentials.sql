@@ -0,0 +1,14 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+ALTER TABLE oauth_client
+    ADD COLUMN grant_types text[] NOT NULL DEFAULT '{authorization_code,refresh_token}',
+    ADD COLUMN audiences   text[] NOT NULL DEFAULT '{}';
+
+-- +migrate Down
+
+ALTER TABLE oauth_client
+    DROP COLUMN grant_types,
+    DROP COLUMN audiences;
@@ -0,0 +1,26 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table device_code
+(
+    hash          text        not null
+        constraint device_code_pk
+            primary key,
+    user_code     text        not null,
+    client_id     text        not null,
+    scope         text        not null default '',
+    status        text        not null default 'pending',
+    uuid          text        not null default '',
+    poll_interval integer     not null,
+    last_polled   timestamptz,
+    auth_time     timestamptz,
+    expires       timestamptz not null
+);
+
+create index device_code_user_code_idx on device_code (user_code);
+
+-- +migrate Down
+
+DROP TABLE device_code;
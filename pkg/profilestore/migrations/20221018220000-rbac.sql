@@ -0,0 +1,32 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table role
+(
+    name        text        not null
+        constraint role_pk
+            primary key,
+    description text        not null default '',
+    permissions text[]      not null default '{}',
+    created     timestamptz not null default now(),
+    updated     timestamptz not null default now()
+);
+
+create table user_role
+(
+    uuid    text        not null,
+    role    text        not null
+        constraint user_role_role_fk
+            references role (name)
+            on delete cascade,
+    created timestamptz not null default now(),
+    constraint user_role_pk
+        primary key (uuid, role)
+);
+
+-- +migrate Down
+
+DROP TABLE user_role;
+DROP TABLE role;
@@ -0,0 +1,46 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table tenant
+(
+    id      text        not null
+        constraint tenant_pk
+            primary key,
+    name    text        not null default '',
+    hosts   text[]      not null default '{}',
+    issuer  text        not null default '',
+    created timestamptz not null default now(),
+    updated timestamptz not null default now()
+);
+
+INSERT INTO tenant (id, name)
+VALUES ('default', 'Default');
+
+ALTER TABLE user_model
+    ADD COLUMN tenant_id text not null default 'default'
+        constraint user_model_tenant_fk
+            references tenant (id);
+ALTER TABLE user_model
+    DROP CONSTRAINT unique_phone;
+ALTER TABLE user_model
+    ADD CONSTRAINT unique_tenant_phone unique (tenant_id, phone);
+
+ALTER TABLE refresh_token
+    ADD COLUMN tenant_id text not null default 'default';
+
+-- +migrate Down
+
+ALTER TABLE refresh_token
+    DROP COLUMN tenant_id;
+DELETE
+FROM user_model
+WHERE tenant_id <> 'default';
+ALTER TABLE user_model
+    DROP CONSTRAINT unique_tenant_phone;
+ALTER TABLE user_model
+    ADD CONSTRAINT unique_phone unique (phone);
+ALTER TABLE user_model
+    DROP COLUMN tenant_id;
+DROP TABLE tenant;
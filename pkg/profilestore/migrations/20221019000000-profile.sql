@@ -0,0 +1,22 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+ALTER TABLE user_model
+    ADD COLUMN name       text  not null default '',
+    ADD COLUMN email      text  not null default '',
+    ADD COLUMN avatar_url text  not null default '',
+    ADD COLUMN locale     text  not null default '',
+    ADD COLUMN time_zone  text  not null default '',
+    ADD COLUMN metadata   jsonb not null default '{}';
+
+-- +migrate Down
+
+ALTER TABLE user_model
+    DROP COLUMN name,
+    DROP COLUMN email,
+    DROP COLUMN avatar_url,
+    DROP COLUMN locale,
+    DROP COLUMN time_zone,
+    DROP COLUMN metadata;
@@ -0,0 +1,67 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+ALTER TABLE user_role
+    DROP CONSTRAINT user_role_role_fk;
+ALTER TABLE user_role
+    DROP CONSTRAINT user_role_pk;
+ALTER TABLE role
+    DROP CONSTRAINT role_pk;
+
+ALTER TABLE role
+    ADD COLUMN tenant_id text not null default 'default'
+        constraint role_tenant_fk
+            references tenant (id);
+ALTER TABLE role
+    ADD CONSTRAINT role_pk primary key (tenant_id, name);
+
+-- users of other tenants keep their roles, copied into their tenant
+INSERT INTO role (tenant_id, name, description, permissions, created, updated)
+SELECT DISTINCT u.tenant_id, r.name, r.description, r.permissions, r.created, r.updated
+FROM user_role ur
+         JOIN user_model u ON u.uuid = ur.uuid
+         JOIN role r ON r.name = ur.role AND r.tenant_id = 'default'
+WHERE u.tenant_id <> 'default';
+
+ALTER TABLE user_role
+    ADD COLUMN tenant_id text not null default 'default';
+UPDATE user_role ur
+SET tenant_id = u.tenant_id
+FROM user_model u
+WHERE u.uuid = ur.uuid;
+ALTER TABLE user_role
+    ADD CONSTRAINT user_role_pk primary key (tenant_id, uuid, role);
+ALTER TABLE user_role
+    ADD CONSTRAINT user_role_role_fk foreign key (tenant_id, role)
+        references role (tenant_id, name)
+        on delete cascade;
+
+-- +migrate Down
+
+ALTER TABLE user_role
+    DROP CONSTRAINT user_role_role_fk;
+ALTER TABLE user_role
+    DROP CONSTRAINT user_role_pk;
+DELETE
+FROM user_role
+WHERE tenant_id <> 'default';
+ALTER TABLE user_role
+    DROP COLUMN tenant_id;
+ALTER TABLE user_role
+    ADD CONSTRAINT user_role_pk primary key (uuid, role);
+
+ALTER TABLE role
+    DROP CONSTRAINT role_pk;
+DELETE
+FROM role
+WHERE tenant_id <> 'default';
+ALTER TABLE role
+    DROP COLUMN tenant_id;
+ALTER TABLE role
+    ADD CONSTRAINT role_pk primary key (name);
+ALTER TABLE user_role
+    ADD CONSTRAINT user_role_role_fk foreign key (role)
+        references role (name)
+        on delete cascade;
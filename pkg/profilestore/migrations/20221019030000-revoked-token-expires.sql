@@ -0,0 +1,10 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+CREATE INDEX revoked_token_expires_idx ON revoked_token (expires);
+
+-- +migrate Down
+
+DROP INDEX revoked_token_expires_idx;
@@ -0,0 +1,22 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table user_model
+(
+    uuid    text not null
+        constraint uuid_pk
+            primary key,
+    phone   text not null
+        constraint unique_phone
+            unique,
+    created text default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    updated text default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+CREATE INDEX phone_idx ON user_model (phone);
+
+-- +migrate Down
+
+DROP TABLE user_model;
@@ -0,0 +1,23 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+ALTER TABLE verification_challenge
+    ADD COLUMN channel text not null default 'flashcall';
+
+CREATE TABLE sms_code
+(
+    phone     text not null
+        constraint sms_code_pk
+            primary key,
+    code_hash text not null,
+    expires   text not null
+);
+
+-- +migrate Down
+
+DROP TABLE sms_code;
+
+ALTER TABLE verification_challenge
+    DROP COLUMN channel;
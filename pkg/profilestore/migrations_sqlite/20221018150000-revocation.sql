@@ -0,0 +1,33 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table revoked_token
+(
+    jti        text not null
+        constraint revoked_token_pk
+            primary key,
+    expires    text not null,
+    revoked_at text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+CREATE INDEX revoked_token_revoked_at_idx ON revoked_token (revoked_at);
+
+create table revoked_user
+(
+    uuid       text not null
+        constraint revoked_user_pk
+            primary key,
+    revoked_at text not null
+);
+
+CREATE INDEX refresh_token_uuid_idx ON refresh_token (uuid);
+
+-- +migrate Down
+
+DROP INDEX refresh_token_uuid_idx;
+
+DROP TABLE revoked_user;
+
+DROP TABLE revoked_token;
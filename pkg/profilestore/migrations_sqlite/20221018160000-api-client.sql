@@ -0,0 +1,23 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- lists are kept as json arrays
+create table api_client
+(
+    id                text    not null
+        constraint api_client_pk
+            primary key,
+    name              text    not null,
+    secret_hash       text    not null,
+    allowed_endpoints text    not null default '[]',
+    disabled          boolean not null default false,
+    created           text    not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    updated           text    not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    last_used         text
+);
+
+-- +migrate Down
+
+DROP TABLE api_client;
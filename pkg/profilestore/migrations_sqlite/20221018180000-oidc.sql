@@ -0,0 +1,17 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+ALTER TABLE authorization_code
+    ADD COLUMN nonce text NOT NULL DEFAULT '';
+-- columns added later can't default to an expression
+ALTER TABLE authorization_code
+    ADD COLUMN auth_time text NOT NULL DEFAULT '';
+
+-- +migrate Down
+
+ALTER TABLE authorization_code
+    DROP COLUMN nonce;
+ALTER TABLE authorization_code
+    DROP COLUMN auth_time;
@@ -0,0 +1,16 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+ALTER TABLE oauth_client
+    ADD COLUMN grant_types text NOT NULL DEFAULT '["authorization_code","refresh_token"]';
+ALTER TABLE oauth_client
+    ADD COLUMN audiences text NOT NULL DEFAULT '[]';
+
+-- +migrate Down
+
+ALTER TABLE oauth_client
+    DROP COLUMN grant_types;
+ALTER TABLE oauth_client
+    DROP COLUMN audiences;
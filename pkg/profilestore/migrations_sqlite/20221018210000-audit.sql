@@ -0,0 +1,24 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table audit_log
+(
+    id        text not null
+        constraint audit_log_pk
+            primary key,
+    action    text not null,
+    actor     text not null,
+    subject   text not null,
+    client_id text not null default '',
+    details   text not null default '',
+    created   text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+create index audit_log_subject_idx on audit_log (subject, created);
+create index audit_log_actor_idx on audit_log (actor, created);
+
+-- +migrate Down
+
+DROP TABLE audit_log;
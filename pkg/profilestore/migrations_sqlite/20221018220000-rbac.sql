@@ -0,0 +1,32 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table role
+(
+    name        text not null
+        constraint role_pk
+            primary key,
+    description text not null default '',
+    permissions text not null default '[]',
+    created     text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    updated     text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+create table user_role
+(
+    uuid    text not null,
+    role    text not null
+        constraint user_role_role_fk
+            references role (name)
+            on delete cascade,
+    created text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    constraint user_role_pk
+        primary key (uuid, role)
+);
+
+-- +migrate Down
+
+DROP TABLE user_role;
+DROP TABLE role;
@@ -0,0 +1,73 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table tenant
+(
+    id      text not null
+        constraint tenant_pk
+            primary key,
+    name    text not null default '',
+    hosts   text not null default '[]',
+    issuer  text not null default '',
+    created text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    updated text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+INSERT INTO tenant (id, name)
+VALUES ('default', 'Default');
+
+-- constraints can't be altered, the table is rebuilt with them
+create table user_model_tenant
+(
+    uuid      text not null
+        constraint uuid_pk
+            primary key,
+    tenant_id text not null default 'default'
+        constraint user_model_tenant_fk
+            references tenant (id),
+    phone     text not null,
+    created   text default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    updated   text default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    constraint unique_tenant_phone
+        unique (tenant_id, phone)
+);
+
+INSERT INTO user_model_tenant (uuid, phone, created, updated)
+SELECT uuid, phone, created, updated
+FROM user_model;
+DROP TABLE user_model;
+ALTER TABLE user_model_tenant
+    RENAME TO user_model;
+CREATE INDEX phone_idx ON user_model (phone);
+
+ALTER TABLE refresh_token
+    ADD COLUMN tenant_id text not null default 'default';
+
+-- +migrate Down
+
+ALTER TABLE refresh_token
+    DROP COLUMN tenant_id;
+
+create table user_model_phone
+(
+    uuid    text not null
+        constraint uuid_pk
+            primary key,
+    phone   text not null
+        constraint unique_phone
+            unique,
+    created text default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    updated text default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+INSERT INTO user_model_phone (uuid, phone, created, updated)
+SELECT uuid, phone, created, updated
+FROM user_model
+WHERE tenant_id = 'default';
+DROP TABLE user_model;
+ALTER TABLE user_model_phone
+    RENAME TO user_model;
+CREATE INDEX phone_idx ON user_model (phone);
+DROP TABLE tenant;
@@ -0,0 +1,32 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+ALTER TABLE user_model
+    ADD COLUMN name text not null default '';
+ALTER TABLE user_model
+    ADD COLUMN email text not null default '';
+ALTER TABLE user_model
+    ADD COLUMN avatar_url text not null default '';
+ALTER TABLE user_model
+    ADD COLUMN locale text not null default '';
+ALTER TABLE user_model
+    ADD COLUMN time_zone text not null default '';
+ALTER TABLE user_model
+    ADD COLUMN metadata text not null default '{}';
+
+-- +migrate Down
+
+ALTER TABLE user_model
+    DROP COLUMN metadata;
+ALTER TABLE user_model
+    DROP COLUMN time_zone;
+ALTER TABLE user_model
+    DROP COLUMN locale;
+ALTER TABLE user_model
+    DROP COLUMN avatar_url;
+ALTER TABLE user_model
+    DROP COLUMN email;
+ALTER TABLE user_model
+    DROP COLUMN name;
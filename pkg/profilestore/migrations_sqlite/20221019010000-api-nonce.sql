@@ -0,0 +1,17 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+create table api_nonce
+(
+    client_id text not null,
+    nonce     text not null,
+    expires   text not null,
+    constraint api_nonce_pk
+        primary key (client_id, nonce)
+);
+
+-- +migrate Down
+
+DROP TABLE api_nonce;
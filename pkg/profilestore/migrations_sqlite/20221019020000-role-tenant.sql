@@ -0,0 +1,98 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+-- constraints can't be altered, the tables are rebuilt with them
+create table role_tenant
+(
+    tenant_id   text not null default 'default'
+        constraint role_tenant_fk
+            references tenant (id),
+    name        text not null,
+    description text not null default '',
+    permissions text not null default '[]',
+    created     text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    updated     text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    constraint role_pk
+        primary key (tenant_id, name)
+);
+
+create table user_role_tenant
+(
+    tenant_id text not null default 'default',
+    uuid      text not null,
+    role      text not null,
+    created   text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    constraint user_role_pk
+        primary key (tenant_id, uuid, role),
+    constraint user_role_role_fk
+        foreign key (tenant_id, role)
+            references role_tenant (tenant_id, name)
+            on delete cascade
+);
+
+INSERT INTO role_tenant (name, description, permissions, created, updated)
+SELECT name, description, permissions, created, updated
+FROM role;
+
+-- users of other tenants keep their roles, copied into their tenant
+INSERT INTO role_tenant (tenant_id, name, description, permissions, created, updated)
+SELECT DISTINCT u.tenant_id, r.name, r.description, r.permissions, r.created, r.updated
+FROM user_role ur
+         JOIN user_model u ON u.uuid = ur.uuid
+         JOIN role r ON r.name = ur.role
+WHERE u.tenant_id <> 'default';
+
+INSERT INTO user_role_tenant (tenant_id, uuid, role, created)
+SELECT coalesce(u.tenant_id, 'default'), ur.uuid, ur.role, ur.created
+FROM user_role ur
+         LEFT JOIN user_model u ON u.uuid = ur.uuid;
+
+DROP TABLE user_role;
+DROP TABLE role;
+ALTER TABLE role_tenant
+    RENAME TO role;
+ALTER TABLE user_role_tenant
+    RENAME TO user_role;
+
+-- +migrate Down
+
+create table role_name
+(
+    name        text not null
+        constraint role_pk
+            primary key,
+    description text not null default '',
+    permissions text not null default '[]',
+    created     text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    updated     text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+create table user_role_name
+(
+    uuid    text not null,
+    role    text not null
+        constraint user_role_role_fk
+            references role_name (name)
+            on delete cascade,
+    created text not null default (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    constraint user_role_pk
+        primary key (uuid, role)
+);
+
+INSERT INTO role_name (name, description, permissions, created, updated)
+SELECT name, description, permissions, created, updated
+FROM role
+WHERE tenant_id = 'default';
+INSERT INTO user_role_name (uuid, role, created)
+SELECT uuid, role, created
+FROM user_role
+WHERE tenant_id = 'default';
+
+DROP TABLE user_role;
+DROP TABLE role;
+ALTER TABLE role_name
+    RENAME TO role;
+ALTER TABLE user_role_name
+    RENAME TO user_role;
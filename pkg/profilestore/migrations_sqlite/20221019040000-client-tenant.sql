@@ -0,0 +1,16 @@
+-- noinspection SqlNoDataSourceInspectionForFile
+
+
+-- +migrate Up
+
+ALTER TABLE api_client
+    ADD COLUMN tenant_id text not null default 'default';
+ALTER TABLE oauth_client
+    ADD COLUMN tenant_id text not null default 'default';
+
+-- +migrate Down
+
+ALTER TABLE oauth_client
+    DROP COLUMN tenant_id;
+ALTER TABLE api_client
+    DROP COLUMN tenant_id;
@@ -0,0 +1,83 @@
+package profilestore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func (pg *PG) SaveOAuthClient(ctx context.Context, client *models.OAuthClient) error {
+	query := `
+INSERT INTO oauth_client (id, tenant_id, name, secret_hash, redirect_uris, scopes, grant_types, audiences, created)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+;`
+	return pg.exec(ctx, "SaveOAuthClient", query, client.ID, client.TenantID, client.Name, client.SecretHash,
+		client.RedirectURIs, client.Scopes, client.GrantTypes, client.Audiences, client.Created)
+}
+
+func (pg *PG) GetOAuthClient(ctx context.Context, id string) (*models.OAuthClient, error) {
+	query := `SELECT id, tenant_id, name, secret_hash, redirect_uris, scopes, grant_types, audiences, created
+FROM oauth_client
+WHERE id = $1;`
+	var result models.OAuthClient
+	err := pg.retry(ctx, "GetOAuthClient", func(ctx context.Context, conn *pgxpool.Conn) error {
+		return pgxscan.Get(ctx, conn, &result, query, id)
+	})
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, common.ErrClientNotFound
+	default:
+		return nil, err
+	}
+}
+
+func (pg *PG) ListOAuthClients(ctx context.Context, tenantID string) ([]models.OAuthClient, error) {
+	query := `SELECT id, tenant_id, name, secret_hash, redirect_uris, scopes, grant_types, audiences, created
+FROM oauth_client
+WHERE tenant_id = $1
+ORDER BY created;`
+	var result []models.OAuthClient
+	err := pg.retry(ctx, "ListOAuthClients", func(ctx context.Context, conn *pgxpool.Conn) error {
+		result = nil
+		return pgxscan.Select(ctx, conn, &result, query, tenantID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (pg *PG) SaveAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	query := `
+INSERT INTO authorization_code (hash, client_id, redirect_uri, uuid, scope, code_challenge, nonce, auth_time, expires)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+;`
+	return pg.exec(ctx, "SaveAuthorizationCode", query, code.Hash, code.ClientID, code.RedirectURI, code.UUID,
+		code.Scope, code.CodeChallenge, code.Nonce, code.AuthTime, code.Expires)
+}
+
+// TakeAuthorizationCode deletes the code returning it, so that it can be exchanged once.
+func (pg *PG) TakeAuthorizationCode(ctx context.Context, hash string) (*models.AuthorizationCode, error) {
+	query := `DELETE FROM authorization_code
+WHERE hash = $1
+RETURNING hash, client_id, redirect_uri, uuid, scope, code_challenge, nonce, auth_time, expires;`
+	var result models.AuthorizationCode
+	err := pg.retry(ctx, "TakeAuthorizationCode", func(ctx context.Context, conn *pgxpool.Conn) error {
+		return pgxscan.Get(ctx, conn, &result, query, hash)
+	})
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, common.ErrInvalidGrant
+	default:
+		return nil, err
+	}
+}
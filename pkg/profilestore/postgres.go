@@ -2,7 +2,6 @@ package profilestore
 
 import (
 	"context"
-	"database/sql"
 	"embed"
 	"errors"
 	"fmt"
@@ -15,57 +14,84 @@ import (
 	"github.com/gerladeno/authorization-service/pkg/metrics"
 	"github.com/gerladeno/authorization-service/pkg/models"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jackc/pgx/v4/stdlib"
 	migrate "github.com/rubenv/sql-migrate"
 	"github.com/sirupsen/logrus"
 )
 
+// retryBackoff is the pause before the first retry, doubled for every next one.
+const retryBackoff = 50 * time.Millisecond
+
+// Codes of the errors a statement may succeed after, see retryable.
+const (
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+	pgAdminShutdown        = "57P01"
+	pgCrashShutdown        = "57P02"
+	pgCannotConnectNow     = "57P03"
+)
+
 //go:embed migrations
 var migrations embed.FS
 
 type PG struct {
-	db      *pgx.Conn
-	dsn     string
+	db      *pgxpool.Pool
 	log     *logrus.Entry
 	metrics *metrics.DBClient
 }
 
-func GetPGStore(ctx context.Context, log *logrus.Logger, dsn string) (*PG, error) {
-	config, err := pgx.ParseConfig(dsn)
+// GetPGStore connects a pool of at most maxConns connections, the pool_max_conns of the dsn or pgxpool's
+// default if it's 0. Statements are prepared and cached per connection, add prefer_simple_protocol=true
+// to the dsn for poolers that don't keep them, such as pgbouncer in transaction mode.
+func GetPGStore(ctx context.Context, log *logrus.Logger, dsn string, maxConns int) (*PG, error) {
+	config, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, err
 	}
-	config.PreferSimpleProtocol = true
-	db, err := pgx.ConnectConfig(ctx, config)
+	if maxConns > 0 {
+		config.MaxConns = int32(maxConns)
+	}
+	db, err := pgxpool.ConnectConfig(ctx, config)
 	if err != nil {
 		return nil, err
 	}
 	if err = db.Ping(ctx); err != nil {
+		db.Close()
 		return nil, err
 	}
 	fn := func() float64 {
-		return 1.0
+		return float64(db.Stat().TotalConns())
 	}
+	connConfig := config.ConnConfig
 	return &PG{
-		db:      db,
-		dsn:     dsn,
-		log:     log.WithField("module", "profileStore"),
-		metrics: metrics.NewDBClient(config.Database, config.Host, fmt.Sprintf("%d", config.Port), fn).AutoRegister(),
+		db:  db,
+		log: log.WithField("module", "profileStore"),
+		metrics: metrics.NewDBClient(connConfig.Database, connConfig.Host, fmt.Sprintf("%d", connConfig.Port), fn).
+			AutoRegister(),
 	}, nil
 }
 
+func (pg *PG) Close() {
+	pg.db.Close()
+}
+
 func (pg *PG) Migrate(direction migrate.MigrationDirection) error {
-	conn, err := sql.Open("pgx", pg.dsn)
-	if err != nil {
-		return err
-	}
+	conn := stdlib.OpenDB(*pg.db.Config().ConnConfig)
 	defer func() {
-		if err = conn.Close(); err != nil {
+		if err := conn.Close(); err != nil {
 			pg.log.Error("err closing migration connection")
 		}
 	}()
+	_, err := migrate.Exec(conn, "postgres", migrationSource(migrations, "migrations"), direction)
+	return err
+}
+
+// migrationSource reads the migrations from the directory of the embedded files.
+func migrationSource(files embed.FS, dir string) *migrate.AssetMigrationSource {
 	assetDir := func() func(string) ([]string, error) {
 		return func(path string) ([]string, error) {
-			dirEntry, er := migrations.ReadDir(path)
+			dirEntry, er := files.ReadDir(path)
 			if er != nil {
 				return nil, er
 			}
@@ -77,68 +103,173 @@ func (pg *PG) Migrate(direction migrate.MigrationDirection) error {
 			return entries, nil
 		}
 	}()
-	asset := migrate.AssetMigrationSource{
-		Asset:    migrations.ReadFile,
+	return &migrate.AssetMigrationSource{
+		Asset:    files.ReadFile,
 		AssetDir: assetDir,
-		Dir:      "migrations",
+		Dir:      dir,
+	}
+}
+
+// retry runs fn on a connection of the pool, accounting it under the name. Attempts that couldn't get
+// a connection or failed with a retryable error are repeated after a pause growing with every attempt,
+// unless ctx is done. pgx.ErrNoRows is an answer rather than a failure and is returned as is.
+func (pg *PG) retry(ctx context.Context, name string, fn func(ctx context.Context, conn *pgxpool.Conn) error) error {
+	return pg.attempt(ctx, name, common.GlobalRequestRetries, fn)
+}
+
+// once is retry making a single attempt, for statements that would be applied twice if repeated after
+// the connection failed with them applied, as incrementing a counter.
+func (pg *PG) once(ctx context.Context, name string, fn func(ctx context.Context, conn *pgxpool.Conn) error) error {
+	return pg.attempt(ctx, name, 1, fn)
+}
+
+func (pg *PG) attempt(
+	ctx context.Context,
+	name string,
+	attempts int,
+	fn func(ctx context.Context, conn *pgxpool.Conn) error,
+) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			timer := time.NewTimer(retryBackoff << (i - 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return pg.failed(name, err)
+			case <-timer.C:
+			}
+		}
+		started := time.Now()
+		var conn *pgxpool.Conn
+		conn, err = pg.db.Acquire(ctx)
+		acquired := err == nil
+		if acquired {
+			err = fn(ctx, conn)
+			conn.Release()
+		}
+		if err == nil || errors.Is(err, pgx.ErrNoRows) {
+			pg.metrics.TimeTotal.WithLabelValues(name).Add(time.Since(started).Seconds())
+			return err
+		}
+		pg.metrics.ErrsTotal.WithLabelValues(name).Inc()
+		if ctx.Err() != nil || (acquired && !retryable(err)) {
+			break
+		}
 	}
-	_, err = migrate.Exec(conn, "postgres", asset, direction)
+	return pg.failed(name, err)
+}
+
+func (pg *PG) failed(name string, err error) error {
+	err = fmt.Errorf("err executing %s in pg: %w", name, err)
+	pg.log.Debug(err)
 	return err
 }
 
-func (pg *PG) GetUser(ctx context.Context, phone string) (*models.User, error) {
-	query := fmt.Sprintf(`SELECT uuid, phone, created, updated
+// retryable tells the errors of statements that are safe to run again: those that never reached the server,
+// serialization failures, deadlocks and the server going down.
+func retryable(err error) bool {
+	if pgconn.SafeToRetry(err) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.Code {
+	case pgSerializationFailure, pgDeadlockDetected, pgAdminShutdown, pgCrashShutdown, pgCannotConnectNow:
+		return true
+	default:
+		return false
+	}
+}
+
+func (pg *PG) GetUser(ctx context.Context, tenantID, phone string) (*models.User, error) {
+	query := `SELECT uuid, tenant_id, phone, name, email, avatar_url, locale, time_zone, metadata, created, updated
 FROM user_model
-WHERE phone = '%s';`, phone)
-	var started time.Time
-	var err error
+WHERE tenant_id = $1
+  AND phone = $2;`
 	var result models.User
-	for i := 0; i < common.GlobalRequestRetries; i++ {
-		started = time.Now()
-		err = pgxscan.Get(ctx, pg.db, &result, query)
-		switch {
-		case err == nil:
-		case errors.Is(err, pgx.ErrNoRows):
-			return nil, common.ErrPhoneNotFound
-		default:
-			pg.metrics.ErrsTotal.WithLabelValues("GetUser").Inc()
-			continue
-		}
-		pg.metrics.TimeTotal.WithLabelValues("GetUser").Add(time.Since(started).Seconds())
+	err := pg.retry(ctx, "GetUser", func(ctx context.Context, conn *pgxpool.Conn) error {
+		return pgxscan.Get(ctx, conn, &result, query, tenantID, phone)
+	})
+	switch {
+	case err == nil:
 		return &result, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, common.ErrPhoneNotFound
+	default:
+		return nil, err
+	}
+}
+
+// GetUserByUUIDUncached is GetUserByUUID, the store doesn't cache. CachedStore reads it past the cache.
+func (pg *PG) GetUserByUUIDUncached(ctx context.Context, uuid string) (*models.User, error) {
+	return pg.GetUserByUUID(ctx, uuid)
+}
+
+func (pg *PG) GetUserByUUID(ctx context.Context, uuid string) (*models.User, error) {
+	query := `SELECT uuid, tenant_id, phone, name, email, avatar_url, locale, time_zone, metadata, created, updated
+FROM user_model
+WHERE uuid = $1;`
+	var result models.User
+	err := pg.retry(ctx, "GetUserByUUID", func(ctx context.Context, conn *pgxpool.Conn) error {
+		return pgxscan.Get(ctx, conn, &result, query, uuid)
+	})
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, common.ErrUserNotFound
+	default:
+		return nil, err
 	}
-	pg.log.Debugf("err selecting from pg: %s", err)
-	return nil, err
 }
 
 func (pg *PG) UpsertUser(ctx context.Context, user *models.User) error {
 	query := `
-INSERT INTO user_model (uuid, phone, created, updated)
-VALUES ($1, $2, $3, $4)
-ON CONFLICT (phone) DO UPDATE SET phone     = excluded.phone,
-                                 updated    = NOW()
+INSERT INTO user_model (uuid, tenant_id, phone, created, updated)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (tenant_id, phone) DO UPDATE SET phone   = excluded.phone,
+                                             updated = NOW()
 ;`
-	var started time.Time
-	var err error
-	var result pgconn.CommandTag
-	for i := 0; i < common.GlobalRequestRetries; i++ {
-		started = time.Now()
-		result, err = pg.db.Exec(
-			ctx, query, user.UUID, user.Phone,
-			time.Now().UTC().Format(common.PGDatetimeFmt), time.Now().UTC().Format(common.PGDatetimeFmt))
-		if err != nil {
-			pg.metrics.ErrsTotal.WithLabelValues("UpsertUser").Inc()
-			continue
-		}
-		if result.RowsAffected() == 0 {
-			err = errors.New("err user not upserted")
-			pg.metrics.ErrsTotal.WithLabelValues("UpsertUser").Inc()
-			continue
+	now := time.Now().UTC().Format(common.PGDatetimeFmt)
+	affected, err := pg.execAffected(ctx, "UpsertUser", query, user.UUID, user.TenantID, user.Phone, now, now)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errors.New("err user not upserted")
+	}
+	return nil
+}
+
+// UpdateProfile saves the profile fields of the user unless it was updated after ifUpdated, the time it was read.
+// The user gets the new update time.
+func (pg *PG) UpdateProfile(ctx context.Context, user *models.User, ifUpdated time.Time) error {
+	query := `
+UPDATE user_model
+SET name       = $3,
+    email      = $4,
+    avatar_url = $5,
+    locale     = $6,
+    time_zone  = $7,
+    metadata   = $8,
+    updated    = $9
+WHERE uuid = $1
+  AND updated = $2;`
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	affected, err := pg.execAffected(ctx, "UpdateProfile", query, user.UUID, ifUpdated, user.Name, user.Email,
+		user.AvatarURL, user.Locale, user.TimeZone, string(profileMetadata(user.Metadata)), now)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		if _, err = pg.GetUserByUUID(ctx, user.UUID); err != nil {
+			return err
 		}
-		pg.metrics.TimeTotal.WithLabelValues("UpsertUser").Add(time.Since(started).Seconds())
-		return nil
+		return common.ErrUserModified
 	}
-	err = fmt.Errorf("err inserting to pg: %w", err)
-	pg.log.Debug(err)
-	return err
+	user.Updated = now
+	return nil
 }
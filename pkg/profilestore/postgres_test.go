@@ -0,0 +1,38 @@
+package profilestore_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/gerladeno/authorization-service/pkg/profilestore"
+	"github.com/gerladeno/authorization-service/pkg/profilestore/storetest"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/sirupsen/logrus"
+)
+
+// TestPGStore runs the suite against the database of PG_TEST_DSN, migrated up first. The suite names its
+// records uniquely, so the database may be shared with other runs.
+func TestPGStore(t *testing.T) {
+	dsn := os.Getenv("PG_TEST_DSN")
+	if dsn == "" {
+		t.Skip("PG_TEST_DSN is not set")
+	}
+	log := logrus.New()
+	pg, err := profilestore.GetPGStore(context.Background(), log, dsn, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pg.Migrate(migrate.Up)
+	pg.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.Run(t, func(t *testing.T) profilestore.Store {
+		store, err := profilestore.GetPGStore(context.Background(), log, dsn, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
@@ -0,0 +1,56 @@
+package profilestore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func (pg *PG) SaveRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	query := `
+INSERT INTO refresh_token (hash, family_id, uuid, tenant_id, client_id, scope, created, expires)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+;`
+	return pg.exec(ctx, "SaveRefreshToken", query, token.Hash, token.FamilyID, token.UUID, token.TenantID,
+		token.ClientID, token.Scope, token.Created, token.Expires)
+}
+
+func (pg *PG) GetRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	query := `SELECT hash, family_id, uuid, tenant_id, client_id, scope, used, revoked, created, expires
+FROM refresh_token
+WHERE hash = $1;`
+	var result models.RefreshToken
+	err := pg.retry(ctx, "GetRefreshToken", func(ctx context.Context, conn *pgxpool.Conn) error {
+		return pgxscan.Get(ctx, conn, &result, query, hash)
+	})
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, common.ErrInvalidRefreshToken
+	default:
+		return nil, err
+	}
+}
+
+func (pg *PG) UseRefreshToken(ctx context.Context, hash string) error {
+	query := `UPDATE refresh_token SET used = true WHERE hash = $1 AND NOT used;`
+	affected, err := pg.execAffected(ctx, "UseRefreshToken", query, hash)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrRefreshTokenReused
+	}
+	return nil
+}
+
+func (pg *PG) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	query := `UPDATE refresh_token SET revoked = true WHERE family_id = $1;`
+	return pg.exec(ctx, "RevokeTokenFamily", query, familyID)
+}
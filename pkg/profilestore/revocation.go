@@ -0,0 +1,103 @@
+package profilestore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func (pg *PG) RevokeToken(ctx context.Context, jti string, expires time.Time) error {
+	query := `
+INSERT INTO revoked_token (jti, expires, revoked_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (jti) DO NOTHING
+;`
+	return pg.exec(ctx, "RevokeToken", query, jti, expires)
+}
+
+func (pg *PG) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	query := `SELECT jti FROM revoked_token WHERE jti = $1;`
+	err := pg.retry(ctx, "IsTokenRevoked", func(ctx context.Context, conn *pgxpool.Conn) error {
+		var found string
+		return conn.QueryRow(ctx, query, jti).Scan(&found)
+	})
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (pg *PG) RevokedTokensSince(ctx context.Context, since time.Time) ([]string, error) {
+	query := `SELECT jti FROM revoked_token WHERE revoked_at > $1 AND expires > NOW();`
+	var result []string
+	err := pg.retry(ctx, "RevokedTokensSince", func(ctx context.Context, conn *pgxpool.Conn) error {
+		result = nil
+		return pgxscan.Select(ctx, conn, &result, query, since)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteRevokedTokens forgets the revocations of tokens expired before the time, they are refused anyway.
+func (pg *PG) DeleteRevokedTokens(ctx context.Context, expiredBefore time.Time) error {
+	query := `DELETE FROM revoked_token WHERE expires < $1;`
+	return pg.exec(ctx, "DeleteRevokedTokens", query, expiredBefore)
+}
+
+func (pg *PG) RevokeUser(ctx context.Context, uuid string, at time.Time) error {
+	query := `
+INSERT INTO revoked_user (uuid, revoked_at)
+VALUES ($1, $2)
+ON CONFLICT (uuid) DO UPDATE SET revoked_at = excluded.revoked_at
+;`
+	return pg.exec(ctx, "RevokeUser", query, uuid, at)
+}
+
+func (pg *PG) UsersRevokedSince(ctx context.Context, since time.Time) ([]models.RevokedUser, error) {
+	query := `SELECT uuid, revoked_at FROM revoked_user WHERE revoked_at > $1;`
+	var result []models.RevokedUser
+	err := pg.retry(ctx, "UsersRevokedSince", func(ctx context.Context, conn *pgxpool.Conn) error {
+		result = nil
+		return pgxscan.Select(ctx, conn, &result, query, since)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (pg *PG) RevokeUserRefreshTokens(ctx context.Context, uuid string) error {
+	query := `UPDATE refresh_token SET revoked = true WHERE uuid = $1 AND NOT revoked;`
+	return pg.exec(ctx, "RevokeUserRefreshTokens", query, uuid)
+}
+
+// exec runs a statement with retries, accounting it under the name.
+func (pg *PG) exec(ctx context.Context, name, query string, args ...interface{}) error {
+	_, err := pg.execAffected(ctx, name, query, args...)
+	return err
+}
+
+// execAffected is exec returning the number of rows affected.
+func (pg *PG) execAffected(ctx context.Context, name, query string, args ...interface{}) (int64, error) {
+	var affected int64
+	err := pg.retry(ctx, name, func(ctx context.Context, conn *pgxpool.Conn) error {
+		result, err := conn.Exec(ctx, query, args...)
+		affected = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return affected, nil
+}
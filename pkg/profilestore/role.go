@@ -0,0 +1,99 @@
+package profilestore
+
+import (
+	"context"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// SaveRole creates the role of its tenant or replaces the description and permissions of the existing one.
+func (pg *PG) SaveRole(ctx context.Context, role *models.Role) error {
+	query := `
+INSERT INTO role (tenant_id, name, description, permissions, created, updated)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (tenant_id, name) DO UPDATE SET description = excluded.description,
+                                            permissions = excluded.permissions,
+                                            updated     = excluded.updated
+;`
+	return pg.exec(ctx, "SaveRole", query, role.TenantID, role.Name, role.Description, role.Permissions, role.Created,
+		role.Updated)
+}
+
+func (pg *PG) ListRoles(ctx context.Context, tenantID string) ([]models.Role, error) {
+	query := `SELECT tenant_id, name, description, permissions, created, updated
+FROM role
+WHERE tenant_id = $1
+ORDER BY name;`
+	return pg.selectRoles(ctx, "ListRoles", query, tenantID)
+}
+
+// DeleteRole removes the role along with its assignments.
+func (pg *PG) DeleteRole(ctx context.Context, tenantID, name string) error {
+	query := `DELETE FROM role WHERE tenant_id = $1 AND name = $2;`
+	affected, err := pg.execAffected(ctx, "DeleteRole", query, tenantID, name)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrRoleNotFound
+	}
+	return nil
+}
+
+func (pg *PG) GetUserRoles(ctx context.Context, tenantID, uuid string) ([]models.Role, error) {
+	query := `SELECT r.tenant_id, r.name, r.description, r.permissions, r.created, r.updated
+FROM role r
+         JOIN user_role ur ON ur.tenant_id = r.tenant_id AND ur.role = r.name
+WHERE ur.tenant_id = $1
+  AND ur.uuid = $2
+ORDER BY r.name;`
+	return pg.selectRoles(ctx, "GetUserRoles", query, tenantID, uuid)
+}
+
+// AssignRole gives the role of the tenant to the user, common.ErrRoleNotFound is returned if there is no such
+// role. Assigning a role the user has already is not an error.
+func (pg *PG) AssignRole(ctx context.Context, tenantID, uuid, role string) error {
+	// the no-op update makes a repeated assignment count as affected
+	query := `INSERT INTO user_role (tenant_id, uuid, role)
+SELECT tenant_id, $2, name
+FROM role
+WHERE tenant_id = $1
+  AND name = $3
+ON CONFLICT (tenant_id, uuid, role) DO UPDATE SET role = excluded.role;`
+	affected, err := pg.execAffected(ctx, "AssignRole", query, tenantID, uuid, role)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrRoleNotFound
+	}
+	return nil
+}
+
+// UnassignRole takes the role from the user, common.ErrRoleNotFound is returned if the user doesn't have it.
+func (pg *PG) UnassignRole(ctx context.Context, tenantID, uuid, role string) error {
+	query := `DELETE FROM user_role WHERE tenant_id = $1 AND uuid = $2 AND role = $3;`
+	affected, err := pg.execAffected(ctx, "UnassignRole", query, tenantID, uuid, role)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrRoleNotFound
+	}
+	return nil
+}
+
+func (pg *PG) selectRoles(ctx context.Context, name, query string, args ...interface{}) ([]models.Role, error) {
+	var result []models.Role
+	err := pg.retry(ctx, name, func(ctx context.Context, conn *pgxpool.Conn) error {
+		result = nil
+		return pgxscan.Select(ctx, conn, &result, query, args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
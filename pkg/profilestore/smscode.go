@@ -0,0 +1,43 @@
+package profilestore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func (pg *PG) SaveCode(ctx context.Context, phone, codeHash string, expires time.Time) error {
+	query := `
+INSERT INTO sms_code (phone, code_hash, expires)
+VALUES ($1, $2, $3)
+ON CONFLICT (phone) DO UPDATE SET code_hash = excluded.code_hash,
+                                  expires   = excluded.expires
+;`
+	return pg.exec(ctx, "SaveCode", query, phone, codeHash, expires)
+}
+
+func (pg *PG) GetCode(ctx context.Context, phone string) (string, time.Time, error) {
+	query := `SELECT code_hash, expires FROM sms_code WHERE phone = $1;`
+	var codeHash string
+	var expires time.Time
+	err := pg.retry(ctx, "GetCode", func(ctx context.Context, conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, query, phone).Scan(&codeHash, &expires)
+	})
+	switch {
+	case err == nil:
+		return codeHash, expires, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return "", time.Time{}, common.ErrChallengeNotFound
+	default:
+		return "", time.Time{}, err
+	}
+}
+
+func (pg *PG) DeleteCode(ctx context.Context, phone string) error {
+	query := `DELETE FROM sms_code WHERE phone = $1;`
+	return pg.exec(ctx, "DeleteCode", query, phone)
+}
@@ -0,0 +1,422 @@
+package profilestore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/metrics"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite" // registers the sqlite driver
+)
+
+// SQLiteScheme starts the dsn of SQLite databases, followed by the path of the file.
+const SQLiteScheme = "sqlite://"
+
+// sqliteTimeFmt keeps times in UTC to the microsecond as postgres does, of the same length, so that they
+// compare as text.
+const sqliteTimeFmt = "2006-01-02T15:04:05.000000Z"
+
+// sqlitePragmas are set on every connection: foreign keys are off by default, waiting for locks instead
+// of failing on them and letting readers go along with the writer.
+const sqlitePragmas = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+//go:embed migrations_sqlite
+var sqliteMigrations embed.FS
+
+// SQLite is the store of single node installs, kept in one file.
+type SQLite struct {
+	db      *sql.DB
+	log     *logrus.Entry
+	metrics *metrics.DBClient
+}
+
+// GetSQLiteStore opens the database at path, creating the file if there is none. Everything goes through
+// a single connection, SQLite allows a single writer anyway.
+func GetSQLiteStore(ctx context.Context, log *logrus.Logger, path string) (*SQLite, error) {
+	dsn := "file:" + path
+	if strings.Contains(path, "?") {
+		dsn += "&" + sqlitePragmas
+	} else {
+		dsn += "?" + sqlitePragmas
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if err = db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	fn := func() float64 {
+		return float64(db.Stats().OpenConnections)
+	}
+	return &SQLite{
+		db:      db,
+		log:     log.WithField("module", "profileStore"),
+		metrics: metrics.NewDBClient(path, "localhost", "", fn).AutoRegister(),
+	}, nil
+}
+
+func (s *SQLite) Close() {
+	if err := s.db.Close(); err != nil {
+		s.log.Warnf("err closing sqlite: %v", err)
+	}
+}
+
+func (s *SQLite) Migrate(direction migrate.MigrationDirection) error {
+	_, err := migrate.Exec(s.db, "sqlite3", migrationSource(sqliteMigrations, "migrations_sqlite"), direction)
+	return err
+}
+
+// run runs fn accounting it under the name. There is nothing to retry, locks are waited for by SQLite.
+// sql.ErrNoRows is an answer rather than a failure and is returned as is.
+func (s *SQLite) run(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	started := time.Now()
+	err := fn(ctx)
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		s.metrics.TimeTotal.WithLabelValues(name).Add(time.Since(started).Seconds())
+		return err
+	}
+	s.metrics.ErrsTotal.WithLabelValues(name).Inc()
+	err = fmt.Errorf("err executing %s in sqlite: %w", name, err)
+	s.log.Debug(err)
+	return err
+}
+
+func (s *SQLite) exec(ctx context.Context, name, query string, args ...interface{}) error {
+	_, err := s.execAffected(ctx, name, query, args...)
+	return err
+}
+
+func (s *SQLite) execAffected(ctx context.Context, name, query string, args ...interface{}) (int64, error) {
+	var affected int64
+	err := s.run(ctx, name, func(ctx context.Context) error {
+		result, err := s.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		affected, err = result.RowsAffected()
+		return err
+	})
+	return affected, err
+}
+
+// get scans the single row of the query, sql.ErrNoRows if there is none.
+func (s *SQLite) get(ctx context.Context, name, query string, args []interface{}, dest ...interface{}) error {
+	return s.run(ctx, name, func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx, query, args...).Scan(dest...)
+	})
+}
+
+// query calls scan for every row of the query.
+func (s *SQLite) query(ctx context.Context, name, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	return s.run(ctx, name, func(ctx context.Context) error {
+		rows, err := s.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err = scan(rows); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// timeText is a time kept as text in sqliteTimeFmt.
+type timeText time.Time
+
+func (t timeText) Value() (driver.Value, error) {
+	return time.Time(t).UTC().Format(sqliteTimeFmt), nil
+}
+
+func (t *timeText) Scan(src interface{}) error {
+	s, ok := src.(string)
+	if !ok {
+		return fmt.Errorf("err scanning time from %T", src)
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	*t = timeText(parsed)
+	return nil
+}
+
+// nullTimeText is a time kept as text that may be null.
+type nullTimeText struct {
+	t **time.Time
+}
+
+func (t nullTimeText) Scan(src interface{}) error {
+	if src == nil {
+		*t.t = nil
+		return nil
+	}
+	var parsed timeText
+	if err := parsed.Scan(src); err != nil {
+		return err
+	}
+	result := time.Time(parsed)
+	*t.t = &result
+	return nil
+}
+
+// listText is a list kept as a json array.
+type listText []string
+
+func (l listText) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+func (l *listText) Scan(src interface{}) error {
+	s, ok := src.(string)
+	if !ok {
+		return fmt.Errorf("err scanning list from %T", src)
+	}
+	return json.Unmarshal([]byte(s), (*[]string)(l))
+}
+
+func (s *SQLite) GetUser(ctx context.Context, tenantID, phone string) (*models.User, error) {
+	query := `SELECT uuid, tenant_id, phone, name, email, avatar_url, locale, time_zone, metadata, created, updated
+FROM user_model
+WHERE tenant_id = ?
+  AND phone = ?;`
+	var result models.User
+	err := s.get(ctx, "GetUser", query, []interface{}{tenantID, phone}, userColumns(&result)...)
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, common.ErrPhoneNotFound
+	default:
+		return nil, err
+	}
+}
+
+// GetUserByUUIDUncached is GetUserByUUID, the store doesn't cache. CachedStore reads it past the cache.
+func (s *SQLite) GetUserByUUIDUncached(ctx context.Context, uuid string) (*models.User, error) {
+	return s.GetUserByUUID(ctx, uuid)
+}
+
+func (s *SQLite) GetUserByUUID(ctx context.Context, uuid string) (*models.User, error) {
+	query := `SELECT uuid, tenant_id, phone, name, email, avatar_url, locale, time_zone, metadata, created, updated
+FROM user_model
+WHERE uuid = ?;`
+	var result models.User
+	err := s.get(ctx, "GetUserByUUID", query, []interface{}{uuid}, userColumns(&result)...)
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, common.ErrUserNotFound
+	default:
+		return nil, err
+	}
+}
+
+func (s *SQLite) UpsertUser(ctx context.Context, user *models.User) error {
+	query := `
+INSERT INTO user_model (uuid, tenant_id, phone, created, updated)
+VALUES (?1, ?2, ?3, ?4, ?4)
+ON CONFLICT (tenant_id, phone) DO UPDATE SET phone   = excluded.phone,
+                                             updated = excluded.updated
+;`
+	affected, err := s.execAffected(ctx, "UpsertUser", query, user.UUID, user.TenantID, user.Phone, timeText(time.Now()))
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errors.New("err user not upserted")
+	}
+	return nil
+}
+
+func userColumns(user *models.User) []interface{} {
+	return []interface{}{&user.UUID, &user.TenantID, &user.Phone, &user.Name, &user.Email, &user.AvatarURL, &user.Locale,
+		&user.TimeZone, (*[]byte)(&user.Metadata), (*timeText)(&user.Created), (*timeText)(&user.Updated)}
+}
+
+// UpdateProfile saves the profile fields of the user unless it was updated after ifUpdated, the time it was read.
+// The user gets the new update time.
+func (s *SQLite) UpdateProfile(ctx context.Context, user *models.User, ifUpdated time.Time) error {
+	query := `
+UPDATE user_model
+SET name       = ?3,
+    email      = ?4,
+    avatar_url = ?5,
+    locale     = ?6,
+    time_zone  = ?7,
+    metadata   = ?8,
+    updated    = ?9
+WHERE uuid = ?1
+  AND updated = ?2;`
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	affected, err := s.execAffected(ctx, "UpdateProfile", query, user.UUID, timeText(ifUpdated), user.Name, user.Email,
+		user.AvatarURL, user.Locale, user.TimeZone, string(profileMetadata(user.Metadata)), timeText(now))
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		if _, err = s.GetUserByUUID(ctx, user.UUID); err != nil {
+			return err
+		}
+		return common.ErrUserModified
+	}
+	user.Updated = now
+	return nil
+}
+
+// SaveTenant creates the tenant or replaces the name, hosts and issuer of the existing one.
+func (s *SQLite) SaveTenant(ctx context.Context, tenant *models.Tenant) error {
+	query := `
+INSERT INTO tenant (id, name, hosts, issuer, created, updated)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET name    = excluded.name,
+                               hosts   = excluded.hosts,
+                               issuer  = excluded.issuer,
+                               updated = excluded.updated
+;`
+	return s.exec(ctx, "SaveTenant", query, tenant.ID, tenant.Name, listText(tenant.Hosts), tenant.Issuer,
+		timeText(tenant.Created), timeText(tenant.Updated))
+}
+
+func (s *SQLite) ListTenants(ctx context.Context) ([]models.Tenant, error) {
+	query := `SELECT id, name, hosts, issuer, created, updated
+FROM tenant
+ORDER BY id;`
+	var result []models.Tenant
+	err := s.query(ctx, "ListTenants", query, nil, func(rows *sql.Rows) error {
+		var tenant models.Tenant
+		if err := rows.Scan(&tenant.ID, &tenant.Name, (*listText)(&tenant.Hosts), &tenant.Issuer,
+			(*timeText)(&tenant.Created), (*timeText)(&tenant.Updated)); err != nil {
+			return err
+		}
+		result = append(result, tenant)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SaveRole creates the role of its tenant or replaces the description and permissions of the existing one.
+func (s *SQLite) SaveRole(ctx context.Context, role *models.Role) error {
+	query := `
+INSERT INTO role (tenant_id, name, description, permissions, created, updated)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (tenant_id, name) DO UPDATE SET description = excluded.description,
+                                            permissions = excluded.permissions,
+                                            updated     = excluded.updated
+;`
+	return s.exec(ctx, "SaveRole", query, role.TenantID, role.Name, role.Description, listText(role.Permissions),
+		timeText(role.Created), timeText(role.Updated))
+}
+
+func (s *SQLite) ListRoles(ctx context.Context, tenantID string) ([]models.Role, error) {
+	query := `SELECT tenant_id, name, description, permissions, created, updated
+FROM role
+WHERE tenant_id = ?
+ORDER BY name;`
+	return s.selectRoles(ctx, "ListRoles", query, tenantID)
+}
+
+// DeleteRole removes the role along with its assignments.
+func (s *SQLite) DeleteRole(ctx context.Context, tenantID, name string) error {
+	query := `DELETE FROM role WHERE tenant_id = ? AND name = ?;`
+	affected, err := s.execAffected(ctx, "DeleteRole", query, tenantID, name)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrRoleNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) GetUserRoles(ctx context.Context, tenantID, uuid string) ([]models.Role, error) {
+	query := `SELECT r.tenant_id, r.name, r.description, r.permissions, r.created, r.updated
+FROM role r
+         JOIN user_role ur ON ur.tenant_id = r.tenant_id AND ur.role = r.name
+WHERE ur.tenant_id = ?
+  AND ur.uuid = ?
+ORDER BY r.name;`
+	return s.selectRoles(ctx, "GetUserRoles", query, tenantID, uuid)
+}
+
+// AssignRole gives the role of the tenant to the user, common.ErrRoleNotFound is returned if there is no such
+// role. Assigning a role the user has already is not an error.
+func (s *SQLite) AssignRole(ctx context.Context, tenantID, uuid, role string) error {
+	// the no-op update makes a repeated assignment count as affected
+	query := `INSERT INTO user_role (tenant_id, uuid, role)
+SELECT tenant_id, ?, name
+FROM role
+WHERE tenant_id = ?
+  AND name = ?
+ON CONFLICT (tenant_id, uuid, role) DO UPDATE SET role = excluded.role;`
+	affected, err := s.execAffected(ctx, "AssignRole", query, uuid, tenantID, role)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrRoleNotFound
+	}
+	return nil
+}
+
+// UnassignRole takes the role from the user, common.ErrRoleNotFound is returned if the user doesn't have it.
+func (s *SQLite) UnassignRole(ctx context.Context, tenantID, uuid, role string) error {
+	query := `DELETE FROM user_role WHERE tenant_id = ? AND uuid = ? AND role = ?;`
+	affected, err := s.execAffected(ctx, "UnassignRole", query, tenantID, uuid, role)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrRoleNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) selectRoles(ctx context.Context, name, query string, args ...interface{}) ([]models.Role, error) {
+	var result []models.Role
+	err := s.query(ctx, name, query, args, func(rows *sql.Rows) error {
+		var role models.Role
+		if err := rows.Scan(&role.TenantID, &role.Name, &role.Description, (*listText)(&role.Permissions),
+			(*timeText)(&role.Created), (*timeText)(&role.Updated)); err != nil {
+			return err
+		}
+		result = append(result, role)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *SQLite) SaveAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	query := `
+INSERT INTO audit_log (id, action, actor, subject, client_id, details, created)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+;`
+	return s.exec(ctx, "SaveAuditEntry", query, entry.ID, entry.Action, entry.Actor, entry.Subject, entry.ClientID,
+		entry.Details, timeText(entry.Created))
+}
@@ -0,0 +1,46 @@
+package profilestore_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/gerladeno/authorization-service/pkg/profilestore"
+	"github.com/gerladeno/authorization-service/pkg/profilestore/storetest"
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) profilestore.Store {
+		store, err := profilestore.GetSQLiteStore(context.Background(), logrus.New(),
+			filepath.Join(t.TempDir(), "auth.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = store.Migrate(migrate.Up); err != nil {
+			store.Close()
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+// TestSQLiteMigrations checks that every migration of the file can be rolled back.
+func TestSQLiteMigrations(t *testing.T) {
+	store, err := profilestore.GetSQLiteStore(context.Background(), logrus.New(),
+		filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err = store.Migrate(migrate.Up); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Migrate(migrate.Down); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Migrate(migrate.Up); err != nil {
+		t.Fatal(err)
+	}
+}
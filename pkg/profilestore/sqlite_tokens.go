@@ -0,0 +1,538 @@
+package profilestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+func (s *SQLite) GetChallenge(ctx context.Context, phone string) (*models.Challenge, error) {
+	query := `SELECT phone, channel, attempts, created, expires
+FROM verification_challenge
+WHERE phone = ?;`
+	var result models.Challenge
+	err := s.get(ctx, "GetChallenge", query, []interface{}{phone}, &result.Phone, &result.Channel, &result.Attempts,
+		(*timeText)(&result.Created), (*timeText)(&result.Expires))
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, common.ErrChallengeNotFound
+	default:
+		return nil, err
+	}
+}
+
+func (s *SQLite) SaveChallenge(ctx context.Context, challenge *models.Challenge) error {
+	query := `
+INSERT INTO verification_challenge (phone, channel, attempts, created, expires)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (phone) DO UPDATE SET channel  = excluded.channel,
+                                  attempts = excluded.attempts,
+                                  created  = excluded.created,
+                                  expires  = excluded.expires
+;`
+	return s.exec(ctx, "SaveChallenge", query, challenge.Phone, challenge.Channel, challenge.Attempts,
+		timeText(challenge.Created), timeText(challenge.Expires))
+}
+
+// ClaimChallenge saves the challenge unless the phone has one created after createdBefore,
+// common.ErrResendTooSoon then.
+func (s *SQLite) ClaimChallenge(ctx context.Context, challenge *models.Challenge, createdBefore time.Time) error {
+	query := `
+INSERT INTO verification_challenge (phone, channel, attempts, created, expires)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (phone) DO UPDATE SET channel  = excluded.channel,
+                                  attempts = excluded.attempts,
+                                  created  = excluded.created,
+                                  expires  = excluded.expires
+WHERE verification_challenge.created <= ?
+;`
+	affected, err := s.execAffected(ctx, "ClaimChallenge", query, challenge.Phone, challenge.Channel,
+		challenge.Attempts, timeText(challenge.Created), timeText(challenge.Expires), timeText(createdBefore))
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrResendTooSoon
+	}
+	return nil
+}
+
+func (s *SQLite) IncrementAttempts(ctx context.Context, phone string) (int, error) {
+	query := `
+UPDATE verification_challenge
+SET attempts = attempts + 1
+WHERE phone = ?
+RETURNING attempts;`
+	var attempts int
+	err := s.get(ctx, "IncrementAttempts", query, []interface{}{phone}, &attempts)
+	switch {
+	case err == nil:
+		return attempts, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return 0, common.ErrChallengeNotFound
+	default:
+		return 0, err
+	}
+}
+
+func (s *SQLite) DeleteChallenge(ctx context.Context, phone string) error {
+	query := `DELETE FROM verification_challenge WHERE phone = ?;`
+	affected, err := s.execAffected(ctx, "DeleteChallenge", query, phone)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrChallengeNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) SaveCode(ctx context.Context, phone, codeHash string, expires time.Time) error {
+	query := `
+INSERT INTO sms_code (phone, code_hash, expires)
+VALUES (?, ?, ?)
+ON CONFLICT (phone) DO UPDATE SET code_hash = excluded.code_hash,
+                                  expires   = excluded.expires
+;`
+	return s.exec(ctx, "SaveCode", query, phone, codeHash, timeText(expires))
+}
+
+func (s *SQLite) GetCode(ctx context.Context, phone string) (string, time.Time, error) {
+	query := `SELECT code_hash, expires FROM sms_code WHERE phone = ?;`
+	var codeHash string
+	var expires time.Time
+	err := s.get(ctx, "GetCode", query, []interface{}{phone}, &codeHash, (*timeText)(&expires))
+	switch {
+	case err == nil:
+		return codeHash, expires, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return "", time.Time{}, common.ErrChallengeNotFound
+	default:
+		return "", time.Time{}, err
+	}
+}
+
+func (s *SQLite) DeleteCode(ctx context.Context, phone string) error {
+	query := `DELETE FROM sms_code WHERE phone = ?;`
+	return s.exec(ctx, "DeleteCode", query, phone)
+}
+
+func (s *SQLite) SaveRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	query := `
+INSERT INTO refresh_token (hash, family_id, uuid, tenant_id, client_id, scope, created, expires)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+;`
+	return s.exec(ctx, "SaveRefreshToken", query, token.Hash, token.FamilyID, token.UUID, token.TenantID,
+		token.ClientID, token.Scope, timeText(token.Created), timeText(token.Expires))
+}
+
+func (s *SQLite) GetRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	query := `SELECT hash, family_id, uuid, tenant_id, client_id, scope, used, revoked, created, expires
+FROM refresh_token
+WHERE hash = ?;`
+	var result models.RefreshToken
+	err := s.get(ctx, "GetRefreshToken", query, []interface{}{hash}, &result.Hash, &result.FamilyID, &result.UUID,
+		&result.TenantID, &result.ClientID, &result.Scope, &result.Used, &result.Revoked,
+		(*timeText)(&result.Created), (*timeText)(&result.Expires))
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, common.ErrInvalidRefreshToken
+	default:
+		return nil, err
+	}
+}
+
+func (s *SQLite) UseRefreshToken(ctx context.Context, hash string) error {
+	query := `UPDATE refresh_token SET used = true WHERE hash = ? AND NOT used;`
+	affected, err := s.execAffected(ctx, "UseRefreshToken", query, hash)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrRefreshTokenReused
+	}
+	return nil
+}
+
+func (s *SQLite) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	query := `UPDATE refresh_token SET revoked = true WHERE family_id = ?;`
+	return s.exec(ctx, "RevokeTokenFamily", query, familyID)
+}
+
+func (s *SQLite) RevokeUserRefreshTokens(ctx context.Context, uuid string) error {
+	query := `UPDATE refresh_token SET revoked = true WHERE uuid = ? AND NOT revoked;`
+	return s.exec(ctx, "RevokeUserRefreshTokens", query, uuid)
+}
+
+func (s *SQLite) RevokeToken(ctx context.Context, jti string, expires time.Time) error {
+	query := `
+INSERT INTO revoked_token (jti, expires, revoked_at)
+VALUES (?, ?, ?)
+ON CONFLICT (jti) DO NOTHING
+;`
+	return s.exec(ctx, "RevokeToken", query, jti, timeText(expires), timeText(time.Now()))
+}
+
+func (s *SQLite) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	query := `SELECT jti FROM revoked_token WHERE jti = ?;`
+	var found string
+	err := s.get(ctx, "IsTokenRevoked", query, []interface{}{jti}, &found)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (s *SQLite) RevokedTokensSince(ctx context.Context, since time.Time) ([]string, error) {
+	query := `SELECT jti FROM revoked_token WHERE revoked_at > ? AND expires > ?;`
+	var result []string
+	err := s.query(ctx, "RevokedTokensSince", query, []interface{}{timeText(since), timeText(time.Now())},
+		func(rows *sql.Rows) error {
+			var jti string
+			if err := rows.Scan(&jti); err != nil {
+				return err
+			}
+			result = append(result, jti)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteRevokedTokens forgets the revocations of tokens expired before the time, they are refused anyway.
+func (s *SQLite) DeleteRevokedTokens(ctx context.Context, expiredBefore time.Time) error {
+	query := `DELETE FROM revoked_token WHERE expires < ?;`
+	return s.exec(ctx, "DeleteRevokedTokens", query, timeText(expiredBefore))
+}
+
+func (s *SQLite) RevokeUser(ctx context.Context, uuid string, at time.Time) error {
+	query := `
+INSERT INTO revoked_user (uuid, revoked_at)
+VALUES (?, ?)
+ON CONFLICT (uuid) DO UPDATE SET revoked_at = excluded.revoked_at
+;`
+	return s.exec(ctx, "RevokeUser", query, uuid, timeText(at))
+}
+
+func (s *SQLite) UsersRevokedSince(ctx context.Context, since time.Time) ([]models.RevokedUser, error) {
+	query := `SELECT uuid, revoked_at FROM revoked_user WHERE revoked_at > ?;`
+	var result []models.RevokedUser
+	err := s.query(ctx, "UsersRevokedSince", query, []interface{}{timeText(since)}, func(rows *sql.Rows) error {
+		var user models.RevokedUser
+		if err := rows.Scan(&user.UUID, (*timeText)(&user.RevokedAt)); err != nil {
+			return err
+		}
+		result = append(result, user)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *SQLite) SaveClient(ctx context.Context, client *models.APIClient) error {
+	query := `
+INSERT INTO api_client (id, tenant_id, name, secret_hash, allowed_endpoints, disabled, created, updated)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+;`
+	return s.exec(ctx, "SaveClient", query, client.ID, client.TenantID, client.Name, client.SecretHash,
+		listText(client.AllowedEndpoints), client.Disabled, timeText(client.Created), timeText(client.Updated))
+}
+
+func (s *SQLite) GetClient(ctx context.Context, id string) (*models.APIClient, error) {
+	query := `SELECT id, tenant_id, name, secret_hash, allowed_endpoints, disabled, created, updated, last_used
+FROM api_client
+WHERE id = ?;`
+	var result models.APIClient
+	err := s.get(ctx, "GetClient", query, []interface{}{id}, apiClientColumns(&result)...)
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, common.ErrClientNotFound
+	default:
+		return nil, err
+	}
+}
+
+func (s *SQLite) ListClients(ctx context.Context, tenantID string) ([]models.APIClient, error) {
+	query := `SELECT id, tenant_id, name, secret_hash, allowed_endpoints, disabled, created, updated, last_used
+FROM api_client
+WHERE tenant_id = ?
+ORDER BY created;`
+	var result []models.APIClient
+	err := s.query(ctx, "ListClients", query, []interface{}{tenantID}, func(rows *sql.Rows) error {
+		var client models.APIClient
+		if err := rows.Scan(apiClientColumns(&client)...); err != nil {
+			return err
+		}
+		result = append(result, client)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func apiClientColumns(client *models.APIClient) []interface{} {
+	return []interface{}{&client.ID, &client.TenantID, &client.Name, &client.SecretHash,
+		(*listText)(&client.AllowedEndpoints), &client.Disabled, (*timeText)(&client.Created), (*timeText)(&client.Updated),
+		nullTimeText{&client.LastUsed}}
+}
+
+func (s *SQLite) UpdateClientSecret(ctx context.Context, id, secretHash string) error {
+	query := `UPDATE api_client SET secret_hash = ?, updated = ? WHERE id = ?;`
+	return s.updateClient(ctx, "UpdateClientSecret", query, secretHash, timeText(time.Now()), id)
+}
+
+func (s *SQLite) DisableClient(ctx context.Context, id string) error {
+	query := `UPDATE api_client SET disabled = true, updated = ? WHERE id = ?;`
+	return s.updateClient(ctx, "DisableClient", query, timeText(time.Now()), id)
+}
+
+func (s *SQLite) TouchClient(ctx context.Context, id string, at time.Time) error {
+	query := `UPDATE api_client SET last_used = ? WHERE id = ?;`
+	return s.exec(ctx, "TouchClient", query, timeText(at), id)
+}
+
+// UseNonce records the nonce of a signed request of the client, failing with ErrNonceReused if it was used before.
+// Nonces of the client that expired are forgotten on the way.
+func (s *SQLite) UseNonce(ctx context.Context, clientID, nonce string, expires time.Time) error {
+	query := `DELETE FROM api_nonce WHERE client_id = ? AND expires < ?;`
+	if err := s.exec(ctx, "UseNonce", query, clientID, timeText(time.Now())); err != nil {
+		return err
+	}
+	query = `
+INSERT INTO api_nonce (client_id, nonce, expires)
+VALUES (?, ?, ?)
+ON CONFLICT DO NOTHING
+;`
+	affected, err := s.execAffected(ctx, "UseNonce", query, clientID, nonce, timeText(expires))
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrNonceReused
+	}
+	return nil
+}
+
+func (s *SQLite) updateClient(ctx context.Context, name, query string, args ...interface{}) error {
+	affected, err := s.execAffected(ctx, name, query, args...)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrClientNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) SaveOAuthClient(ctx context.Context, client *models.OAuthClient) error {
+	query := `
+INSERT INTO oauth_client (id, tenant_id, name, secret_hash, redirect_uris, scopes, grant_types, audiences, created)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+;`
+	return s.exec(ctx, "SaveOAuthClient", query, client.ID, client.TenantID, client.Name, client.SecretHash,
+		listText(client.RedirectURIs), listText(client.Scopes), listText(client.GrantTypes), listText(client.Audiences),
+		timeText(client.Created))
+}
+
+func (s *SQLite) GetOAuthClient(ctx context.Context, id string) (*models.OAuthClient, error) {
+	query := `SELECT id, tenant_id, name, secret_hash, redirect_uris, scopes, grant_types, audiences, created
+FROM oauth_client
+WHERE id = ?;`
+	var result models.OAuthClient
+	err := s.get(ctx, "GetOAuthClient", query, []interface{}{id}, oauthClientColumns(&result)...)
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, common.ErrClientNotFound
+	default:
+		return nil, err
+	}
+}
+
+func (s *SQLite) ListOAuthClients(ctx context.Context, tenantID string) ([]models.OAuthClient, error) {
+	query := `SELECT id, tenant_id, name, secret_hash, redirect_uris, scopes, grant_types, audiences, created
+FROM oauth_client
+WHERE tenant_id = ?
+ORDER BY created;`
+	var result []models.OAuthClient
+	err := s.query(ctx, "ListOAuthClients", query, []interface{}{tenantID}, func(rows *sql.Rows) error {
+		var client models.OAuthClient
+		if err := rows.Scan(oauthClientColumns(&client)...); err != nil {
+			return err
+		}
+		result = append(result, client)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func oauthClientColumns(client *models.OAuthClient) []interface{} {
+	return []interface{}{&client.ID, &client.TenantID, &client.Name, &client.SecretHash, (*listText)(&client.RedirectURIs),
+		(*listText)(&client.Scopes), (*listText)(&client.GrantTypes), (*listText)(&client.Audiences),
+		(*timeText)(&client.Created)}
+}
+
+func (s *SQLite) SaveAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	query := `
+INSERT INTO authorization_code (hash, client_id, redirect_uri, uuid, scope, code_challenge, nonce, auth_time, expires)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+;`
+	return s.exec(ctx, "SaveAuthorizationCode", query, code.Hash, code.ClientID, code.RedirectURI, code.UUID,
+		code.Scope, code.CodeChallenge, code.Nonce, timeText(code.AuthTime), timeText(code.Expires))
+}
+
+// TakeAuthorizationCode deletes the code returning it, so that it can be exchanged once.
+func (s *SQLite) TakeAuthorizationCode(ctx context.Context, hash string) (*models.AuthorizationCode, error) {
+	query := `DELETE FROM authorization_code
+WHERE hash = ?
+RETURNING hash, client_id, redirect_uri, uuid, scope, code_challenge, nonce, auth_time, expires;`
+	var result models.AuthorizationCode
+	err := s.get(ctx, "TakeAuthorizationCode", query, []interface{}{hash}, &result.Hash, &result.ClientID,
+		&result.RedirectURI, &result.UUID, &result.Scope, &result.CodeChallenge, &result.Nonce,
+		(*timeText)(&result.AuthTime), (*timeText)(&result.Expires))
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, common.ErrInvalidGrant
+	default:
+		return nil, err
+	}
+}
+
+func (s *SQLite) SaveDeviceCode(ctx context.Context, code *models.DeviceCode) error {
+	query := `
+INSERT INTO device_code (hash, user_code, client_id, scope, status, poll_interval, expires)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+;`
+	return s.exec(ctx, "SaveDeviceCode", query, code.Hash, code.UserCode, code.ClientID, code.Scope, code.Status,
+		code.PollInterval, timeText(code.Expires))
+}
+
+// GetDeviceCode returns the pending code by the user code, common.ErrInvalidGrant if there is none or it expired.
+func (s *SQLite) GetDeviceCode(ctx context.Context, userCode string) (*models.DeviceCode, error) {
+	query := `SELECT hash, user_code, client_id, scope, status, uuid, poll_interval, last_polled, auth_time, expires
+FROM device_code
+WHERE user_code = ?
+  AND status = 'pending'
+  AND expires > ?
+ORDER BY expires DESC
+LIMIT 1;`
+	var result models.DeviceCode
+	err := s.get(ctx, "GetDeviceCode", query, []interface{}{userCode, timeText(time.Now())},
+		deviceCodeColumns(&result)...)
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, common.ErrInvalidGrant
+	default:
+		return nil, err
+	}
+}
+
+// ResolveDeviceCode approves or denies the pending code, common.ErrInvalidGrant if there is none or it expired.
+func (s *SQLite) ResolveDeviceCode(ctx context.Context, userCode, uuid, status string, at time.Time) error {
+	query := `UPDATE device_code
+SET status    = ?3,
+    uuid      = ?2,
+    auth_time = ?4
+WHERE user_code = ?1
+  AND status = 'pending'
+  AND expires > ?4;`
+	affected, err := s.execAffected(ctx, "ResolveDeviceCode", query, userCode, uuid, status, timeText(at))
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrInvalidGrant
+	}
+	return nil
+}
+
+// PollDeviceCode records the poll of the device returning the code as it was before,
+// common.ErrInvalidGrant if there is none.
+func (s *SQLite) PollDeviceCode(ctx context.Context, hash string, at time.Time) (*models.DeviceCode, error) {
+	selectQuery := `SELECT hash, user_code, client_id, scope, status, uuid, poll_interval, last_polled, auth_time, expires
+FROM device_code
+WHERE hash = ?;`
+	updateQuery := `UPDATE device_code SET last_polled = ? WHERE hash = ?;`
+	var result models.DeviceCode
+	err := s.run(ctx, "PollDeviceCode", func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = tx.Rollback() }()
+		if err = tx.QueryRowContext(ctx, selectQuery, hash).Scan(deviceCodeColumns(&result)...); err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, updateQuery, timeText(at), hash); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	switch {
+	case err == nil:
+		return &result, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, common.ErrInvalidGrant
+	default:
+		return nil, err
+	}
+}
+
+// SlowDownDeviceCode makes the device poll the code seconds less often, common.ErrInvalidGrant if there is none.
+func (s *SQLite) SlowDownDeviceCode(ctx context.Context, hash string, seconds int) error {
+	query := `UPDATE device_code SET poll_interval = poll_interval + ? WHERE hash = ?;`
+	affected, err := s.execAffected(ctx, "SlowDownDeviceCode", query, seconds, hash)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrInvalidGrant
+	}
+	return nil
+}
+
+// DeleteDeviceCode removes the code once tokens are issued for it, common.ErrInvalidGrant if it's gone already.
+func (s *SQLite) DeleteDeviceCode(ctx context.Context, hash string) error {
+	query := `DELETE FROM device_code WHERE hash = ?;`
+	affected, err := s.execAffected(ctx, "DeleteDeviceCode", query, hash)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return common.ErrInvalidGrant
+	}
+	return nil
+}
+
+func deviceCodeColumns(code *models.DeviceCode) []interface{} {
+	return []interface{}{&code.Hash, &code.UserCode, &code.ClientID, &code.Scope, &code.Status, &code.UUID,
+		&code.PollInterval, nullTimeText{&code.LastPolled}, nullTimeText{&code.AuthTime}, (*timeText)(&code.Expires)}
+}
@@ -0,0 +1,86 @@
+package profilestore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+// Store is everything the service keeps, implemented by PG, SQLite and MemoryStore. New storage methods go here
+// so that the backends keep up, storetest checks they behave the same.
+type Store interface {
+	GetUser(ctx context.Context, tenantID, phone string) (*models.User, error)
+	GetUserByUUID(ctx context.Context, uuid string) (*models.User, error)
+	// GetUserByUUIDUncached reads the user past any cache, for the reads writes are conditioned on.
+	GetUserByUUIDUncached(ctx context.Context, uuid string) (*models.User, error)
+	UpsertUser(ctx context.Context, user *models.User) error
+	UpdateProfile(ctx context.Context, user *models.User, ifUpdated time.Time) error
+
+	GetChallenge(ctx context.Context, phone string) (*models.Challenge, error)
+	SaveChallenge(ctx context.Context, challenge *models.Challenge) error
+	ClaimChallenge(ctx context.Context, challenge *models.Challenge, createdBefore time.Time) error
+	IncrementAttempts(ctx context.Context, phone string) (int, error)
+	DeleteChallenge(ctx context.Context, phone string) error
+
+	SaveCode(ctx context.Context, phone, codeHash string, expires time.Time) error
+	GetCode(ctx context.Context, phone string) (string, time.Time, error)
+	DeleteCode(ctx context.Context, phone string) error
+
+	SaveRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash string) (*models.RefreshToken, error)
+	UseRefreshToken(ctx context.Context, hash string) error
+	RevokeTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, uuid string) error
+
+	RevokeToken(ctx context.Context, jti string, expires time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	RevokedTokensSince(ctx context.Context, since time.Time) ([]string, error)
+	DeleteRevokedTokens(ctx context.Context, expiredBefore time.Time) error
+	RevokeUser(ctx context.Context, uuid string, at time.Time) error
+	UsersRevokedSince(ctx context.Context, since time.Time) ([]models.RevokedUser, error)
+
+	SaveClient(ctx context.Context, client *models.APIClient) error
+	GetClient(ctx context.Context, id string) (*models.APIClient, error)
+	ListClients(ctx context.Context, tenantID string) ([]models.APIClient, error)
+	UpdateClientSecret(ctx context.Context, id, secretHash string) error
+	DisableClient(ctx context.Context, id string) error
+	TouchClient(ctx context.Context, id string, at time.Time) error
+	UseNonce(ctx context.Context, clientID, nonce string, expires time.Time) error
+
+	SaveOAuthClient(ctx context.Context, client *models.OAuthClient) error
+	GetOAuthClient(ctx context.Context, id string) (*models.OAuthClient, error)
+	ListOAuthClients(ctx context.Context, tenantID string) ([]models.OAuthClient, error)
+	SaveAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error
+	TakeAuthorizationCode(ctx context.Context, hash string) (*models.AuthorizationCode, error)
+
+	SaveDeviceCode(ctx context.Context, code *models.DeviceCode) error
+	GetDeviceCode(ctx context.Context, userCode string) (*models.DeviceCode, error)
+	ResolveDeviceCode(ctx context.Context, userCode, uuid, status string, at time.Time) error
+	PollDeviceCode(ctx context.Context, hash string, at time.Time) (*models.DeviceCode, error)
+	SlowDownDeviceCode(ctx context.Context, hash string, seconds int) error
+	DeleteDeviceCode(ctx context.Context, hash string) error
+
+	SaveAuditEntry(ctx context.Context, entry *models.AuditEntry) error
+
+	SaveRole(ctx context.Context, role *models.Role) error
+	ListRoles(ctx context.Context, tenantID string) ([]models.Role, error)
+	DeleteRole(ctx context.Context, tenantID, name string) error
+	GetUserRoles(ctx context.Context, tenantID, uuid string) ([]models.Role, error)
+	AssignRole(ctx context.Context, tenantID, uuid, role string) error
+	UnassignRole(ctx context.Context, tenantID, uuid, role string) error
+
+	SaveTenant(ctx context.Context, tenant *models.Tenant) error
+	ListTenants(ctx context.Context) ([]models.Tenant, error)
+
+	Close()
+}
+
+// profileMetadata is what is saved for the metadata of a user, an empty object if there is none.
+func profileMetadata(metadata json.RawMessage) json.RawMessage {
+	if len(metadata) == 0 {
+		return json.RawMessage("{}")
+	}
+	return metadata
+}
@@ -0,0 +1,698 @@
+// Package storetest checks that a profilestore.Store behaves the way the service expects of it,
+// so that every backend can be run through the same suite:
+//
+//	func TestMemoryStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) profilestore.Store {
+//			return profilestore.NewMemoryStore(context.Background())
+//		})
+//	}
+//
+// Records are named uniquely on every run, so the suite may be run against a database that has data already.
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/gerladeno/authorization-service/pkg/profilestore"
+	"github.com/google/uuid"
+)
+
+const defaultTenant = "default"
+
+// Run runs the suite, every test on a store returned by newStore.
+func Run(t *testing.T, newStore func(t *testing.T) profilestore.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store profilestore.Store)
+	}{
+		{"Users", testUsers},
+		{"Profiles", testProfiles},
+		{"Tenants", testTenants},
+		{"Challenges", testChallenges},
+		{"Codes", testCodes},
+		{"RefreshTokens", testRefreshTokens},
+		{"Revocations", testRevocations},
+		{"Clients", testClients},
+		{"OAuthClients", testOAuthClients},
+		{"AuthorizationCodes", testAuthorizationCodes},
+		{"DeviceCodes", testDeviceCodes},
+		{"Audit", testAudit},
+		{"Roles", testRoles},
+		{"TenantRoles", testTenantRoles},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			store := newStore(t)
+			t.Cleanup(store.Close)
+			test.fn(t, store)
+		})
+	}
+}
+
+func testUsers(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	tenantID := "tenant-" + unique()
+	check(t, store.SaveTenant(ctx, &models.Tenant{ID: tenantID, Created: now(), Updated: now()}))
+	phone := "+7" + unique()
+
+	_, err := store.GetUser(ctx, defaultTenant, phone)
+	expectErr(t, err, common.ErrPhoneNotFound)
+	_, err = store.GetUserByUUID(ctx, unique())
+	expectErr(t, err, common.ErrUserNotFound)
+
+	user := models.User{UUID: unique(), TenantID: defaultTenant, Phone: phone}
+	check(t, store.UpsertUser(ctx, &user))
+	got, err := store.GetUser(ctx, defaultTenant, phone)
+	check(t, err)
+	if got.UUID != user.UUID || got.TenantID != defaultTenant || got.Phone != phone || got.Created.IsZero() {
+		t.Fatalf("got user %+v, want %+v", got, user)
+	}
+	byUUID, err := store.GetUserByUUID(ctx, user.UUID)
+	check(t, err)
+	if byUUID.Phone != phone {
+		t.Fatalf("got phone %s by uuid, want %s", byUUID.Phone, phone)
+	}
+
+	// the phone is taken within the tenant, the user keeps its uuid
+	check(t, store.UpsertUser(ctx, &models.User{UUID: unique(), TenantID: defaultTenant, Phone: phone}))
+	got, err = store.GetUser(ctx, defaultTenant, phone)
+	check(t, err)
+	if got.UUID != user.UUID {
+		t.Fatalf("upsert changed uuid to %s, want %s", got.UUID, user.UUID)
+	}
+
+	// but not in another one
+	other := models.User{UUID: unique(), TenantID: tenantID, Phone: phone}
+	check(t, store.UpsertUser(ctx, &other))
+	got, err = store.GetUser(ctx, tenantID, phone)
+	check(t, err)
+	if got.UUID != other.UUID || got.TenantID != tenantID {
+		t.Fatalf("got user %+v of the other tenant, want %+v", got, other)
+	}
+
+	if err = store.UpsertUser(ctx, &models.User{UUID: unique(), TenantID: "missing-" + unique(), Phone: phone}); err == nil {
+		t.Fatal("upserted user of unknown tenant")
+	}
+}
+
+func testProfiles(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	user := models.User{UUID: unique(), TenantID: defaultTenant, Phone: "+7" + unique()}
+	check(t, store.UpsertUser(ctx, &user))
+	got, err := store.GetUserByUUID(ctx, user.UUID)
+	check(t, err)
+	if got.Name != "" || string(got.Metadata) != "{}" {
+		t.Fatalf("got profile %+v of a new user, want an empty one", got)
+	}
+
+	got.Name, got.Email, got.AvatarURL = "Ivan", "ivan@example.com", "https://example.com/ivan.png"
+	got.Locale, got.TimeZone, got.Metadata = "ru-RU", "Europe/Moscow", json.RawMessage(`{"theme":"dark"}`)
+	read := got.Updated
+	check(t, store.UpdateProfile(ctx, got, read))
+	if !got.Updated.After(read) {
+		t.Fatalf("update time %v not after %v", got.Updated, read)
+	}
+	updated, err := store.GetUser(ctx, defaultTenant, user.Phone)
+	check(t, err)
+	if updated.Name != got.Name || updated.Email != got.Email || updated.AvatarURL != got.AvatarURL ||
+		updated.Locale != got.Locale || updated.TimeZone != got.TimeZone || !updated.Updated.Equal(got.Updated) {
+		t.Fatalf("got profile %+v, want %+v", updated, got)
+	}
+	var metadata map[string]string
+	check(t, json.Unmarshal(updated.Metadata, &metadata))
+	if metadata["theme"] != "dark" {
+		t.Fatalf("got metadata %s", updated.Metadata)
+	}
+
+	// the profile read before the update is stale
+	got.Name = "Stale"
+	expectErr(t, store.UpdateProfile(ctx, got, read), common.ErrUserModified)
+	expectErr(t, store.UpdateProfile(ctx, &models.User{UUID: unique()}, read), common.ErrUserNotFound)
+}
+
+func testTenants(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	tenants, err := store.ListTenants(ctx)
+	check(t, err)
+	if _, ok := findTenant(tenants, defaultTenant); !ok {
+		t.Fatal("no default tenant")
+	}
+
+	tenant := models.Tenant{ID: "tenant-" + unique(), Name: "Brand", Hosts: []string{"brand.example.com"}, Created: now(),
+		Updated: now()}
+	check(t, store.SaveTenant(ctx, &tenant))
+	tenant.Name, tenant.Issuer = "Renamed", "https://brand.example.com"
+	check(t, store.SaveTenant(ctx, &tenant))
+	tenants, err = store.ListTenants(ctx)
+	check(t, err)
+	got, ok := findTenant(tenants, tenant.ID)
+	if !ok || got.Name != "Renamed" || got.Issuer != tenant.Issuer || !equalStrings(got.Hosts, tenant.Hosts) {
+		t.Fatalf("got tenant %+v, want %+v", got, tenant)
+	}
+	for i := 1; i < len(tenants); i++ {
+		if tenants[i-1].ID > tenants[i].ID {
+			t.Fatalf("tenants not ordered by id: %s before %s", tenants[i-1].ID, tenants[i].ID)
+		}
+	}
+}
+
+func testChallenges(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	phone := "+7" + unique()
+	_, err := store.GetChallenge(ctx, phone)
+	expectErr(t, err, common.ErrChallengeNotFound)
+	_, err = store.IncrementAttempts(ctx, phone)
+	expectErr(t, err, common.ErrChallengeNotFound)
+	expectErr(t, store.DeleteChallenge(ctx, phone), common.ErrChallengeNotFound)
+
+	challenge := models.Challenge{Phone: phone, Channel: "sms", Created: now(), Expires: now().Add(time.Minute)}
+	check(t, store.SaveChallenge(ctx, &challenge))
+	attempts, err := store.IncrementAttempts(ctx, phone)
+	check(t, err)
+	if attempts != 1 {
+		t.Fatalf("got %d attempts, want 1", attempts)
+	}
+	challenge.Channel, challenge.Attempts = "flashcall", 0
+	check(t, store.SaveChallenge(ctx, &challenge))
+	got, err := store.GetChallenge(ctx, phone)
+	check(t, err)
+	if got.Channel != "flashcall" || got.Attempts != 0 || !got.Expires.Equal(challenge.Expires) {
+		t.Fatalf("got challenge %+v, want %+v", got, challenge)
+	}
+	check(t, store.DeleteChallenge(ctx, phone))
+	_, err = store.GetChallenge(ctx, phone)
+	expectErr(t, err, common.ErrChallengeNotFound)
+
+	claimed := models.Challenge{Phone: phone, Channel: "sms", Created: now(), Expires: now().Add(time.Minute)}
+	check(t, store.ClaimChallenge(ctx, &claimed, claimed.Created.Add(-time.Minute)))
+	again := claimed
+	again.Channel, again.Created = "flashcall", claimed.Created.Add(time.Second)
+	expectErr(t, store.ClaimChallenge(ctx, &again, again.Created.Add(-time.Minute)), common.ErrResendTooSoon)
+	got, err = store.GetChallenge(ctx, phone)
+	check(t, err)
+	if got.Channel != claimed.Channel || !got.Created.Equal(claimed.Created) {
+		t.Fatalf("got challenge %+v claimed too soon, want %+v", got, claimed)
+	}
+	again.Created = claimed.Created.Add(time.Minute)
+	check(t, store.ClaimChallenge(ctx, &again, claimed.Created))
+	got, err = store.GetChallenge(ctx, phone)
+	check(t, err)
+	if got.Channel != again.Channel || !got.Created.Equal(again.Created) {
+		t.Fatalf("got challenge %+v, want %+v", got, again)
+	}
+}
+
+func testCodes(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	phone := "+7" + unique()
+	_, _, err := store.GetCode(ctx, phone)
+	expectErr(t, err, common.ErrChallengeNotFound)
+
+	expires := now().Add(time.Minute)
+	check(t, store.SaveCode(ctx, phone, "first", expires))
+	check(t, store.SaveCode(ctx, phone, "second", expires))
+	hash, gotExpires, err := store.GetCode(ctx, phone)
+	check(t, err)
+	if hash != "second" || !gotExpires.Equal(expires) {
+		t.Fatalf("got code %s expiring %s, want second expiring %s", hash, gotExpires, expires)
+	}
+	check(t, store.DeleteCode(ctx, phone))
+	check(t, store.DeleteCode(ctx, phone))
+	_, _, err = store.GetCode(ctx, phone)
+	expectErr(t, err, common.ErrChallengeNotFound)
+}
+
+func testRefreshTokens(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	_, err := store.GetRefreshToken(ctx, unique())
+	expectErr(t, err, common.ErrInvalidRefreshToken)
+
+	userID, familyID := unique(), unique()
+	first := refreshToken(userID, familyID)
+	second := refreshToken(userID, familyID)
+	other := refreshToken(userID, unique())
+	for _, token := range []*models.RefreshToken{first, second, other} {
+		check(t, store.SaveRefreshToken(ctx, token))
+	}
+	if err = store.SaveRefreshToken(ctx, first); err == nil {
+		t.Fatal("saved refresh token twice")
+	}
+	got, err := store.GetRefreshToken(ctx, first.Hash)
+	check(t, err)
+	if got.UUID != userID || got.FamilyID != familyID || got.TenantID != defaultTenant || got.Scope != first.Scope ||
+		got.Used || got.Revoked {
+		t.Fatalf("got refresh token %+v, want %+v", got, first)
+	}
+
+	check(t, store.UseRefreshToken(ctx, first.Hash))
+	expectErr(t, store.UseRefreshToken(ctx, first.Hash), common.ErrRefreshTokenReused)
+	expectErr(t, store.UseRefreshToken(ctx, unique()), common.ErrRefreshTokenReused)
+
+	check(t, store.RevokeTokenFamily(ctx, familyID))
+	expectRevoked(t, store, first.Hash, true)
+	expectRevoked(t, store, second.Hash, true)
+	expectRevoked(t, store, other.Hash, false)
+	check(t, store.RevokeUserRefreshTokens(ctx, userID))
+	expectRevoked(t, store, other.Hash, true)
+}
+
+func refreshToken(userID, familyID string) *models.RefreshToken {
+	return &models.RefreshToken{
+		Hash:     unique(),
+		FamilyID: familyID,
+		UUID:     userID,
+		TenantID: defaultTenant,
+		Scope:    "openid",
+		Created:  now(),
+		Expires:  now().Add(time.Hour),
+	}
+}
+
+func expectRevoked(t *testing.T, store profilestore.Store, hash string, revoked bool) {
+	t.Helper()
+	got, err := store.GetRefreshToken(context.Background(), hash)
+	check(t, err)
+	if got.Revoked != revoked {
+		t.Fatalf("refresh token revoked is %t, want %t", got.Revoked, revoked)
+	}
+}
+
+func testRevocations(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	since := now().Add(-time.Second)
+	live, expired := unique(), unique()
+	revoked, err := store.IsTokenRevoked(ctx, live)
+	check(t, err)
+	if revoked {
+		t.Fatal("token revoked before it was")
+	}
+	check(t, store.RevokeToken(ctx, live, now().Add(time.Hour)))
+	check(t, store.RevokeToken(ctx, live, now().Add(time.Hour)))
+	check(t, store.RevokeToken(ctx, expired, now().Add(-time.Minute)))
+	for _, jti := range []string{live, expired} {
+		revoked, err = store.IsTokenRevoked(ctx, jti)
+		check(t, err)
+		if !revoked {
+			t.Fatalf("token %s not revoked", jti)
+		}
+	}
+	jtis, err := store.RevokedTokensSince(ctx, since)
+	check(t, err)
+	if !common.Contains(jtis, live) || common.Contains(jtis, expired) {
+		t.Fatalf("got %v revoked since, want %s without %s", jtis, live, expired)
+	}
+	check(t, store.DeleteRevokedTokens(ctx, now()))
+	for jti, want := range map[string]bool{live: true, expired: false} {
+		revoked, err = store.IsTokenRevoked(ctx, jti)
+		check(t, err)
+		if revoked != want {
+			t.Fatalf("token %s revoked is %t after deleting expired revocations, want %t", jti, revoked, want)
+		}
+	}
+
+	userID, at := unique(), now()
+	check(t, store.RevokeUser(ctx, userID, at.Add(-time.Minute)))
+	check(t, store.RevokeUser(ctx, userID, at))
+	users, err := store.UsersRevokedSince(ctx, since)
+	check(t, err)
+	found := false
+	for _, user := range users {
+		if user.UUID == userID {
+			found = user.RevokedAt.Equal(at)
+		}
+	}
+	if !found {
+		t.Fatalf("got %+v revoked since, want %s revoked at %s", users, userID, at)
+	}
+}
+
+func testClients(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	_, err := store.GetClient(ctx, unique())
+	expectErr(t, err, common.ErrClientNotFound)
+	expectErr(t, store.UpdateClientSecret(ctx, unique(), "hash"), common.ErrClientNotFound)
+	expectErr(t, store.DisableClient(ctx, unique()), common.ErrClientNotFound)
+	check(t, store.TouchClient(ctx, unique(), now()))
+
+	first := apiClient(now().Add(-time.Second))
+	second := apiClient(now())
+	check(t, store.SaveClient(ctx, second))
+	check(t, store.SaveClient(ctx, first))
+	if err = store.SaveClient(ctx, first); err == nil {
+		t.Fatal("saved client twice")
+	}
+	got, err := store.GetClient(ctx, first.ID)
+	check(t, err)
+	if got.Name != first.Name || got.TenantID != first.TenantID || got.SecretHash != first.SecretHash || got.Disabled ||
+		got.LastUsed != nil || !equalStrings(got.AllowedEndpoints, first.AllowedEndpoints) {
+		t.Fatalf("got client %+v, want %+v", got, first)
+	}
+
+	check(t, store.UpdateClientSecret(ctx, first.ID, "rotated"))
+	check(t, store.DisableClient(ctx, first.ID))
+	usedAt := now()
+	check(t, store.TouchClient(ctx, first.ID, usedAt))
+	got, err = store.GetClient(ctx, first.ID)
+	check(t, err)
+	if got.SecretHash != "rotated" || !got.Disabled || got.LastUsed == nil || !got.LastUsed.Equal(usedAt) {
+		t.Fatalf("got updated client %+v", got)
+	}
+
+	nonce := unique()
+	check(t, store.UseNonce(ctx, first.ID, nonce, now().Add(time.Minute)))
+	expectErr(t, store.UseNonce(ctx, first.ID, nonce, now().Add(time.Minute)), common.ErrNonceReused)
+	check(t, store.UseNonce(ctx, second.ID, nonce, now().Add(time.Minute)))
+	// expired nonces are forgotten
+	expired := unique()
+	check(t, store.UseNonce(ctx, first.ID, expired, now().Add(-time.Second)))
+	check(t, store.UseNonce(ctx, first.ID, expired, now().Add(time.Minute)))
+
+	clients, err := store.ListClients(ctx, unique())
+	check(t, err)
+	if len(clients) != 0 {
+		t.Fatalf("got %d clients of another tenant", len(clients))
+	}
+	clients, err = store.ListClients(ctx, first.TenantID)
+	check(t, err)
+	firstAt, secondAt := -1, -1
+	for i := range clients {
+		switch clients[i].ID {
+		case first.ID:
+			firstAt = i
+		case second.ID:
+			secondAt = i
+		}
+	}
+	if firstAt < 0 || secondAt < firstAt {
+		t.Fatalf("clients not listed by creation, %s at %d, %s at %d", first.ID, firstAt, second.ID, secondAt)
+	}
+}
+
+func apiClient(created time.Time) *models.APIClient {
+	return &models.APIClient{
+		ID:               unique(),
+		TenantID:         common.DefaultTenant,
+		Name:             "billing",
+		SecretHash:       "hash",
+		AllowedEndpoints: []string{"/private/v1/*"},
+		Created:          created,
+		Updated:          created,
+	}
+}
+
+func testOAuthClients(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	_, err := store.GetOAuthClient(ctx, unique())
+	expectErr(t, err, common.ErrClientNotFound)
+
+	client := models.OAuthClient{
+		ID:           unique(),
+		TenantID:     common.DefaultTenant,
+		Name:         "app",
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		Scopes:       []string{"openid", "phone"},
+		GrantTypes:   []string{"authorization_code", "refresh_token"},
+		Audiences:    []string{},
+		Created:      now(),
+	}
+	check(t, store.SaveOAuthClient(ctx, &client))
+	if err = store.SaveOAuthClient(ctx, &client); err == nil {
+		t.Fatal("saved oauth client twice")
+	}
+	got, err := store.GetOAuthClient(ctx, client.ID)
+	check(t, err)
+	if got.Name != client.Name || got.TenantID != client.TenantID || got.SecretHash != "" ||
+		!equalStrings(got.RedirectURIs, client.RedirectURIs) || !equalStrings(got.Scopes, client.Scopes) ||
+		!equalStrings(got.GrantTypes, client.GrantTypes) {
+		t.Fatalf("got oauth client %+v, want %+v", got, client)
+	}
+	clients, err := store.ListOAuthClients(ctx, unique())
+	check(t, err)
+	if len(clients) != 0 {
+		t.Fatalf("got %d oauth clients of another tenant", len(clients))
+	}
+	clients, err = store.ListOAuthClients(ctx, client.TenantID)
+	check(t, err)
+	found := false
+	for i := range clients {
+		found = found || clients[i].ID == client.ID
+	}
+	if !found {
+		t.Fatalf("oauth client %s not listed", client.ID)
+	}
+}
+
+func testAuthorizationCodes(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	code := models.AuthorizationCode{
+		Hash:          unique(),
+		ClientID:      unique(),
+		RedirectURI:   "https://app.example.com/callback",
+		UUID:          unique(),
+		Scope:         "openid",
+		CodeChallenge: "challenge",
+		Nonce:         "nonce",
+		AuthTime:      now(),
+		Expires:       now().Add(time.Minute),
+	}
+	check(t, store.SaveAuthorizationCode(ctx, &code))
+	got, err := store.TakeAuthorizationCode(ctx, code.Hash)
+	check(t, err)
+	if got.ClientID != code.ClientID || got.UUID != code.UUID || got.CodeChallenge != code.CodeChallenge ||
+		got.Nonce != code.Nonce || !got.Expires.Equal(code.Expires) {
+		t.Fatalf("got authorization code %+v, want %+v", got, code)
+	}
+	_, err = store.TakeAuthorizationCode(ctx, code.Hash)
+	expectErr(t, err, common.ErrInvalidGrant)
+}
+
+func testDeviceCodes(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	userCode := unique()
+	_, err := store.GetDeviceCode(ctx, userCode)
+	expectErr(t, err, common.ErrInvalidGrant)
+
+	expired := deviceCode(userCode, now().Add(-time.Minute))
+	code := deviceCode(userCode, now().Add(time.Minute))
+	check(t, store.SaveDeviceCode(ctx, expired))
+	check(t, store.SaveDeviceCode(ctx, code))
+	got, err := store.GetDeviceCode(ctx, userCode)
+	check(t, err)
+	if got.Hash != code.Hash || got.Status != models.DeviceCodePending || got.PollInterval != code.PollInterval {
+		t.Fatalf("got device code %+v, want %+v", got, code)
+	}
+
+	polledAt := now()
+	prev, err := store.PollDeviceCode(ctx, code.Hash, polledAt)
+	check(t, err)
+	if prev.LastPolled != nil {
+		t.Fatalf("first poll returned last polled %s", prev.LastPolled)
+	}
+	prev, err = store.PollDeviceCode(ctx, code.Hash, polledAt.Add(time.Second))
+	check(t, err)
+	if prev.LastPolled == nil || !prev.LastPolled.Equal(polledAt) {
+		t.Fatalf("second poll returned last polled %v, want %s", prev.LastPolled, polledAt)
+	}
+	_, err = store.PollDeviceCode(ctx, unique(), polledAt)
+	expectErr(t, err, common.ErrInvalidGrant)
+	check(t, store.SlowDownDeviceCode(ctx, code.Hash, 5))
+	prev, err = store.PollDeviceCode(ctx, code.Hash, polledAt.Add(2*time.Second))
+	check(t, err)
+	if prev.PollInterval != code.PollInterval+5 {
+		t.Fatalf("got poll interval %d slowed down, want %d", prev.PollInterval, code.PollInterval+5)
+	}
+	expectErr(t, store.SlowDownDeviceCode(ctx, unique(), 5), common.ErrInvalidGrant)
+
+	userID, approvedAt := unique(), now()
+	check(t, store.ResolveDeviceCode(ctx, userCode, userID, models.DeviceCodeApproved, approvedAt))
+	expectErr(t, store.ResolveDeviceCode(ctx, userCode, userID, models.DeviceCodeDenied, approvedAt), common.ErrInvalidGrant)
+	_, err = store.GetDeviceCode(ctx, userCode)
+	expectErr(t, err, common.ErrInvalidGrant)
+	prev, err = store.PollDeviceCode(ctx, code.Hash, now())
+	check(t, err)
+	if prev.Status != models.DeviceCodeApproved || prev.UUID != userID || prev.AuthTime == nil ||
+		!prev.AuthTime.Equal(approvedAt) {
+		t.Fatalf("got resolved device code %+v", prev)
+	}
+
+	check(t, store.DeleteDeviceCode(ctx, code.Hash))
+	expectErr(t, store.DeleteDeviceCode(ctx, code.Hash), common.ErrInvalidGrant)
+}
+
+func deviceCode(userCode string, expires time.Time) *models.DeviceCode {
+	return &models.DeviceCode{
+		Hash:         unique(),
+		UserCode:     userCode,
+		ClientID:     unique(),
+		Scope:        "openid",
+		Status:       models.DeviceCodePending,
+		PollInterval: 5,
+		Expires:      expires,
+	}
+}
+
+func testAudit(t *testing.T, store profilestore.Store) {
+	entry := models.AuditEntry{
+		ID:      unique(),
+		Action:  "impersonate",
+		Actor:   unique(),
+		Subject: unique(),
+		Details: "{}",
+		Created: now(),
+	}
+	check(t, store.SaveAuditEntry(context.Background(), &entry))
+	if err := store.SaveAuditEntry(context.Background(), &entry); err == nil {
+		t.Fatal("saved audit entry twice")
+	}
+}
+
+func testRoles(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	userID := unique()
+	admin := models.Role{TenantID: defaultTenant, Name: "admin-" + unique(), Permissions: []string{"users:write"},
+		Created: now(), Updated: now()}
+	editor := models.Role{TenantID: defaultTenant, Name: "editor-" + unique(), Permissions: []string{"posts:write"},
+		Created: now(), Updated: now()}
+	expectErr(t, store.AssignRole(ctx, defaultTenant, userID, admin.Name), common.ErrRoleNotFound)
+	expectErr(t, store.DeleteRole(ctx, defaultTenant, admin.Name), common.ErrRoleNotFound)
+
+	check(t, store.SaveRole(ctx, &admin))
+	check(t, store.SaveRole(ctx, &editor))
+	admin.Description, admin.Permissions = "Administrators", []string{"users:read", "users:write"}
+	check(t, store.SaveRole(ctx, &admin))
+	roles, err := store.ListRoles(ctx, defaultTenant)
+	check(t, err)
+	got, ok := findRole(roles, admin.Name)
+	if !ok || got.TenantID != defaultTenant || got.Description != admin.Description ||
+		!equalStrings(got.Permissions, admin.Permissions) {
+		t.Fatalf("got role %+v, want %+v", got, admin)
+	}
+
+	check(t, store.AssignRole(ctx, defaultTenant, userID, editor.Name))
+	check(t, store.AssignRole(ctx, defaultTenant, userID, admin.Name))
+	check(t, store.AssignRole(ctx, defaultTenant, userID, admin.Name))
+	roles, err = store.GetUserRoles(ctx, defaultTenant, userID)
+	check(t, err)
+	if len(roles) != 2 || roles[0].Name != admin.Name || roles[1].Name != editor.Name {
+		t.Fatalf("got user roles %+v, want %s and %s by name", roles, admin.Name, editor.Name)
+	}
+
+	check(t, store.UnassignRole(ctx, defaultTenant, userID, editor.Name))
+	expectErr(t, store.UnassignRole(ctx, defaultTenant, userID, editor.Name), common.ErrRoleNotFound)
+	check(t, store.DeleteRole(ctx, defaultTenant, admin.Name))
+	roles, err = store.GetUserRoles(ctx, defaultTenant, userID)
+	check(t, err)
+	if len(roles) != 0 {
+		t.Fatalf("got user roles %+v after deleting them", roles)
+	}
+	check(t, store.DeleteRole(ctx, defaultTenant, editor.Name))
+}
+
+func testTenantRoles(t *testing.T, store profilestore.Store) {
+	ctx := context.Background()
+	tenantID := "tenant-" + unique()
+	check(t, store.SaveTenant(ctx, &models.Tenant{ID: tenantID, Created: now(), Updated: now()}))
+	userID := unique()
+	role := models.Role{TenantID: tenantID, Name: "admin-" + unique(), Permissions: []string{"users:write"},
+		Created: now(), Updated: now()}
+	check(t, store.SaveRole(ctx, &role))
+
+	// the role of a tenant is neither seen nor assignable in another one
+	roles, err := store.ListRoles(ctx, defaultTenant)
+	check(t, err)
+	if _, ok := findRole(roles, role.Name); ok {
+		t.Fatalf("role %s of %s listed in the default tenant", role.Name, tenantID)
+	}
+	expectErr(t, store.AssignRole(ctx, defaultTenant, userID, role.Name), common.ErrRoleNotFound)
+	expectErr(t, store.DeleteRole(ctx, defaultTenant, role.Name), common.ErrRoleNotFound)
+
+	check(t, store.AssignRole(ctx, tenantID, userID, role.Name))
+	roles, err = store.GetUserRoles(ctx, defaultTenant, userID)
+	check(t, err)
+	if len(roles) != 0 {
+		t.Fatalf("got user roles %+v of %s in the default tenant", roles, tenantID)
+	}
+	expectErr(t, store.UnassignRole(ctx, defaultTenant, userID, role.Name), common.ErrRoleNotFound)
+
+	// a role of the same name in the default tenant is another role
+	same := models.Role{TenantID: defaultTenant, Name: role.Name, Permissions: []string{"posts:read"}, Created: now(),
+		Updated: now()}
+	check(t, store.SaveRole(ctx, &same))
+	roles, err = store.GetUserRoles(ctx, tenantID, userID)
+	check(t, err)
+	if len(roles) != 1 || roles[0].TenantID != tenantID || !equalStrings(roles[0].Permissions, role.Permissions) {
+		t.Fatalf("got user roles %+v, want %+v", roles, role)
+	}
+	check(t, store.DeleteRole(ctx, defaultTenant, same.Name))
+	roles, err = store.GetUserRoles(ctx, tenantID, userID)
+	check(t, err)
+	if len(roles) != 1 {
+		t.Fatalf("got user roles %+v after deleting the default tenant's role", roles)
+	}
+	check(t, store.DeleteRole(ctx, tenantID, role.Name))
+
+	if err = store.SaveRole(ctx, &models.Role{TenantID: "missing-" + unique(), Name: role.Name, Created: now(),
+		Updated: now()}); err == nil {
+		t.Fatal("saved role of unknown tenant")
+	}
+}
+
+func findTenant(tenants []models.Tenant, id string) (models.Tenant, bool) {
+	for i := range tenants {
+		if tenants[i].ID == id {
+			return tenants[i], true
+		}
+	}
+	return models.Tenant{}, false
+}
+
+func findRole(roles []models.Role, name string) (models.Role, bool) {
+	for i := range roles {
+		if roles[i].Name == name {
+			return roles[i], true
+		}
+	}
+	return models.Role{}, false
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectErr(t *testing.T, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("got err %v, want %v", err, want)
+	}
+}
+
+func unique() string {
+	return uuid.New().String()
+}
+
+// now is truncated to what postgres keeps.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
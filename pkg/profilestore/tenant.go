@@ -0,0 +1,38 @@
+package profilestore
+
+import (
+	"context"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// SaveTenant creates the tenant or replaces the name, hosts and issuer of the existing one.
+func (pg *PG) SaveTenant(ctx context.Context, tenant *models.Tenant) error {
+	query := `
+INSERT INTO tenant (id, name, hosts, issuer, created, updated)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (id) DO UPDATE SET name    = excluded.name,
+                               hosts   = excluded.hosts,
+                               issuer  = excluded.issuer,
+                               updated = excluded.updated
+;`
+	return pg.exec(ctx, "SaveTenant", query,
+		tenant.ID, tenant.Name, tenant.Hosts, tenant.Issuer, tenant.Created, tenant.Updated)
+}
+
+func (pg *PG) ListTenants(ctx context.Context) ([]models.Tenant, error) {
+	query := `SELECT id, name, hosts, issuer, created, updated
+FROM tenant
+ORDER BY id;`
+	var result []models.Tenant
+	err := pg.retry(ctx, "ListTenants", func(ctx context.Context, conn *pgxpool.Conn) error {
+		result = nil
+		return pgxscan.Select(ctx, conn, &result, query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
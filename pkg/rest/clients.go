@@ -0,0 +1,96 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *handler) listClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.apiClients.List(r.Context())
+	if err != nil {
+		h.log.Warnf("err listing clients: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	result := make([]map[string]interface{}, 0, len(clients))
+	for i := range clients {
+		result = append(result, clientResponse(&clients[i]))
+	}
+	writeResponse(w, result)
+}
+
+func (h *handler) createClient(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	endpoints := r.Form["endpoint"]
+	if name == "" || len(endpoints) == 0 {
+		writeErrResponse(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	client, secret, err := h.apiClients.Create(r.Context(), name, endpoints)
+	if err != nil {
+		h.log.Warnf("err creating client: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	result := clientResponse(client)
+	result["secret"] = secret
+	if key := h.apiClients.SigningKey(secret); key != "" {
+		result["signingKey"] = key
+	}
+	writeResponse(w, result)
+}
+
+func (h *handler) rotateClient(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	secret, err := h.apiClients.Rotate(r.Context(), id)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrClientNotFound):
+		writeErrResponse(w, "Client not found", http.StatusNotFound)
+		return
+	default:
+		h.log.Warnf("err rotating client secret: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	result := map[string]string{"id": id, "secret": secret}
+	if key := h.apiClients.SigningKey(secret); key != "" {
+		result["signingKey"] = key
+	}
+	writeResponse(w, result)
+}
+
+func (h *handler) disableClient(w http.ResponseWriter, r *http.Request) {
+	err := h.apiClients.Disable(r.Context(), chi.URLParam(r, "id"))
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrClientNotFound):
+		writeErrResponse(w, "Client not found", http.StatusNotFound)
+		return
+	default:
+		h.log.Warnf("err disabling client: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+func clientResponse(client *models.APIClient) map[string]interface{} {
+	result := map[string]interface{}{
+		"id":               client.ID,
+		"name":             client.Name,
+		"allowedEndpoints": client.AllowedEndpoints,
+		"disabled":         client.Disabled,
+		"created":          client.Created.Format(time.RFC3339),
+		"updated":          client.Updated.Format(time.RFC3339),
+	}
+	if client.LastUsed != nil {
+		result["lastUsed"] = client.LastUsed.Format(time.RFC3339)
+	}
+	return result
+}
@@ -0,0 +1,117 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/oauth"
+)
+
+type devicePage struct {
+	signInForm
+	UserCode string
+	// Client is set once the user code is found.
+	Client string
+	Scopes []string
+	// Done is shown instead of the form once the request is approved or denied.
+	Done  string
+	Fatal string
+}
+
+// deviceAuthorization is the device authorization endpoint of RFC 8628, clients authenticate
+// the same way as at the token endpoint.
+func (h *handler) deviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	id, secret := oauthClient(r)
+	result, err := h.oauth.AuthorizeDevice(r.Context(), id, secret, r.PostFormValue("scope"))
+	var oauthErr *oauth.Error
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidClient):
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		writeOAuthError(w, "invalid_client", http.StatusUnauthorized)
+		return
+	case errors.As(err, &oauthErr):
+		h.log.Debug(err)
+		writeOAuthError(w, oauthErr.Code, http.StatusBadRequest)
+		return
+	default:
+		h.log.Warnf("err authorizing device: %v", err)
+		writeOAuthError(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(result) //nolint:errchkjson
+}
+
+// device is the page users approve devices at. They enter the user code shown by the device and the phone,
+// then the code sent, and allow or deny the device access. Denying takes the code too, seeing the user code
+// on the device isn't enough to cancel the sign in.
+func (h *handler) device(w http.ResponseWriter, r *http.Request) {
+	page := devicePage{UserCode: r.FormValue("user_code")}
+	if page.UserCode == "" {
+		h.renderPage(w, deviceTemplate, http.StatusOK, &page)
+		return
+	}
+	client, code, err := h.oauth.DeviceRequest(r.Context(), page.UserCode)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidGrant):
+		page.Error = "Unknown or expired code"
+		h.renderPage(w, deviceTemplate, http.StatusOK, &page)
+		return
+	default:
+		h.log.Warnf("err getting device request: %v", err)
+		h.renderPage(w, deviceTemplate, http.StatusInternalServerError, &devicePage{Fatal: "Internal server error"})
+		return
+	}
+	page.Client = client.Name
+	page.Scopes = strings.Fields(code.Scope)
+	phone := r.PostFormValue("phone")
+	switch r.PostFormValue("action") {
+	case "send":
+		err = h.sendCode(r, &page.signInForm, phone)
+	case "allow", "deny":
+		h.resolveDevice(w, r, &page, phone, r.PostFormValue("code"), r.PostFormValue("action") == "allow")
+		return
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidGrant):
+		page = devicePage{Fatal: "The code expired, start over on the device"}
+	default:
+		h.log.Warnf("err handling device request: %v", err)
+		h.renderPage(w, deviceTemplate, http.StatusInternalServerError, &devicePage{Fatal: "Internal server error"})
+		return
+	}
+	h.renderPage(w, deviceTemplate, http.StatusOK, &page)
+}
+
+// resolveDevice signs the user in and approves or denies the device.
+func (h *handler) resolveDevice(w http.ResponseWriter, r *http.Request, page *devicePage, phone, code string,
+	approve bool) {
+	user, err := h.signInUser(r, &page.signInForm, phone, code)
+	if err == nil && user != nil {
+		err = h.oauth.ResolveDevice(r.Context(), page.UserCode, user.UUID, approve)
+		switch {
+		case err != nil:
+		case approve:
+			page.Done = "Device connected, you can return to it"
+		default:
+			page.Done = "Access denied, you can close the page"
+		}
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidGrant):
+		*page = devicePage{Fatal: "The code expired, start over on the device"}
+	default:
+		h.log.Warnf("err resolving device: %v", err)
+		h.renderPage(w, deviceTemplate, http.StatusInternalServerError, &devicePage{Fatal: "Internal server error"})
+		return
+	}
+	h.renderPage(w, deviceTemplate, http.StatusOK, page)
+}
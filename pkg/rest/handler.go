@@ -1,28 +1,47 @@
 package rest
 
 import (
+	"encoding/json"
 	"errors"
-	"github.com/go-chi/chi/v5"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 
 	"github.com/google/uuid"
 
+	"github.com/gerladeno/authorization-service/pkg/authorization"
 	"github.com/gerladeno/authorization-service/pkg/common"
 	"github.com/gerladeno/authorization-service/pkg/models"
 	"github.com/sirupsen/logrus"
 )
 
 type handler struct {
-	log      *logrus.Entry
-	provider TokenProvider
-	store    ProfileStore
+	log        *logrus.Entry
+	provider   TokenProvider
+	store      ProfileStore
+	apiClients APIClients
+	oauth      OAuthServer
+	policies   PolicyDecider
 }
 
-func newHandler(log *logrus.Logger, provider TokenProvider, store ProfileStore) *handler {
+func newHandler(
+	log *logrus.Logger,
+	provider TokenProvider,
+	store ProfileStore,
+	apiClients APIClients,
+	oauth OAuthServer,
+	policies PolicyDecider,
+) *handler {
 	h := handler{
-		log:      log.WithField("module", "http_in"),
-		provider: provider,
-		store:    store,
+		log:        log.WithField("module", "http_in"),
+		provider:   provider,
+		store:      store,
+		apiClients: apiClients,
+		oauth:      oauth,
+		policies:   policies,
 	}
 	return &h
 }
@@ -33,22 +52,25 @@ func (h *handler) authenticate(w http.ResponseWriter, r *http.Request) {
 		writeErrResponse(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	user, err := h.store.GetUser(r.Context(), phone)
+	user, err := h.store.GetUser(r.Context(), requestTenant(r), phone)
 	switch {
 	case err == nil:
 	case errors.Is(err, common.ErrPhoneNotFound):
-		user = &models.User{Phone: phone}
+		user = &models.User{Phone: phone, TenantID: requestTenant(r)}
 	default:
 		h.log.Warnf("err finding user in authentication: %v", err)
 		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	err = h.provider.StartAuthentication(r.Context(), user)
+	err = h.provider.StartAuthentication(r.Context(), user, r.URL.Query().Get("channel"))
 	switch {
 	case err == nil:
-	case errors.Is(err, common.ErrInvalidPhoneNumber):
+	case errors.Is(err, common.ErrInvalidPhoneNumber), errors.Is(err, common.ErrUnknownChannel):
 		writeErrResponse(w, "Bad request", http.StatusBadRequest)
 		return
+	case errors.Is(err, common.ErrResendTooSoon):
+		writeErrResponse(w, "Code already sent, try again later", http.StatusTooManyRequests)
+		return
 	default:
 		h.log.Warnf("err initiating authentication: %v", err)
 		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
@@ -63,29 +85,31 @@ func (h *handler) signIn(w http.ResponseWriter, r *http.Request) {
 		writeErrResponse(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	user, err := h.store.GetUser(r.Context(), phone)
-	switch {
-	case err == nil:
-	case errors.Is(err, common.ErrPhoneNotFound):
-		id, e := uuid.NewUUID()
-		if e != nil {
-			h.log.Warnf("err generating user uuid: %v", err)
-			writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-		user = &models.User{Phone: phone, UUID: id.String()}
-	default:
+	user, err := h.getOrNewUser(r, phone)
+	if err != nil {
 		h.log.Warnf("err finding user in signIn: %v", err)
 		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 	code := r.URL.Query().Get("code")
-	token, err := h.provider.SignIn(r.Context(), user, code)
+	tokens, err := h.provider.SignIn(r.Context(), user, code)
 	switch {
 	case err == nil:
 	case errors.Is(err, common.ErrInvalidPhoneNumber):
 		writeErrResponse(w, "Bad request", http.StatusBadRequest)
 		return
+	case errors.Is(err, common.ErrInvalidCode):
+		writeErrResponse(w, "Invalid code", http.StatusUnauthorized)
+		return
+	case errors.Is(err, common.ErrCodeExpired):
+		writeErrResponse(w, "Code expired", http.StatusUnauthorized)
+		return
+	case errors.Is(err, common.ErrAttemptsExhausted):
+		writeErrResponse(w, "Too many attempts, request a new code", http.StatusTooManyRequests)
+		return
+	case errors.Is(err, common.ErrChallengeNotFound):
+		writeErrResponse(w, "No code was requested", http.StatusUnauthorized)
+		return
 	case errors.Is(err, common.ErrUnauthenticated):
 		writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -99,7 +123,55 @@ func (h *handler) signIn(w http.ResponseWriter, r *http.Request) {
 		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	writeResponse(w, map[string]string{"uuid": user.UUID, "token": token})
+	writeResponse(w, tokensResponse(tokens))
+}
+
+// getOrNewUser finds the user by phone or makes up a new one, saved once signed in.
+func (h *handler) getOrNewUser(r *http.Request, phone string) (*models.User, error) {
+	user, err := h.store.GetUser(r.Context(), requestTenant(r), phone)
+	switch {
+	case err == nil:
+		return user, nil
+	case errors.Is(err, common.ErrPhoneNotFound):
+		id, err := uuid.NewUUID()
+		if err != nil {
+			return nil, fmt.Errorf("err generating user uuid: %w", err)
+		}
+		return &models.User{Phone: phone, UUID: id.String(), TenantID: requestTenant(r)}, nil
+	default:
+		return nil, err
+	}
+}
+
+func (h *handler) refresh(w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.FormValue("refreshToken")
+	if refreshToken == "" {
+		writeErrResponse(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	// tokens of OAuth clients are refreshed by them at /oauth/token, authenticated
+	tokens, err := h.provider.Refresh(r.Context(), refreshToken, "")
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidRefreshToken), errors.Is(err, common.ErrRefreshTokenReused):
+		writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	default:
+		h.log.Warnf("err refreshing token: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, tokensResponse(tokens))
+}
+
+func tokensResponse(tokens *models.Tokens) map[string]string {
+	return map[string]string{
+		"uuid":             tokens.UUID,
+		"token":            tokens.AccessToken,
+		"expiresAt":        tokens.AccessExpires.Format(time.RFC3339),
+		"refreshToken":     tokens.RefreshToken,
+		"refreshExpiresAt": tokens.RefreshExpires.Format(time.RFC3339),
+	}
 }
 
 func (h *handler) verify(w http.ResponseWriter, r *http.Request) {
@@ -108,10 +180,10 @@ func (h *handler) verify(w http.ResponseWriter, r *http.Request) {
 		writeErrResponse(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	id, err := h.provider.ParseToken(token)
+	id, err := h.provider.ParseToken(r.Context(), token)
 	switch {
 	case err == nil:
-	case errors.Is(err, common.ErrInvalidAccessToken):
+	case errors.Is(err, common.ErrInvalidAccessToken), errors.Is(err, common.ErrTokenRevoked):
 		writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	default:
@@ -122,14 +194,90 @@ func (h *handler) verify(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, map[string]string{"userId": id})
 }
 
+func (h *handler) logout(w http.ResponseWriter, r *http.Request) {
+	token, _ := r.Context().Value(tokenKey).(string)
+	if err := h.provider.Logout(r.Context(), token); err != nil {
+		h.log.Warnf("err logging out: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+func (h *handler) logoutAll(w http.ResponseWriter, r *http.Request) {
+	token, _ := r.Context().Value(tokenKey).(string)
+	if err := h.provider.LogoutAll(r.Context(), token); err != nil {
+		h.log.Warnf("err logging out of all devices: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+// forward answers forward auth subrequests of nginx auth_request, Traefik ForwardAuth or Envoy ext_authz over http,
+// passing the user on in X-User-* headers, X-Client-Id tells the OAuth client the token was issued to, if any,
+// X-Actor-Id the support staff member impersonating the user, X-Roles the roles of the user and X-Tenant-Id
+// the tenant of the token.
+func (h *handler) forward(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	identity, err := h.provider.Identify(r.Context(), token)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidAccessToken), errors.Is(err, common.ErrTokenRevoked):
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	default:
+		h.log.Warnf("err identifying token: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-User-Id", identity.UUID)
+	w.Header().Set("X-User-Phone", identity.Phone)
+	w.Header().Set("X-Scopes", strings.Join(identity.Scopes, " "))
+	w.Header().Set("X-Client-Id", identity.ClientID)
+	w.Header().Set("X-Actor-Id", identity.Actor)
+	w.Header().Set("X-Roles", strings.Join(identity.Roles, " "))
+	w.Header().Set("X-Tenant-Id", identity.TenantID)
+	writeResponse(w, "Ok")
+}
+
+// introspect implements RFC 7662, the response is not wrapped into JSONResponse.
+func (h *handler) introspect(w http.ResponseWriter, r *http.Request) {
+	token := r.PostFormValue("token")
+	if token == "" {
+		writeOAuthError(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	result, err := h.provider.Introspect(r.Context(), token, r.PostFormValue("token_type_hint"))
+	if err != nil {
+		h.log.Warnf("err introspecting token: %v", err)
+		writeOAuthError(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(result) //nolint:errchkjson
+}
+
 func (h *handler) getToken(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "uuid")
 	if !isValidUUID(id) {
 		writeErrResponse(w, "Bad request", http.StatusBadRequest)
 		return
 	}
-	token, err := h.provider.GetToken(id)
-	if err != nil {
+	token, err := h.provider.GetToken(r.Context(), id)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrUserNotFound):
+		writeErrResponse(w, "User not found", http.StatusNotFound)
+		return
+	default:
 		h.log.Warnf("err parsing token: %v", err)
 		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -137,6 +285,37 @@ func (h *handler) getToken(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, map[string]string{"uuid": id, "token": token})
 }
 
+func (h *handler) rotateKeys(w http.ResponseWriter, r *http.Request) {
+	err := h.provider.RotateKeys(r.Context())
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrKeysRotating):
+		writeErrResponse(w, "Keys are being rotated", http.StatusConflict)
+		return
+	default:
+		h.log.Warnf("err rotating keys: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, h.provider.JWKS(r.Context()))
+}
+
+func (h *handler) reloadKeys(w http.ResponseWriter, r *http.Request) {
+	if err := h.provider.ReloadKeys(r.Context()); err != nil {
+		h.log.Warnf("err reloading keys: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, h.provider.JWKS(r.Context()))
+}
+
+// jwks is served as is, not wrapped into JSONResponse, as verifiers expect RFC 7517 key sets.
+func (h *handler) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(authorization.JWKSMaxAge.Seconds())))
+	_ = json.NewEncoder(w).Encode(h.provider.JWKS(r.Context())) //nolint:errchkjson
+}
+
 func isValidUUID(u string) bool {
 	_, err := uuid.Parse(u)
 	return err == nil
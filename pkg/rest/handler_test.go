@@ -0,0 +1,232 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/authorization"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/gerladeno/authorization-service/pkg/oauth"
+	"github.com/gerladeno/authorization-service/pkg/profilestore"
+	"github.com/gerladeno/authorization-service/pkg/revocation"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	otherTenant = "other"
+	otherHost   = "other.example.com"
+)
+
+// testService is the router over the services keeping everything in memory, serving the default tenant
+// and the other one at otherHost.
+type testService struct {
+	router http.Handler
+	auth   *authorization.Authorizer
+	oauth  *oauth.Server
+	store  *profilestore.MemoryStore
+}
+
+func newTestService(t *testing.T) *testService {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	log := logrus.New()
+	store := profilestore.NewMemoryStore(ctx)
+	if err := store.SaveTenant(ctx, &models.Tenant{ID: otherTenant, Hosts: []string{otherHost}}); err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := authorization.LoadTenants(ctx, log, store, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocations, err := revocation.NewChecker(ctx, log, store, revocation.Config{TokenTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := authorization.Config{Issuer: "test", AccessTTL: time.Hour, RefreshTTL: time.Hour}
+	auth := authorization.New(log, nil, nil, store, store, revocations, authorization.NewKeyring(log, key), config).
+		WithTenants(tenants)
+	oauthServer := oauth.New(log, store, auth)
+	return &testService{
+		router: NewRouter(log, auth, store, nil, oauthServer, nil, "test", "test"),
+		auth:   auth,
+		oauth:  oauthServer,
+		store:  store,
+	}
+}
+
+// signIn adds a user of the tenant and returns the uuid and the access token of the service's own sign in.
+func (s *testService) signIn(t *testing.T, tenantID, phone string) (string, string) {
+	t.Helper()
+	ctx := context.Background()
+	user := models.User{UUID: uuid.New().String(), TenantID: tenantID, Phone: phone}
+	if err := s.store.UpsertUser(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := s.auth.IssueTokens(ctx, user.UUID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.UUID, tokens.AccessToken
+}
+
+// confidentialClient registers an OAuth client of the tenant and returns its id and secret.
+func (s *testService) confidentialClient(t *testing.T, tenantID string) (string, string) {
+	t.Helper()
+	client := models.OAuthClient{Name: "api", GrantTypes: []string{oauth.GrantClientCredentials}}
+	secret, err := s.oauth.CreateClient(common.WithTenant(context.Background(), tenantID), &client, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.ID, secret
+}
+
+func (s *testService) serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+// TestIntrospect checks that tokens are active to the clients of their tenant only.
+func TestIntrospect(t *testing.T) {
+	s := newTestService(t)
+	_, token := s.signIn(t, common.DefaultTenant, "+70000000001")
+	_, otherToken := s.signIn(t, otherTenant, "+70000000001")
+	_, revoked := s.signIn(t, common.DefaultTenant, "+70000000002")
+	if err := s.auth.Logout(context.Background(), revoked); err != nil {
+		t.Fatal(err)
+	}
+	clientID, secret := s.confidentialClient(t, common.DefaultTenant)
+	otherID, otherSecret := s.confidentialClient(t, otherTenant)
+	public := models.OAuthClient{Name: "app", GrantTypes: []string{oauth.GrantDeviceCode}}
+	if _, err := s.oauth.CreateClient(context.Background(), &public, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		host       string
+		clientID   string
+		secret     string
+		token      string
+		wantStatus int
+		wantActive bool
+		wantTenant string
+	}{
+		{"own tenant", "", clientID, secret, token, http.StatusOK, true, common.DefaultTenant},
+		{"other tenant at its host", otherHost, otherID, otherSecret, otherToken, http.StatusOK, true, otherTenant},
+		{"token of another tenant", "", clientID, secret, otherToken, http.StatusOK, false, ""},
+		{"token of the default tenant at another host", otherHost, otherID, otherSecret, token, http.StatusOK, false, ""},
+		{"revoked token", "", clientID, secret, revoked, http.StatusOK, false, ""},
+		{"garbage", "", clientID, secret, "token", http.StatusOK, false, ""},
+		{"client of another tenant", "", otherID, otherSecret, otherToken, http.StatusUnauthorized, false, ""},
+		{"wrong secret", "", clientID, "secret", token, http.StatusUnauthorized, false, ""},
+		{"public client", "", public.ID, "", token, http.StatusUnauthorized, false, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			form := url.Values{"token": {test.token}}
+			r := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.host != "" {
+				r.Host = test.host
+			}
+			r.SetBasicAuth(test.clientID, test.secret)
+			w := s.serve(r)
+			if w.Code != test.wantStatus {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, test.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var result models.Introspection
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Active != test.wantActive || result.Tid != test.wantTenant {
+				t.Fatalf("got active %t of tenant %q, want %t of %q", result.Active, result.Tid, test.wantActive, test.wantTenant)
+			}
+		})
+	}
+}
+
+// TestForward checks the answers of the forward auth, the headers telling who the user is replacing any the client
+// sent along.
+func TestForward(t *testing.T) {
+	s := newTestService(t)
+	user, token := s.signIn(t, common.DefaultTenant, "+70000000001")
+	_, revoked := s.signIn(t, common.DefaultTenant, "+70000000002")
+	if err := s.auth.Logout(context.Background(), revoked); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantChallenge string
+	}{
+		{"valid", "Bearer " + token, http.StatusOK, ""},
+		{"no token", "", http.StatusUnauthorized, "Bearer"},
+		{"basic", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, "Bearer"},
+		{"revoked", "Bearer " + revoked, http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"garbage", "Bearer token", http.StatusUnauthorized, `Bearer error="invalid_token"`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/auth/forward", nil)
+			r.Header.Set("Authorization", test.authorization)
+			w := s.serve(r)
+			if w.Code != test.wantStatus {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, test.wantStatus)
+			}
+			if challenge := w.Header().Get("WWW-Authenticate"); challenge != test.wantChallenge {
+				t.Fatalf("got challenge %q, want %q", challenge, test.wantChallenge)
+			}
+			if w.Code != http.StatusOK && w.Header().Get("X-User-Id") != "" {
+				t.Fatalf("got user %s of a denied request", w.Header().Get("X-User-Id"))
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/forward", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	spoofed := map[string]string{
+		"X-User-Id":    uuid.New().String(),
+		"X-User-Phone": "+70000000009",
+		"X-Scopes":     "users:impersonate",
+		"X-Client-Id":  "client",
+		"X-Actor-Id":   uuid.New().String(),
+		"X-Roles":      "admin",
+		"X-Tenant-Id":  common.DefaultTenant,
+	}
+	for key, value := range spoofed {
+		r.Header.Set(key, value)
+	}
+	w := s.serve(r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	want := map[string]string{
+		"X-User-Id":    user,
+		"X-User-Phone": "+70000000001",
+		"X-Tenant-Id":  common.DefaultTenant,
+	}
+	for key := range spoofed {
+		if values := w.Header().Values(key); len(values) != 1 || values[0] != want[key] {
+			t.Errorf("got %s %q, want %q", key, values, want[key])
+		}
+	}
+}
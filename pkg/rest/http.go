@@ -3,44 +3,112 @@ package rest
 import (
 	"compress/flate"
 	"context"
+	"crypto/x509"
 	"encoding/json"
 	"net/http"
 	"time"
 
+	"github.com/gerladeno/authorization-service/pkg/authorization"
 	"github.com/gerladeno/authorization-service/pkg/metrics"
 	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/gerladeno/authorization-service/pkg/oauth"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
 const gitURL = "https://github.com/gerladeno/authorization-service"
 
 type TokenProvider interface {
-	StartAuthentication(ctx context.Context, user *models.User) error
-	SignIn(ctx context.Context, user *models.User, code string) (string, error)
-	ParseToken(accessToken string) (string, error)
-	GetToken(uuid string) (string, error)
+	StartAuthentication(ctx context.Context, user *models.User, channel string) error
+	SignIn(ctx context.Context, user *models.User, code string) (*models.Tokens, error)
+	VerifyCode(ctx context.Context, user *models.User, code string) error
+	Refresh(ctx context.Context, refreshToken, clientID string) (*models.Tokens, error)
+	ParseToken(ctx context.Context, accessToken string) (string, error)
+	Identify(ctx context.Context, accessToken string) (*models.Identity, error)
+	Logout(ctx context.Context, accessToken string) error
+	LogoutAll(ctx context.Context, accessToken string) error
+	GetToken(ctx context.Context, uuid string) (string, error)
+	Introspect(ctx context.Context, token, hint string) (*models.Introspection, error)
+	JWKS(ctx context.Context) models.JSONWebKeySet
+	OpenIDConfiguration(ctx context.Context) models.OpenIDConfiguration
+	ResolveTenant(id, host string) (*models.Tenant, error)
+	RotateKeys(ctx context.Context) error
+	ReloadKeys(ctx context.Context) error
+}
+
+type APIClients interface {
+	Authenticate(ctx context.Context, id, secret, path string) (*models.APIClient, error)
+	AuthenticateSigned(
+		ctx context.Context, id, signature, nonce string, payload []byte, path string, expires time.Time,
+	) (*models.APIClient, error)
+	SigningKey(secret string) string
+	AuthenticateCertificate(ctx context.Context, cert *x509.Certificate, path string) (*models.APIClient, error)
+	Create(ctx context.Context, name string, endpoints []string) (*models.APIClient, string, error)
+	Rotate(ctx context.Context, id string) (string, error)
+	Disable(ctx context.Context, id string) error
+	List(ctx context.Context) ([]models.APIClient, error)
+}
+
+type OAuthServer interface {
+	Authorize(ctx context.Context, req *oauth.AuthorizationRequest) (*models.OAuthClient, error)
+	IssueCode(ctx context.Context, req *oauth.AuthorizationRequest, userUUID string) (string, error)
+	Token(ctx context.Context, req *oauth.TokenRequest) (*models.Tokens, error)
+	AuthenticateClient(ctx context.Context, id, secret string) error
+	AuthorizeDevice(ctx context.Context, clientID, clientSecret, scope string) (*models.DeviceAuthorization, error)
+	DeviceRequest(ctx context.Context, userCode string) (*models.OAuthClient, *models.DeviceCode, error)
+	ResolveDevice(ctx context.Context, userCode, userUUID string, approved bool) error
+	CreateClient(ctx context.Context, client *models.OAuthClient, confidential bool) (string, error)
+	ListClients(ctx context.Context) ([]models.OAuthClient, error)
+}
+
+type PolicyDecider interface {
+	Decide(req *models.PolicyRequest) models.Decision
+	Reload() error
 }
 
 type ProfileStore interface {
-	GetUser(ctx context.Context, phone string) (*models.User, error)
+	GetUser(ctx context.Context, tenantID, phone string) (*models.User, error)
+	GetUserByUUID(ctx context.Context, uuid string) (*models.User, error)
+	GetUserByUUIDUncached(ctx context.Context, uuid string) (*models.User, error)
 	UpsertUser(ctx context.Context, user *models.User) error
+	UpdateProfile(ctx context.Context, user *models.User, ifUpdated time.Time) error
+	SaveRole(ctx context.Context, role *models.Role) error
+	ListRoles(ctx context.Context, tenantID string) ([]models.Role, error)
+	DeleteRole(ctx context.Context, tenantID, name string) error
+	GetUserRoles(ctx context.Context, tenantID, uuid string) ([]models.Role, error)
+	AssignRole(ctx context.Context, tenantID, uuid, role string) error
+	UnassignRole(ctx context.Context, tenantID, uuid, role string) error
+	SaveTenant(ctx context.Context, tenant *models.Tenant) error
+	ListTenants(ctx context.Context) ([]models.Tenant, error)
 }
 
-func NewRouter(log *logrus.Logger, provider TokenProvider, store ProfileStore, host, version string) chi.Router {
-	handler := newHandler(log, provider, store)
+func NewRouter(
+	log *logrus.Logger,
+	provider TokenProvider,
+	store ProfileStore,
+	apiClients APIClients,
+	oauth OAuthServer,
+	policies PolicyDecider,
+	host, version string,
+) chi.Router {
+	handler := newHandler(log, provider, store, apiClients, oauth, policies)
 	r := chi.NewRouter()
 	r.Use(middleware.Recoverer)
 	r.Use(cors.AllowAll().Handler)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.StripSlashes)
+	r.Use(handler.tenant)
 	r.Use(middleware.NewCompressor(flate.DefaultCompression).Handler)
 	r.NotFound(notFoundHandler)
 	r.Get("/ping", pingHandler)
 	r.Get("/version", versionHandler(version))
+	r.Handle("/metrics", promhttp.Handler())
+	r.Get("/.well-known/jwks.json", handler.jwks)
+	r.Get("/.well-known/openid-configuration", handler.openIDConfiguration)
 	r.Group(func(r chi.Router) {
 		r.Use(metrics.NewPromMiddleware(host))
 		r.Use(middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: log, NoColor: true}))
@@ -50,17 +118,70 @@ func NewRouter(log *logrus.Logger, provider TokenProvider, store ProfileStore, h
 			r.Route("/v1", func(r chi.Router) {
 				r.Get("/authenticate", handler.authenticate)
 				r.Get("/signIn", handler.signIn)
+				r.Post("/refresh", handler.refresh)
 				r.Get("/verify", handler.verify)
 				r.Group(func(r chi.Router) {
 					r.Use(handler.auth)
-					// protected endpoints
+					r.Post("/logout", handler.logout)
+					r.Post("/logoutAll", handler.logoutAll)
+				})
+				// the profile is the user's own, read and changed by the user signed in to the service only, not
+				// by OAuth clients, which get what was consented to at /userinfo
+				r.Group(func(r chi.Router) {
+					r.Use(handler.requireScope())
+					r.Get("/me", handler.getMe)
+					r.Patch("/me", handler.patchMe)
 				})
 			})
 		})
+		r.HandleFunc("/auth/forward", handler.forward)
+		r.Get("/userinfo", handler.userinfo)
+		r.Post("/userinfo", handler.userinfo)
+		r.Route("/oauth", func(r chi.Router) {
+			r.Get("/authorize", handler.authorize)
+			r.Post("/authorize", handler.authorize)
+			r.Post("/token", handler.token)
+			r.Post("/device_authorization", handler.deviceAuthorization)
+			r.Get("/device", handler.device)
+			r.Post("/device", handler.device)
+			r.With(handler.clientAuth).Post("/introspect", handler.introspect)
+		})
+		roles := func(r chi.Router) {
+			r.Get("/roles", handler.listRoles)
+			r.Put("/roles/{name}", handler.saveRole)
+			r.Delete("/roles/{name}", handler.deleteRole)
+			r.Get("/users/{uuid}/roles", handler.listUserRoles)
+			r.Put("/users/{uuid}/roles/{name}", handler.assignRole)
+			r.Delete("/users/{uuid}/roles/{name}", handler.unassignRole)
+		}
+		r.Route("/admin/v1", func(r chi.Router) {
+			r.Use(handler.requireScope(authorization.ScopeRolesWrite))
+			r.Group(roles)
+		})
 		r.Route("/private", func(r chi.Router) {
-			r.Use(handler.customAuth)
+			r.Use(handler.apiClientAuth)
 			r.Route("/v1", func(r chi.Router) {
 				r.Get("/token/{uuid}", handler.getToken)
+				r.Post("/keys/rotate", handler.rotateKeys)
+				r.Post("/keys/reload", handler.reloadKeys)
+				r.Post("/authorize", handler.authorizeAction)
+				r.Post("/policies/reload", handler.reloadPolicies)
+				r.Group(func(r chi.Router) {
+					r.Use(defaultTenantOnly)
+					r.Get("/tenants", handler.listTenants)
+					r.Put("/tenants/{id}", handler.saveTenant)
+				})
+				r.Route("/clients", func(r chi.Router) {
+					r.Get("/", handler.listClients)
+					r.Post("/", handler.createClient)
+					r.Post("/{id}/rotate", handler.rotateClient)
+					r.Post("/{id}/disable", handler.disableClient)
+				})
+				r.Route("/oauth/clients", func(r chi.Router) {
+					r.Get("/", handler.listOAuthClients)
+					r.Post("/", handler.createOAuthClient)
+				})
+				r.Group(roles)
 			})
 		})
 	})
@@ -94,6 +215,14 @@ func writeErrResponse(w http.ResponseWriter, message string, status int) {
 	_ = json.NewEncoder(w).Encode(response) //nolint:errchkjson
 }
 
+// writeOAuthError responds in the RFC 6749 error format the OAuth endpoints use instead of JSONResponse.
+func writeOAuthError(w http.ResponseWriter, code string, status int) {
+	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code}) //nolint:errchkjson
+}
+
 type JSONResponse struct {
 	Data  interface{} `json:"data,omitempty"`
 	Meta  *Meta       `json:"meta,omitempty"`
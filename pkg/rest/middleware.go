@@ -1,61 +1,234 @@
 package rest
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
 )
 
 type idType string
 
-const idKey idType = `userID`
+const (
+	idKey       idType = `userID`
+	tokenKey    idType = `token`
+	clientKey   idType = `clientID`
+	identityKey idType = `identity`
+)
 
 func (h *handler) auth(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
+		token, ok := bearerToken(r)
+		if !ok {
 			writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		headerParts := strings.Split(authHeader, " ")
-		if len(headerParts) != 2 {
+		id, err := h.provider.ParseToken(r.Context(), token)
+		switch {
+		case err == nil:
+		case errors.Is(err, common.ErrInvalidAccessToken), errors.Is(err, common.ErrTokenRevoked):
 			writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
 			return
+		default:
+			h.log.Warnf("err parsing token: %v", err)
+			writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
 		}
-		if headerParts[0] != "Bearer" {
-			writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
+		ctx := context.WithValue(r.Context(), idKey, id)
+		r = r.WithContext(context.WithValue(ctx, tokenKey, token))
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// requireScope lets through requests bearing an access token of the service's own sign in granted all the scopes,
+// answering 401 if there is no valid token and 403 if a scope is missing. Tokens of OAuth clients are refused with
+// 403, their scopes are ones the user consented to and not the permissions of the user's roles, and so are
+// tokens of impersonation. The identity of the token and its user are put into the context. With no scopes any
+// such token is let through.
+func (h *handler) requireScope(scopes ...string) func(http.Handler) http.Handler {
+	challenge := `Bearer error="insufficient_scope"`
+	if len(scopes) > 0 {
+		challenge += fmt.Sprintf(`, scope=%q`, strings.Join(scopes, " "))
+	}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			identity, err := h.provider.Identify(r.Context(), token)
+			switch {
+			case err == nil:
+			case errors.Is(err, common.ErrInvalidAccessToken), errors.Is(err, common.ErrTokenRevoked):
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			default:
+				h.log.Warnf("err identifying token: %v", err)
+				writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if identity.ClientID != "" || identity.Actor != "" || identity.UUID == "" {
+				w.Header().Set("WWW-Authenticate", challenge)
+				writeErrResponse(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			for _, scope := range scopes {
+				if !common.Contains(identity.Scopes, scope) {
+					w.Header().Set("WWW-Authenticate", challenge)
+					writeErrResponse(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+			}
+			ctx := context.WithValue(r.Context(), identityKey, identity)
+			ctx = context.WithValue(ctx, idKey, identity.UUID)
+			r = r.WithContext(context.WithValue(ctx, tokenKey, token))
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" || headerParts[1] == "" {
+		return "", false
+	}
+	return headerParts[1], true
+}
+
+// clientAuth authenticates confidential OAuth clients by HTTP Basic or client_id and client_secret form values.
+func (h *handler) clientAuth(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok {
+			id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+		}
+		if id == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			writeOAuthError(w, "invalid_client", http.StatusUnauthorized)
 			return
 		}
-		id, err := h.provider.ParseToken(headerParts[1])
+		err := h.oauth.AuthenticateClient(r.Context(), id, secret)
 		switch {
 		case err == nil:
-		case errors.Is(err, common.ErrInvalidAccessToken):
-			writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
+		case errors.Is(err, common.ErrInvalidClient):
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			writeOAuthError(w, "invalid_client", http.StatusUnauthorized)
 			return
 		default:
-			h.log.Warnf("err parsing token: %v", err)
-			writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+			h.log.Warnf("err authenticating client: %v", err)
+			writeOAuthError(w, "server_error", http.StatusInternalServerError)
 			return
 		}
-		r = r.WithContext(context.WithValue(r.Context(), idKey, id))
+		r = r.WithContext(context.WithValue(r.Context(), clientKey, id))
 		next.ServeHTTP(w, r)
 	}
 	return http.HandlerFunc(fn)
 }
 
-func (h *handler) customAuth(next http.Handler) http.Handler {
-	var fn http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
-		secret := r.Header.Get("key")
-		if secret != "secret_key" {
+const (
+	hmacScheme = "HMAC-SHA256"
+	// hmacMaxSkew is how far X-Timestamp of a signed request may be from now.
+	hmacMaxSkew = 5 * time.Minute
+	// hmacMaxBody is the largest body of a signed request, it's read whole to be hashed.
+	hmacMaxBody = 1 << 20
+	// hmacMaxNonce is the longest X-Nonce of a signed request.
+	hmacMaxNonce = 64
+)
+
+// apiClientAuth lets in the api clients allowed to call the endpoint. Callers with an allowed
+// client certificate are let in by it, others authenticate either with HTTP Basic or by signing the request:
+//
+//	Authorization: HMAC-SHA256 Credential=<client id>, Signature=<hex hmac>
+//	X-Timestamp: <unix seconds>
+//	X-Nonce: <unique per request>
+//
+// The signature covers method, request uri, timestamp, nonce and hex sha256 of the body, separated by newlines.
+func (h *handler) apiClientAuth(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		client, err := h.certificateClient(r)
+		if errors.Is(err, common.ErrInvalidClient) {
+			if id, secret, ok := r.BasicAuth(); ok {
+				client, err = h.apiClients.Authenticate(r.Context(), id, secret, r.URL.Path)
+			} else {
+				client, err = h.authenticateSigned(w, r)
+			}
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, common.ErrInvalidClient):
+			w.Header().Set("WWW-Authenticate", `Basic realm="private"`)
 			writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
 			return
+		case errors.Is(err, common.ErrClientForbidden):
+			writeErrResponse(w, "Forbidden", http.StatusForbidden)
+			return
+		default:
+			h.log.Warnf("err authenticating api client: %v", err)
+			writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), clientKey, client.ID))
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// certificateClient returns the client of the verified client certificate, common.ErrInvalidClient if there is
+// none or it isn't allowed.
+func (h *handler) certificateClient(r *http.Request) (*models.APIClient, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, common.ErrInvalidClient
+	}
+	return h.apiClients.AuthenticateCertificate(r.Context(), r.TLS.VerifiedChains[0][0], r.URL.Path)
+}
+
+func (h *handler) authenticateSigned(w http.ResponseWriter, r *http.Request) (*models.APIClient, error) {
+	scheme, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if scheme != hmacScheme {
+		return nil, common.ErrInvalidClient
+	}
+	var id, signature string
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch key {
+		case "Credential":
+			id = value
+		case "Signature":
+			signature = value
 		}
-		writeErrResponse(w, "Unauthorized", http.StatusUnauthorized)
-		return
-		//next.ServeHTTP(w, r)
 	}
-	return fn
+	timestamp, nonce := r.Header.Get("X-Timestamp"), r.Header.Get("X-Nonce")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if id == "" || signature == "" || nonce == "" || len(nonce) > hmacMaxNonce || err != nil {
+		return nil, common.ErrInvalidClient
+	}
+	signedAt := time.Unix(unix, 0)
+	if skew := time.Since(signedAt); skew > hmacMaxSkew || skew < -hmacMaxSkew {
+		return nil, common.ErrInvalidClient
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, hmacMaxBody))
+	if err != nil {
+		return nil, common.ErrInvalidClient
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	bodyHash := sha256.Sum256(body)
+	payload := strings.Join([]string{r.Method, r.URL.RequestURI(), timestamp, nonce, hex.EncodeToString(bodyHash[:])}, "\n")
+	// the nonce is kept while a request signed with it may be let in
+	return h.apiClients.AuthenticateSigned(r.Context(), id, signature, nonce, []byte(payload), r.URL.Path,
+		signedAt.Add(hmacMaxSkew))
 }
@@ -0,0 +1,371 @@
+package rest
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/authorization"
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/gerladeno/authorization-service/pkg/oauth"
+)
+
+//go:embed templates/*.html
+var templates embed.FS
+
+var (
+	authorizeTemplate = template.Must(template.ParseFS(templates, "templates/authorize.html"))
+	deviceTemplate    = template.Must(template.ParseFS(templates, "templates/device.html"))
+)
+
+// signInForm is the phone sign in part of the pages, Phone is set once the code is sent.
+type signInForm struct {
+	Phone string
+	Error string
+}
+
+type authorizePage struct {
+	signInForm
+	Client  string
+	Request *oauth.AuthorizationRequest
+	Scopes  []string
+	// Fatal is shown instead of the form when the request can't be redirected back.
+	Fatal string
+}
+
+// authorize serves the sign in and consent page. The user enters the phone, then the code sent
+// and approves the request, or denies it, after which they are redirected back to the client.
+func (h *handler) authorize(w http.ResponseWriter, r *http.Request) {
+	req := oauth.AuthorizationRequest{
+		ResponseType:        r.FormValue("response_type"),
+		ClientID:            r.FormValue("client_id"),
+		RedirectURI:         r.FormValue("redirect_uri"),
+		Scope:               r.FormValue("scope"),
+		State:               r.FormValue("state"),
+		CodeChallenge:       r.FormValue("code_challenge"),
+		CodeChallengeMethod: r.FormValue("code_challenge_method"),
+		Nonce:               r.FormValue("nonce"),
+	}
+	client, err := h.oauth.Authorize(r.Context(), &req)
+	var oauthErr *oauth.Error
+	switch {
+	case err == nil:
+	case errors.As(err, &oauthErr):
+		redirectBack(w, r, &req, url.Values{"error": {oauthErr.Code}, "error_description": {oauthErr.Description}})
+		return
+	case errors.Is(err, common.ErrInvalidClient), errors.Is(err, common.ErrInvalidRedirectURI):
+		h.renderPage(w, authorizeTemplate, http.StatusBadRequest, &authorizePage{Fatal: "Unknown client or redirect uri"})
+		return
+	default:
+		h.log.Warnf("err validating authorization request: %v", err)
+		h.renderPage(w, authorizeTemplate, http.StatusInternalServerError, &authorizePage{Fatal: "Internal server error"})
+		return
+	}
+	page := authorizePage{Client: client.Name, Request: &req, Scopes: strings.Fields(req.Scope)}
+	phone := r.PostFormValue("phone")
+	switch r.PostFormValue("action") {
+	case "send":
+		err = h.sendCode(r, &page.signInForm, phone)
+	case "allow":
+		h.approve(w, r, &page, phone, r.PostFormValue("code"))
+		return
+	case "deny":
+		redirectBack(w, r, &req, url.Values{"error": {"access_denied"}})
+		return
+	}
+	if err != nil {
+		h.log.Warnf("err initiating authentication: %v", err)
+		h.renderPage(w, authorizeTemplate, http.StatusInternalServerError, &authorizePage{Fatal: "Internal server error"})
+		return
+	}
+	h.renderPage(w, authorizeTemplate, http.StatusOK, &page)
+}
+
+func (h *handler) approve(w http.ResponseWriter, r *http.Request, page *authorizePage, phone, code string) {
+	user, err := h.signInUser(r, &page.signInForm, phone, code)
+	if err != nil {
+		h.log.Warnf("err signing in: %v", err)
+		h.renderPage(w, authorizeTemplate, http.StatusInternalServerError, &authorizePage{Fatal: "Internal server error"})
+		return
+	}
+	if user == nil {
+		h.renderPage(w, authorizeTemplate, http.StatusOK, page)
+		return
+	}
+	authCode, err := h.oauth.IssueCode(r.Context(), page.Request, user.UUID)
+	if err != nil {
+		h.log.Warnf("err issuing authorization code: %v", err)
+		h.renderPage(w, authorizeTemplate, http.StatusInternalServerError, &authorizePage{Fatal: "Internal server error"})
+		return
+	}
+	redirectBack(w, r, page.Request, url.Values{"code": {authCode}})
+}
+
+// sendCode sends the sign in code to the phone, mistakes of the user are put into the form.
+func (h *handler) sendCode(r *http.Request, form *signInForm, phone string) error {
+	user, err := h.getOrNewUser(r, phone)
+	if err == nil {
+		err = h.provider.StartAuthentication(r.Context(), user, "")
+	}
+	switch {
+	case err == nil:
+		form.Phone = phone
+	case errors.Is(err, common.ErrInvalidPhoneNumber):
+		form.Error = "Invalid phone number"
+	case errors.Is(err, common.ErrResendTooSoon):
+		form.Phone = phone
+		form.Error = "Code already sent, try again later"
+	default:
+		return err
+	}
+	return nil
+}
+
+// signInUser verifies the code sent to the phone and saves the user. The user is nil if they made
+// a mistake, which is put into the form.
+func (h *handler) signInUser(r *http.Request, form *signInForm, phone, code string) (*models.User, error) {
+	user, err := h.getOrNewUser(r, phone)
+	if err == nil {
+		err = h.provider.VerifyCode(r.Context(), user, code)
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidCode), errors.Is(err, common.ErrUnauthenticated):
+		form.Phone = phone
+		form.Error = "Invalid code"
+	case errors.Is(err, common.ErrCodeExpired):
+		form.Error = "Code expired, request a new one"
+	case errors.Is(err, common.ErrAttemptsExhausted):
+		form.Error = "Too many attempts, request a new code"
+	case errors.Is(err, common.ErrChallengeNotFound), errors.Is(err, common.ErrInvalidPhoneNumber):
+		form.Error = "Request a code first"
+	default:
+		return nil, fmt.Errorf("err verifying code: %w", err)
+	}
+	if form.Error != "" {
+		return nil, nil
+	}
+	if err = h.store.UpsertUser(r.Context(), user); err != nil {
+		return nil, fmt.Errorf("err saving user: %w", err)
+	}
+	return user, nil
+}
+
+func (h *handler) renderPage(w http.ResponseWriter, tmpl *template.Template, status int, page interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, page); err != nil {
+		h.log.Warnf("err rendering %s page: %v", tmpl.Name(), err)
+	}
+}
+
+// redirectBack sends the user back to the client with the params and the state of the request.
+func redirectBack(w http.ResponseWriter, r *http.Request, req *oauth.AuthorizationRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectTo)
+	if err != nil {
+		writeErrResponse(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	query := u.Query()
+	for key, values := range params {
+		if values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// oauthClient returns the credentials of an OAuth client calling by HTTP Basic or client_id and client_secret
+// form values.
+func oauthClient(r *http.Request) (string, string) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	return id, secret
+}
+
+// token is the OAuth token endpoint.
+func (h *handler) token(w http.ResponseWriter, r *http.Request) {
+	id, secret := oauthClient(r)
+	tokens, err := h.oauth.Token(r.Context(), &oauth.TokenRequest{
+		GrantType:          r.PostFormValue("grant_type"),
+		ClientID:           id,
+		ClientSecret:       secret,
+		Code:               r.PostFormValue("code"),
+		RedirectURI:        r.PostFormValue("redirect_uri"),
+		CodeVerifier:       r.PostFormValue("code_verifier"),
+		RefreshToken:       r.PostFormValue("refresh_token"),
+		Scope:              r.PostFormValue("scope"),
+		Audience:           r.PostFormValue("audience"),
+		DeviceCode:         r.PostFormValue("device_code"),
+		SubjectToken:       r.PostFormValue("subject_token"),
+		SubjectTokenType:   r.PostFormValue("subject_token_type"),
+		RequestedTokenType: r.PostFormValue("requested_token_type"),
+		RequestedSubject:   r.PostFormValue("requested_subject"),
+	})
+	var oauthErr *oauth.Error
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidClient):
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		writeOAuthError(w, "invalid_client", http.StatusUnauthorized)
+		return
+	case errors.As(err, &oauthErr):
+		h.log.Debug(err)
+		writeOAuthError(w, oauthErr.Code, http.StatusBadRequest)
+		return
+	default:
+		h.log.Warnf("err issuing oauth tokens: %v", err)
+		writeOAuthError(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(oauthTokensResponse(tokens)) //nolint:errchkjson
+}
+
+func oauthTokensResponse(tokens *models.Tokens) map[string]interface{} {
+	result := map[string]interface{}{
+		"access_token": tokens.AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(tokens.AccessExpires).Seconds()),
+	}
+	if tokens.RefreshToken != "" {
+		result["refresh_token"] = tokens.RefreshToken
+	}
+	if tokens.Scope != "" {
+		result["scope"] = tokens.Scope
+	}
+	if tokens.IDToken != "" {
+		result["id_token"] = tokens.IDToken
+	}
+	if tokens.IssuedTokenType != "" {
+		result["issued_token_type"] = tokens.IssuedTokenType
+	}
+	return result
+}
+
+// userinfo is the OpenID Connect userinfo endpoint. Tokens issued to OAuth clients need the openid scope
+// and the phone scope to see the phone, tokens of the service's own sign in see everything.
+func (h *handler) userinfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeOAuthError(w, "invalid_token", http.StatusUnauthorized)
+		return
+	}
+	identity, err := h.provider.Identify(r.Context(), token)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidAccessToken), errors.Is(err, common.ErrTokenRevoked):
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(w, "invalid_token", http.StatusUnauthorized)
+		return
+	default:
+		h.log.Warnf("err identifying token: %v", err)
+		writeOAuthError(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	if identity.UUID == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(w, "invalid_token", http.StatusUnauthorized)
+		return
+	}
+	firstParty := identity.ClientID == ""
+	if !firstParty && !common.Contains(identity.Scopes, models.ScopeOpenID) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		writeOAuthError(w, "insufficient_scope", http.StatusForbidden)
+		return
+	}
+	result := map[string]interface{}{"sub": identity.UUID}
+	if firstParty || common.Contains(identity.Scopes, models.ScopePhone) {
+		result["phone_number"] = identity.Phone
+		result["phone_number_verified"] = true
+	}
+	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(result) //nolint:errchkjson
+}
+
+// openIDConfiguration serves the discovery document as is, not wrapped into JSONResponse.
+func (h *handler) openIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(authorization.JWKSMaxAge.Seconds())))
+	_ = json.NewEncoder(w).Encode(h.provider.OpenIDConfiguration(r.Context())) //nolint:errchkjson
+}
+
+func (h *handler) listOAuthClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.oauth.ListClients(r.Context())
+	if err != nil {
+		h.log.Warnf("err listing oauth clients: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	result := make([]map[string]interface{}, 0, len(clients))
+	for i := range clients {
+		result = append(result, oauthClientResponse(&clients[i]))
+	}
+	writeResponse(w, result)
+}
+
+func (h *handler) createOAuthClient(w http.ResponseWriter, r *http.Request) {
+	client := models.OAuthClient{
+		Name:         r.FormValue("name"),
+		RedirectURIs: r.Form["redirectUri"],
+		Scopes:       r.Form["scope"],
+		GrantTypes:   r.Form["grantType"],
+		Audiences:    r.Form["audience"],
+	}
+	if client.Name == "" {
+		writeErrResponse(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	secret, err := h.oauth.CreateClient(r.Context(), &client, r.FormValue("confidential") == "true")
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidRedirectURI):
+		writeErrResponse(w, "Invalid redirect uri", http.StatusBadRequest)
+		return
+	case errors.Is(err, common.ErrInvalidClientMetadata):
+		writeErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
+		h.log.Warnf("err creating oauth client: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	result := oauthClientResponse(&client)
+	if secret != "" {
+		result["secret"] = secret
+	}
+	writeResponse(w, result)
+}
+
+func oauthClientResponse(client *models.OAuthClient) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           client.ID,
+		"name":         client.Name,
+		"redirectUris": client.RedirectURIs,
+		"scopes":       client.Scopes,
+		"grantTypes":   client.GrantTypes,
+		"audiences":    client.Audiences,
+		"confidential": client.SecretHash != "",
+		"created":      client.Created.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,91 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+const maxAuthorizeBody = 64 << 10
+
+type authorizeRequest struct {
+	Token    string            `json:"token"`
+	Action   string            `json:"action"`
+	Resource models.Attributes `json:"resource"`
+	// Subject are the attributes only the caller knows, such as the organization. The token ones win.
+	Subject models.Attributes `json:"subject"`
+}
+
+// authorizeAction decides whether the token holder may perform the action on the resource. Invalid tokens
+// are denied rather than rejected, the caller is the api client, not the token holder.
+func (h *handler) authorizeAction(w http.ResponseWriter, r *http.Request) {
+	var req authorizeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAuthorizeBody)).Decode(&req); err != nil {
+		writeErrResponse(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" || req.Action == "" {
+		writeErrResponse(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	identity, err := h.provider.Identify(r.Context(), req.Token)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrInvalidAccessToken), errors.Is(err, common.ErrTokenRevoked):
+		writeResponse(w, models.Decision{Allow: false, Reasons: []string{"invalid token"}})
+		return
+	default:
+		h.log.Warnf("err identifying token: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	decision := h.policies.Decide(&models.PolicyRequest{
+		Subject:  subjectAttributes(identity, req.Subject),
+		Action:   req.Action,
+		Resource: req.Resource,
+	})
+	writeResponse(w, decision)
+}
+
+func (h *handler) reloadPolicies(w http.ResponseWriter, _ *http.Request) {
+	if err := h.policies.Reload(); err != nil {
+		h.log.Warnf("err reloading policies: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+// subjectAttributes leaves out the empty token attributes, conditions on them shouldn't hold.
+func subjectAttributes(identity *models.Identity, extra models.Attributes) models.Attributes {
+	subject := make(models.Attributes, len(extra)+7)
+	for name, v := range extra {
+		subject[name] = v
+	}
+	for name, v := range map[string]string{
+		"uuid":      identity.UUID,
+		"phone":     identity.Phone,
+		"client_id": identity.ClientID,
+		"actor":     identity.Actor,
+		"tenant":    identity.TenantID,
+	} {
+		if v == "" {
+			delete(subject, name)
+		} else {
+			subject[name] = v
+		}
+	}
+	subject["scopes"] = nonNil(identity.Scopes)
+	subject["roles"] = nonNil(identity.Roles)
+	return subject
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
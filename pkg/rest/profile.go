@@ -0,0 +1,219 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+const (
+	maxProfileBody     = 64 << 10
+	maxProfileMetadata = 16 << 10
+	maxProfileName     = 256
+	maxProfileURL      = 2048
+	// maxProfileEmail is the longest address RFC 5321 lets through.
+	maxProfileEmail = 254
+)
+
+// reLocale is a BCP 47 language tag, as en or pt-BR.
+var reLocale = regexp.MustCompile(`^[a-zA-Z]{2,8}(-[a-zA-Z0-9]{1,8})*$`)
+
+// getMe responds with the profile of the user of the token, tagged with its update time for PATCH to send back
+// in If-Match. A request with the current tag in If-None-Match gets 304.
+func (h *handler) getMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.me(w, r)
+	if !ok {
+		return
+	}
+	etag := profileETag(user)
+	w.Header().Set("ETag", etag)
+	if matchETag(r.Header.Get("If-None-Match"), etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeResponse(w, profileResponse(user))
+}
+
+// patchMe sets the profile fields present in the JSON body, null clears them. The profile isn't saved if it was
+// updated since it was read, by the tag in If-Match if there is one or since the request started otherwise.
+func (h *handler) patchMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.me(w, r)
+	if !ok {
+		return
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !matchETag(ifMatch, profileETag(user), false) {
+		writeErrResponse(w, "Profile was modified, get it again", http.StatusPreconditionFailed)
+		return
+	}
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProfileBody)).Decode(&patch); err != nil {
+		writeErrResponse(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	read := user.Updated
+	if problem := applyProfilePatch(user, patch); problem != "" {
+		writeErrResponse(w, problem, http.StatusBadRequest)
+		return
+	}
+	err := h.store.UpdateProfile(r.Context(), user, read)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrUserModified):
+		writeErrResponse(w, "Profile was modified, get it again", http.StatusPreconditionFailed)
+		return
+	case errors.Is(err, common.ErrUserNotFound):
+		writeErrResponse(w, "User not found", http.StatusNotFound)
+		return
+	default:
+		h.log.Warnf("err updating profile: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", profileETag(user))
+	writeResponse(w, profileResponse(user))
+}
+
+// me finds the user the middleware let in, responding with an error if it can't. The user is read past the cache,
+// as of another instance it may be stale and so would the tag If-Match is compared with.
+func (h *handler) me(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, _ := r.Context().Value(idKey).(string)
+	user, err := h.store.GetUserByUUIDUncached(r.Context(), id)
+	switch {
+	case err == nil:
+		return user, true
+	case errors.Is(err, common.ErrUserNotFound):
+		writeErrResponse(w, "User not found", http.StatusNotFound)
+	default:
+		h.log.Warnf("err finding user of token: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+	}
+	return nil, false
+}
+
+// applyProfilePatch sets the fields of the patch on the user, returning what's wrong with them if anything is.
+func applyProfilePatch(user *models.User, patch map[string]json.RawMessage) string {
+	for field, value := range patch {
+		if field == "metadata" {
+			metadata, problem := profileMetadata(value)
+			if problem != "" {
+				return problem
+			}
+			user.Metadata = metadata
+			continue
+		}
+		var dest *string
+		switch field {
+		case "name":
+			dest = &user.Name
+		case "email":
+			dest = &user.Email
+		case "avatarUrl":
+			dest = &user.AvatarURL
+		case "locale":
+			dest = &user.Locale
+		case "timeZone":
+			dest = &user.TimeZone
+		default:
+			return "Field " + field + " can't be changed"
+		}
+		// null unmarshals to no change, it clears the field here
+		*dest = ""
+		if err := json.Unmarshal(value, dest); err != nil {
+			return "Invalid " + field
+		}
+	}
+	return validateProfile(user)
+}
+
+// profileMetadata accepts a JSON object or null for an empty one.
+func profileMetadata(value json.RawMessage) (json.RawMessage, string) {
+	if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+		return json.RawMessage("{}"), ""
+	}
+	if len(value) > maxProfileMetadata {
+		return nil, fmt.Sprintf("Metadata is longer than %d bytes", maxProfileMetadata)
+	}
+	var object map[string]json.RawMessage
+	var compact bytes.Buffer
+	if err := json.Unmarshal(value, &object); err != nil || object == nil || json.Compact(&compact, value) != nil {
+		return nil, "Metadata must be an object"
+	}
+	return compact.Bytes(), ""
+}
+
+func validateProfile(user *models.User) string {
+	if utf8.RuneCountInString(user.Name) > maxProfileName || strings.IndexFunc(user.Name, unicode.IsControl) >= 0 {
+		return "Invalid name"
+	}
+	if user.Email != "" {
+		address, err := mail.ParseAddress(user.Email)
+		if err != nil || address.Address != user.Email || len(user.Email) > maxProfileEmail {
+			return "Invalid email"
+		}
+	}
+	if user.AvatarURL != "" {
+		u, err := url.Parse(user.AvatarURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" ||
+			len(user.AvatarURL) > maxProfileURL {
+			return "Invalid avatarUrl"
+		}
+	}
+	if user.Locale != "" && !reLocale.MatchString(user.Locale) {
+		return "Invalid locale"
+	}
+	if user.TimeZone != "" {
+		// Local is whatever the server runs in, not a zone of the user
+		if _, err := time.LoadLocation(user.TimeZone); err != nil || user.TimeZone == "Local" {
+			return "Invalid timeZone"
+		}
+	}
+	return ""
+}
+
+// profileETag changes with every update of the user, sign ins included.
+func profileETag(user *models.User) string {
+	return `"` + strconv.FormatInt(user.Updated.UnixNano(), 36) + `"`
+}
+
+// matchETag reports whether the If-Match or If-None-Match header lists the tag. If-None-Match compares weakly,
+// as W/ tags match too, If-Match strongly as RFC 9110 has it.
+func matchETag(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func profileResponse(user *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"uuid":      user.UUID,
+		"phone":     user.Phone,
+		"name":      user.Name,
+		"email":     user.Email,
+		"avatarUrl": user.AvatarURL,
+		"locale":    user.Locale,
+		"timeZone":  user.TimeZone,
+		"metadata":  user.Metadata,
+		"created":   user.Created.Format(time.RFC3339),
+		"updated":   user.Updated.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,181 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// role names and permissions end up in space separated scopes
+var reRoleName = regexp.MustCompile(`^[a-zA-Z0-9_.:-]{1,64}$`)
+
+// Roles belong to a tenant, the one of the token under /admin/v1 and the one the request is served for under
+// /private/v1.
+
+func (h *handler) listRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.store.ListRoles(r.Context(), rolesTenant(r))
+	if err != nil {
+		h.log.Warnf("err listing roles: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, rolesResponse(roles))
+}
+
+// saveRole creates the role or replaces its description and permissions.
+func (h *handler) saveRole(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	role := models.Role{
+		TenantID:    rolesTenant(r),
+		Name:        chi.URLParam(r, "name"),
+		Description: r.FormValue("description"),
+		Permissions: r.Form["permission"],
+		Created:     now,
+		Updated:     now,
+	}
+	if !reRoleName.MatchString(role.Name) {
+		writeErrResponse(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	for _, permission := range role.Permissions {
+		if !reRoleName.MatchString(permission) {
+			writeErrResponse(w, "Invalid permission "+permission, http.StatusBadRequest)
+			return
+		}
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	if err := h.store.SaveRole(r.Context(), &role); err != nil {
+		h.log.Warnf("err saving role: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, roleResponse(&role))
+}
+
+func (h *handler) deleteRole(w http.ResponseWriter, r *http.Request) {
+	err := h.store.DeleteRole(r.Context(), rolesTenant(r), chi.URLParam(r, "name"))
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrRoleNotFound):
+		writeErrResponse(w, "Role not found", http.StatusNotFound)
+		return
+	default:
+		h.log.Warnf("err deleting role: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+func (h *handler) listUserRoles(w http.ResponseWriter, r *http.Request) {
+	tenantID, id, ok := h.roleUser(w, r)
+	if !ok {
+		return
+	}
+	roles, err := h.store.GetUserRoles(r.Context(), tenantID, id)
+	if err != nil {
+		h.log.Warnf("err getting user roles: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, rolesResponse(roles))
+}
+
+// assignRole gives the role to the user, it's granted to tokens from the next sign in or refresh on.
+func (h *handler) assignRole(w http.ResponseWriter, r *http.Request) {
+	tenantID, id, ok := h.roleUser(w, r)
+	if !ok {
+		return
+	}
+	err := h.store.AssignRole(r.Context(), tenantID, id, chi.URLParam(r, "name"))
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrRoleNotFound):
+		writeErrResponse(w, "Role not found", http.StatusNotFound)
+		return
+	default:
+		h.log.Warnf("err assigning role: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+func (h *handler) unassignRole(w http.ResponseWriter, r *http.Request) {
+	tenantID, id, ok := h.roleUser(w, r)
+	if !ok {
+		return
+	}
+	err := h.store.UnassignRole(r.Context(), tenantID, id, chi.URLParam(r, "name"))
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrRoleNotFound):
+		writeErrResponse(w, "Role not assigned", http.StatusNotFound)
+		return
+	default:
+		h.log.Warnf("err unassigning role: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, "Ok")
+}
+
+// roleUser returns the tenant of the roles and the user of the url, responding with 404 if the user is of
+// another tenant.
+func (h *handler) roleUser(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	tenantID, id := rolesTenant(r), chi.URLParam(r, "uuid")
+	if !isValidUUID(id) {
+		writeErrResponse(w, "Bad request", http.StatusBadRequest)
+		return "", "", false
+	}
+	user, err := h.store.GetUserByUUID(r.Context(), id)
+	switch {
+	case err == nil && userTenant(user) == tenantID:
+		return tenantID, id, true
+	case err == nil, errors.Is(err, common.ErrUserNotFound):
+		writeErrResponse(w, "User not found", http.StatusNotFound)
+	default:
+		h.log.Warnf("err getting user: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+	}
+	return "", "", false
+}
+
+func rolesTenant(r *http.Request) string {
+	if identity, ok := r.Context().Value(identityKey).(*models.Identity); ok {
+		return identity.TenantID
+	}
+	return requestTenant(r)
+}
+
+func userTenant(user *models.User) string {
+	if user.TenantID == "" {
+		return common.DefaultTenant
+	}
+	return user.TenantID
+}
+
+func rolesResponse(roles []models.Role) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(roles))
+	for i := range roles {
+		result = append(result, roleResponse(&roles[i]))
+	}
+	return result
+}
+
+func roleResponse(role *models.Role) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        role.Name,
+		"description": role.Description,
+		"permissions": role.Permissions,
+		"created":     role.Created.Format(time.RFC3339),
+		"updated":     role.Updated.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,112 @@
+package rest
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// tenant ids name the directories of their signing keys
+var reTenantID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// tenant resolves the tenant the request is served for by the host or the X-Tenant-Id header and puts it
+// into the context, see common.TenantFromContext.
+func (h *handler) tenant(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		tenant, err := h.provider.ResolveTenant(r.Header.Get("X-Tenant-Id"), host)
+		switch {
+		case err == nil:
+		case errors.Is(err, common.ErrTenantNotFound):
+			writeErrResponse(w, "Unknown tenant", http.StatusBadRequest)
+			return
+		case errors.Is(err, common.ErrTenantMismatch):
+			writeErrResponse(w, "Tenant not served at the host", http.StatusBadRequest)
+			return
+		default:
+			h.log.Warnf("err resolving tenant: %v", err)
+			writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(common.WithTenant(r.Context(), tenant.ID)))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// defaultTenantOnly lets in the requests of the default tenant only, the clients of other tenants can't manage
+// tenants.
+func defaultTenantOnly(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if requestTenant(r) != common.DefaultTenant {
+			writeErrResponse(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func requestTenant(r *http.Request) string {
+	return common.RequestTenant(r.Context())
+}
+
+func (h *handler) listTenants(w http.ResponseWriter, r *http.Request) {
+	tenants, err := h.store.ListTenants(r.Context())
+	if err != nil {
+		h.log.Warnf("err listing tenants: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	result := make([]map[string]interface{}, 0, len(tenants))
+	for i := range tenants {
+		result = append(result, tenantResponse(&tenants[i]))
+	}
+	writeResponse(w, result)
+}
+
+// saveTenant creates the tenant or replaces its name, hosts and issuer. Changes are served once the tenants
+// are reloaded.
+func (h *handler) saveTenant(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	tenant := models.Tenant{
+		ID:      chi.URLParam(r, "id"),
+		Name:    r.FormValue("name"),
+		Hosts:   r.Form["host"],
+		Issuer:  r.FormValue("issuer"),
+		Created: now,
+		Updated: now,
+	}
+	if !reTenantID.MatchString(tenant.ID) {
+		writeErrResponse(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if tenant.Hosts == nil {
+		tenant.Hosts = []string{}
+	}
+	if err := h.store.SaveTenant(r.Context(), &tenant); err != nil {
+		h.log.Warnf("err saving tenant: %v", err)
+		writeErrResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, tenantResponse(&tenant))
+}
+
+func tenantResponse(tenant *models.Tenant) map[string]interface{} {
+	return map[string]interface{}{
+		"id":      tenant.ID,
+		"name":    tenant.Name,
+		"hosts":   tenant.Hosts,
+		"issuer":  tenant.Issuer,
+		"created": tenant.Created.Format(time.RFC3339),
+		"updated": tenant.Updated.Format(time.RFC3339),
+	}
+}
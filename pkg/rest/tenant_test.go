@@ -0,0 +1,77 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+)
+
+// TestTenantResolution checks that the tenant is told by the host, by the header at hosts of no tenant,
+// and that tokens are accepted by their tenant only.
+func TestTenantResolution(t *testing.T) {
+	s := newTestService(t)
+	_, token := s.signIn(t, common.DefaultTenant, "+70000000001")
+	_, otherToken := s.signIn(t, otherTenant, "+70000000001")
+
+	for _, test := range []struct {
+		name       string
+		host       string
+		tenantID   string
+		token      string
+		wantStatus int
+	}{
+		{"default", "", "", token, http.StatusOK},
+		{"default by header", "", common.DefaultTenant, token, http.StatusOK},
+		{"other by header", "", otherTenant, otherToken, http.StatusOK},
+		{"other by host", otherHost, "", otherToken, http.StatusOK},
+		{"other by host in upper case with a port", "OTHER.example.com:8080", "", otherToken, http.StatusOK},
+		{"other by host and header", otherHost, otherTenant, otherToken, http.StatusOK},
+		{"header of another tenant than the host's", otherHost, common.DefaultTenant, token, http.StatusBadRequest},
+		{"unknown by header", "", "unknown", token, http.StatusBadRequest},
+		{"token of another tenant", "", "", otherToken, http.StatusUnauthorized},
+		{"token of another tenant by header", "", otherTenant, token, http.StatusUnauthorized},
+		{"token of another tenant by host", otherHost, "", token, http.StatusUnauthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/auth/forward", nil)
+			if test.host != "" {
+				r.Host = test.host
+			}
+			if test.tenantID != "" {
+				r.Header.Set("X-Tenant-Id", test.tenantID)
+			}
+			r.Header.Set("Authorization", "Bearer "+test.token)
+			w := s.serve(r)
+			if w.Code != test.wantStatus {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, test.wantStatus)
+			}
+		})
+	}
+}
+
+// TestDefaultTenantOnly checks that the tenants are managed by the clients of the default tenant only.
+func TestDefaultTenantOnly(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeResponse(w, "Ok")
+	})
+	for _, test := range []struct {
+		tenantID   string
+		wantStatus int
+	}{
+		{"", http.StatusOK},
+		{common.DefaultTenant, http.StatusOK},
+		{otherTenant, http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/private/v1/tenants", nil)
+		if test.tenantID != "" {
+			r = r.WithContext(common.WithTenant(r.Context(), test.tenantID))
+		}
+		w := httptest.NewRecorder()
+		defaultTenantOnly(next).ServeHTTP(w, r)
+		if w.Code != test.wantStatus {
+			t.Errorf("got %d for tenant %q, want %d", w.Code, test.tenantID, test.wantStatus)
+		}
+	}
+}
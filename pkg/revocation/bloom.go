@@ -0,0 +1,45 @@
+package revocation
+
+import (
+	"hash/fnv"
+)
+
+// bloom is a fixed size bloom filter of strings, k positions derived by double hashing.
+type bloom struct {
+	bits []uint64
+	k    uint64
+}
+
+func newBloom(m, k uint64) *bloom {
+	return &bloom{bits: make([]uint64, (m+63)/64), k: k}
+}
+
+func (b *bloom) add(s string) {
+	h1, h2 := hashes(s)
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % m
+		b.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+func (b *bloom) test(s string) bool {
+	h1, h2 := hashes(s)
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % m
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func hashes(s string) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	h1 := h.Sum64()
+	h = fnv.New64()
+	_, _ = h.Write([]byte(s))
+	return h1, h.Sum64() | 1
+}
@@ -0,0 +1,199 @@
+package revocation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	bloomBits   = 1 << 23
+	bloomHashes = 5
+	lruSize     = 10000
+	// syncOverlap rereads a bit of the past on every sync not to miss rows committed out of order.
+	syncOverlap = 5 * time.Second
+)
+
+type Store interface {
+	RevokeToken(ctx context.Context, jti string, expires time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	// RevokedTokensSince returns the ids of not yet expired tokens revoked after since.
+	RevokedTokensSince(ctx context.Context, since time.Time) ([]string, error)
+	// DeleteRevokedTokens removes the revocations of tokens expired before the time.
+	DeleteRevokedTokens(ctx context.Context, expiredBefore time.Time) error
+	RevokeUser(ctx context.Context, uuid string, at time.Time) error
+	UsersRevokedSince(ctx context.Context, since time.Time) ([]models.RevokedUser, error)
+}
+
+type Config struct {
+	// TokenTTL is the longest an access token lives, older revocations of users don't matter.
+	TokenTTL time.Duration
+	// SyncInterval is how often revocations made by other instances are picked up.
+	SyncInterval time.Duration
+	// RebuildInterval is how often the bloom filter is rebuilt to forget expired tokens, their revocations are
+	// deleted from the store then.
+	RebuildInterval time.Duration
+}
+
+// Checker answers whether a token is revoked mostly from memory. A bloom filter of revoked token ids
+// rules out most tokens, the rest are looked up in the store through an LRU cache. Revocations of all tokens
+// of a user are kept in memory while tokens they affect may live.
+type Checker struct {
+	log      *logrus.Entry
+	store    Store
+	config   Config
+	mu       sync.RWMutex
+	filter   *bloom
+	cache    *lru
+	users    map[string]time.Time
+	lastSync time.Time
+}
+
+// NewChecker loads the current revocations from the store.
+func NewChecker(ctx context.Context, log *logrus.Logger, store Store, config Config) (*Checker, error) {
+	c := Checker{
+		log:    log.WithField("module", "revocation"),
+		store:  store,
+		config: config,
+		cache:  newLRU(lruSize),
+	}
+	if err := c.rebuild(ctx); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *Checker) IsRevoked(ctx context.Context, jti, uuid string, issuedAt time.Time) (bool, error) {
+	c.mu.RLock()
+	revokedAt, ok := c.users[uuid]
+	mayBeRevoked := c.filter.test(jti)
+	c.mu.RUnlock()
+	if ok && !issuedAt.After(revokedAt) {
+		return true, nil
+	}
+	if !mayBeRevoked {
+		return false, nil
+	}
+	c.mu.Lock()
+	revoked, ok := c.cache.get(jti)
+	c.mu.Unlock()
+	if ok {
+		return revoked, nil
+	}
+	revoked, err := c.store.IsTokenRevoked(ctx, jti)
+	if err != nil {
+		return false, fmt.Errorf("err checking token %s: %w", jti, err)
+	}
+	c.mu.Lock()
+	c.cache.put(jti, revoked)
+	c.mu.Unlock()
+	return revoked, nil
+}
+
+func (c *Checker) RevokeToken(ctx context.Context, jti string, expires time.Time) error {
+	if err := c.store.RevokeToken(ctx, jti, expires); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filter.add(jti)
+	c.cache.put(jti, true)
+	return nil
+}
+
+// RevokeUser revokes every token of the user issued so far. Tokens are told apart by the microsecond they were
+// issued at, the precision the stores keep, so those issued right after in the same second stay valid.
+func (c *Checker) RevokeUser(ctx context.Context, uuid string) error {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if err := c.store.RevokeUser(ctx, uuid, now); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users[uuid] = now
+	return nil
+}
+
+// Run picks up revocations made elsewhere until ctx is done.
+func (c *Checker) Run(ctx context.Context) {
+	syncTicker := time.NewTicker(c.config.SyncInterval)
+	defer syncTicker.Stop()
+	rebuildTicker := time.NewTicker(c.config.RebuildInterval)
+	defer rebuildTicker.Stop()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-syncTicker.C:
+			err = c.sync(ctx)
+		case <-rebuildTicker.C:
+			err = c.rebuild(ctx)
+		}
+		if err != nil {
+			c.log.Warnf("err syncing revocations: %v", err)
+		}
+	}
+}
+
+func (c *Checker) sync(ctx context.Context) error {
+	c.mu.RLock()
+	since := c.lastSync.Add(-syncOverlap)
+	c.mu.RUnlock()
+	started := time.Now().UTC()
+	tokens, err := c.store.RevokedTokensSince(ctx, since)
+	if err != nil {
+		return err
+	}
+	users, err := c.store.UsersRevokedSince(ctx, since)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, jti := range tokens {
+		c.filter.add(jti)
+		c.cache.remove(jti)
+	}
+	for _, u := range users {
+		if u.RevokedAt.After(c.users[u.UUID]) {
+			c.users[u.UUID] = u.RevokedAt
+		}
+	}
+	c.lastSync = started
+	return nil
+}
+
+func (c *Checker) rebuild(ctx context.Context) error {
+	started := time.Now().UTC()
+	if err := c.store.DeleteRevokedTokens(ctx, started); err != nil {
+		return fmt.Errorf("err deleting expired revocations: %w", err)
+	}
+	tokens, err := c.store.RevokedTokensSince(ctx, time.Time{})
+	if err != nil {
+		return err
+	}
+	users, err := c.store.UsersRevokedSince(ctx, started.Add(-c.config.TokenTTL))
+	if err != nil {
+		return err
+	}
+	filter := newBloom(bloomBits, bloomHashes)
+	for _, jti := range tokens {
+		filter.add(jti)
+	}
+	revokedUsers := make(map[string]time.Time, len(users))
+	for _, u := range users {
+		revokedUsers[u.UUID] = u.RevokedAt
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filter = filter
+	c.users = revokedUsers
+	c.cache = newLRU(lruSize)
+	c.lastSync = started
+	return nil
+}
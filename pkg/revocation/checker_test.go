@@ -0,0 +1,57 @@
+package revocation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/profilestore"
+	"github.com/sirupsen/logrus"
+)
+
+// TestRevokeUser checks that a user signing in again right after signing out gets a valid token.
+func TestRevokeUser(t *testing.T) {
+	ctx := context.Background()
+	store := profilestore.NewMemoryStore(ctx)
+	c, err := NewChecker(ctx, logrus.New(), store, Config{TokenTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tokens keep the time they were issued at to the microsecond
+	before := time.UnixMicro(time.Now().UnixMicro())
+	if err = c.RevokeUser(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	after := time.UnixMicro(time.Now().UnixMicro())
+
+	for _, test := range []struct {
+		issuedAt time.Time
+		want     bool
+	}{
+		{before, true},
+		{after, false},
+	} {
+		revoked, err := c.IsRevoked(ctx, "jti", "user", test.issuedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != test.want {
+			t.Errorf("token issued at %s revoked is %t, want %t", test.issuedAt, revoked, test.want)
+		}
+	}
+}
+
+func TestRebuildDeletesExpired(t *testing.T) {
+	ctx := context.Background()
+	store := profilestore.NewMemoryStore(ctx)
+	if err := store.RevokeToken(ctx, "expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewChecker(ctx, logrus.New(), store, Config{TokenTTL: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := store.IsTokenRevoked(ctx, "expired"); err != nil || revoked {
+		t.Fatalf("expired token revocation kept: %t, %v", revoked, err)
+	}
+}
@@ -0,0 +1,49 @@
+package revocation
+
+import "container/list"
+
+type lruEntry struct {
+	key   string
+	value bool
+}
+
+// lru remembers the last size answers of the store. Not safe for concurrent use.
+type lru struct {
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, order: list.New(), items: make(map[string]*list.Element, size)}
+}
+
+func (l *lru) get(key string) (bool, bool) {
+	e, ok := l.items[key]
+	if !ok {
+		return false, false
+	}
+	l.order.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true //nolint:forcetypeassert
+}
+
+func (l *lru) put(key string, value bool) {
+	if e, ok := l.items[key]; ok {
+		e.Value.(*lruEntry).value = value //nolint:forcetypeassert
+		l.order.MoveToFront(e)
+		return
+	}
+	l.items[key] = l.order.PushFront(&lruEntry{key: key, value: value})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruEntry).key) //nolint:forcetypeassert
+	}
+}
+
+func (l *lru) remove(key string) {
+	if e, ok := l.items[key]; ok {
+		l.order.Remove(e)
+		delete(l.items, key)
+	}
+}
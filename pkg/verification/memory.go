@@ -0,0 +1,94 @@
+package verification
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+)
+
+const memoryCleanupInterval = time.Minute
+
+type MemoryStore struct {
+	mu         sync.Mutex
+	challenges map[string]models.Challenge
+}
+
+// NewMemoryStore returns a Store that is dropped on restart. Expired challenges are purged until ctx is done.
+func NewMemoryStore(ctx context.Context) *MemoryStore {
+	m := MemoryStore{challenges: make(map[string]models.Challenge)}
+	go m.cleanup(ctx)
+	return &m
+}
+
+func (m *MemoryStore) GetChallenge(_ context.Context, phone string) (*models.Challenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	challenge, ok := m.challenges[phone]
+	if !ok {
+		return nil, common.ErrChallengeNotFound
+	}
+	return &challenge, nil
+}
+
+func (m *MemoryStore) SaveChallenge(_ context.Context, challenge *models.Challenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.challenges[challenge.Phone] = *challenge
+	return nil
+}
+
+// ClaimChallenge saves the challenge unless the phone has one created after createdBefore,
+// common.ErrResendTooSoon then.
+func (m *MemoryStore) ClaimChallenge(_ context.Context, challenge *models.Challenge, createdBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if prev, ok := m.challenges[challenge.Phone]; ok && prev.Created.After(createdBefore) {
+		return common.ErrResendTooSoon
+	}
+	m.challenges[challenge.Phone] = *challenge
+	return nil
+}
+
+func (m *MemoryStore) IncrementAttempts(_ context.Context, phone string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	challenge, ok := m.challenges[phone]
+	if !ok {
+		return 0, common.ErrChallengeNotFound
+	}
+	challenge.Attempts++
+	m.challenges[phone] = challenge
+	return challenge.Attempts, nil
+}
+
+func (m *MemoryStore) DeleteChallenge(_ context.Context, phone string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.challenges[phone]; !ok {
+		return common.ErrChallengeNotFound
+	}
+	delete(m.challenges, phone)
+	return nil
+}
+
+func (m *MemoryStore) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(memoryCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for phone, challenge := range m.challenges {
+				if now.UTC().After(challenge.Expires) {
+					delete(m.challenges, phone)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+type Store interface {
+	GetChallenge(ctx context.Context, phone string) (*models.Challenge, error)
+	SaveChallenge(ctx context.Context, challenge *models.Challenge) error
+	// ClaimChallenge saves the challenge unless the phone has one created after createdBefore,
+	// common.ErrResendTooSoon then.
+	ClaimChallenge(ctx context.Context, challenge *models.Challenge, createdBefore time.Time) error
+	IncrementAttempts(ctx context.Context, phone string) (int, error)
+	DeleteChallenge(ctx context.Context, phone string) error
+}
+
+type Config struct {
+	TTL            time.Duration
+	MaxAttempts    int
+	ResendCooldown time.Duration
+}
+
+// Sessions keeps track of the codes sent to phones: when they expire, how many
+// guesses were made and that each of them is used only once.
+type Sessions struct {
+	log    *logrus.Entry
+	store  Store
+	config Config
+}
+
+func New(log *logrus.Logger, store Store, config Config) *Sessions {
+	s := Sessions{
+		log:    log.WithField("module", "verification"),
+		store:  store,
+		config: config,
+	}
+	return &s
+}
+
+// Start opens a new challenge for the phone unless the previous one was issued less than
+// ResendCooldown ago. The challenge is claimed before send delivers the code through the channel, so that
+// concurrent requests send it once, and the previous one is put back if sending fails. The channel send
+// leaves in the challenge, naming the provider that sent the code, is saved.
+func (s *Sessions) Start(
+	ctx context.Context,
+	phone, channel string,
+	send func(ctx context.Context, challenge *models.Challenge) error,
+) error {
+	now := time.Now().UTC()
+	prev, err := s.store.GetChallenge(ctx, phone)
+	switch {
+	case err == nil:
+		if now.Before(prev.Created.Add(s.config.ResendCooldown)) {
+			return common.ErrResendTooSoon
+		}
+	case errors.Is(err, common.ErrChallengeNotFound):
+	default:
+		return fmt.Errorf("err getting challenge for %s: %w", phone, err)
+	}
+	challenge := models.Challenge{
+		Phone:   phone,
+		Channel: channel,
+		Created: now,
+		Expires: now.Add(s.config.TTL),
+	}
+	err = s.store.ClaimChallenge(ctx, &challenge, now.Add(-s.config.ResendCooldown))
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrResendTooSoon):
+		return err
+	default:
+		return fmt.Errorf("err saving challenge for %s: %w", phone, err)
+	}
+	if err = send(ctx, &challenge); err != nil {
+		s.release(ctx, phone, prev)
+		return err
+	}
+	if challenge.Channel != channel {
+		if err = s.store.SaveChallenge(ctx, &challenge); err != nil {
+			return fmt.Errorf("err saving challenge for %s: %w", phone, err)
+		}
+	}
+	return nil
+}
+
+// release undoes the claim of a challenge whose code wasn't sent, putting the previous one back if there was one.
+func (s *Sessions) release(ctx context.Context, phone string, prev *models.Challenge) {
+	var err error
+	if prev != nil {
+		err = s.store.SaveChallenge(ctx, prev)
+	} else if err = s.store.DeleteChallenge(ctx, phone); errors.Is(err, common.ErrChallengeNotFound) {
+		err = nil
+	}
+	if err != nil {
+		s.log.Warnf("err releasing challenge for %s: %v", phone, err)
+	}
+}
+
+// Verify spends an attempt of the phone's challenge on check and consumes the challenge if
+// check succeeds. A wrong code results in common.ErrInvalidCode.
+func (s *Sessions) Verify(
+	ctx context.Context, phone string, check func(ctx context.Context, challenge *models.Challenge) error,
+) error {
+	challenge, err := s.store.GetChallenge(ctx, phone)
+	if err != nil {
+		return err
+	}
+	if time.Now().UTC().After(challenge.Expires) {
+		s.discard(ctx, phone)
+		return common.ErrCodeExpired
+	}
+	attempts, err := s.store.IncrementAttempts(ctx, phone)
+	if err != nil {
+		return err
+	}
+	if attempts > s.config.MaxAttempts {
+		return common.ErrAttemptsExhausted
+	}
+	err = check(ctx, challenge)
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrUnauthenticated):
+		return common.ErrInvalidCode
+	case errors.Is(err, common.ErrCodeExpired), errors.Is(err, common.ErrAttemptsExhausted):
+		s.discard(ctx, phone)
+		return err
+	default:
+		return err
+	}
+	// whoever deletes the challenge first is the one who used it
+	return s.store.DeleteChallenge(ctx, phone)
+}
+
+func (s *Sessions) discard(ctx context.Context, phone string) {
+	if err := s.store.DeleteChallenge(ctx, phone); err != nil && !errors.Is(err, common.ErrChallengeNotFound) {
+		s.log.Warnf("err discarding challenge for %s: %v", phone, err)
+	}
+}
@@ -0,0 +1,97 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/authorization-service/pkg/common"
+	"github.com/gerladeno/authorization-service/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+const testPhone = "+79005556162"
+
+func newTestSessions(ctx context.Context) *Sessions {
+	return New(logrus.New(), NewMemoryStore(ctx), Config{TTL: time.Minute, MaxAttempts: 3, ResendCooldown: time.Minute})
+}
+
+// TestStartConcurrent checks that of concurrent requests for a code one sends it.
+func TestStartConcurrent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSessions(ctx)
+	var sent int32
+	// sending takes a while, the other requests come meanwhile
+	send := func(context.Context, *models.Challenge) error {
+		atomic.AddInt32(&sent, 1)
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Start(ctx, testPhone, "sms", send); err != nil && !errors.Is(err, common.ErrResendTooSoon) {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if sent != 1 {
+		t.Fatalf("code sent %d times, want once", sent)
+	}
+}
+
+// TestStartSendFails checks that a code that wasn't sent can be requested again right away and doesn't replace
+// the one sent before.
+func TestStartSendFails(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSessions(ctx)
+	errSend := errors.New("err sending")
+	fail := func(context.Context, *models.Challenge) error { return errSend }
+	if err := s.Start(ctx, testPhone, "sms", fail); !errors.Is(err, errSend) {
+		t.Fatalf("got %v, want %v", err, errSend)
+	}
+	if _, err := s.store.GetChallenge(ctx, testPhone); !errors.Is(err, common.ErrChallengeNotFound) {
+		t.Fatalf("got %v getting the challenge of a code not sent, want %v", err, common.ErrChallengeNotFound)
+	}
+	if err := s.Start(ctx, testPhone, "sms", func(context.Context, *models.Challenge) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	s.config.ResendCooldown = 0
+	if err := s.Start(ctx, testPhone, "flashcall", fail); !errors.Is(err, errSend) {
+		t.Fatalf("got %v, want %v", err, errSend)
+	}
+	challenge, err := s.store.GetChallenge(ctx, testPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge.Channel != "sms" {
+		t.Fatalf("got challenge of %s after failing to send, want the previous one of sms", challenge.Channel)
+	}
+}
+
+// TestStartProvider checks that the provider send names in the channel is kept for checking the code.
+func TestStartProvider(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSessions(ctx)
+	send := func(_ context.Context, challenge *models.Challenge) error {
+		challenge.SetProvider("sms")
+		return nil
+	}
+	if err := s.Start(ctx, testPhone, "auto", send); err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := s.store.GetChallenge(ctx, testPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if channel, provider := challenge.SplitChannel(); channel != "auto" || provider != "sms" {
+		t.Fatalf("got challenge of %s via %s, want auto via sms", channel, provider)
+	}
+}